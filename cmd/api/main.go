@@ -1,28 +1,178 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/health"
 	"github.com/grez-lucas/go-gym/pkg/http"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+	"github.com/grez-lucas/go-gym/pkg/logging"
+	"github.com/grez-lucas/go-gym/pkg/mail"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/ratelimit"
 	"github.com/grez-lucas/go-gym/pkg/storage"
+	"github.com/grez-lucas/go-gym/pkg/tracing"
 )
 
 func main() {
-	fmt.Println("Hello Go Gym Management!")
+	cfg, err := config.Load(os.Args[1:])
 
-	store, err := storage.NewPostgreSQLStore()
+	if errors.Is(err, config.ErrHelp) {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatal("Failed to load config", err)
+	}
+
+	logging.Setup(cfg, os.Stdout)
+
+	// Cancelled on Ctrl+C and on the SIGTERM sent by `docker stop`
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Once shutdown starts, a second signal kills the process right away
+	context.AfterFunc(ctx, stop)
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg)
 
 	if err != nil {
-		log.Fatal("Failed to create DB store ", err.Error())
+		fatal("Failed to set up tracing", err)
+	}
+
+	store, err := storage.NewPostgreSQLStore(cfg)
+
+	if err != nil {
+		fatal("Failed to create DB store", err)
 	}
 
 	if err := store.Init(); err != nil {
-		log.Fatalf("Failed to initialize DB store %s", err.Error())
+		fatal("Failed to initialize DB store", err)
+	}
+
+	metrics.RegisterDBStats(store.DB(), "postgres")
+
+	promoteAdmins(ctx, store, cfg.AdminUsernames)
+
+	keyManager, err := newKeyManager(cfg)
+
+	if err != nil {
+		fatal("Failed to load JWT signing keys", err)
+	}
+
+	go keyManager.Run(ctx)
+
+	mailer, err := mail.NewMailer(cfg)
+
+	if err != nil {
+		fatal("Failed to create mailer", err)
+	}
+
+	checks := newHealthChecks(cfg, store)
+
+	// The cache sits outside the instrumentation so storage metrics only
+	// count queries that actually reach the database
+	var api storage.Storage = storage.NewInstrumentedStore(store)
+
+	if cfg.StorageCacheEnabled {
+		api = storage.NewCachedStore(api, cfg.StorageCacheSize, cfg.StorageCacheTTL)
+	}
+
+	server, err := http.NewAPIServer(cfg.ListenAddr, api, cfg, keyManager, mailer, ratelimit.NewMemoryBackend(), checks)
+
+	if err != nil {
+		fatal("Failed to create server", err)
 	}
 
-	server := http.NewAPIServer(":8000", store)
-	server.Run()
+	runErr := server.Run(ctx)
+
+	if runErr != nil {
+		slog.Error("Server stopped with an error", "error", runErr)
+	}
+
+	if err := store.Close(); err != nil {
+		slog.Error("Error closing DB store", "error", err)
+	}
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", "error", err)
+	}
+
+	if runErr != nil {
+		os.Exit(1)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func promoteAdmins(ctx context.Context, store storage.Storage, usernames []string) {
+	for _, username := range usernames {
+		acc, err := store.GetAccountByUsername(ctx, username)
+
+		if err != nil {
+			slog.Error("Can't promote account to admin", "username", username, "error", err)
+			continue
+		}
+
+		if err := store.SetAccountRole(ctx, acc.ID, domain.RoleAdmin); err != nil {
+			slog.Error("Can't promote account to admin", "username", username, "error", err)
+		}
+	}
+}
+
+// The dependencies /readyz checks
+func newHealthChecks(cfg *config.Config, store *storage.PostgreSQLStore) *health.Registry {
+	checks := health.NewRegistry(cfg.HealthCheckTimeout, cfg.HealthCacheTTL)
+
+	checks.Register("postgres", store.Ping)
+	checks.Register("schema", store.CheckSchemaVersion)
+
+	minFree := uint64(cfg.HealthMinFreeDiskMB) << 20
+
+	if cfg.Mailer == "file" {
+		checks.Register("disk:mail", health.DiskSpace(cfg.MailDir, minFree))
+	}
+
+	if cfg.JWTKeysDir != "" {
+		checks.Register("disk:keys", health.DiskSpace(cfg.JWTKeysDir, minFree))
+	}
+
+	return checks
+}
+
+func newKeyManager(cfg *config.Config) (*keys.Manager, error) {
+	alg, err := keys.ParseAlgorithm(cfg.JWTSigningAlg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// A replaced key has to outlive every token it signed
+	gracePeriod := max(cfg.JWTKeyGracePeriod, cfg.AccessTokenTTL)
+
+	return keys.NewManager(keys.Options{
+		Algorithm:        alg,
+		Secret:           []byte(cfg.JWTSecret),
+		Dir:              cfg.JWTKeysDir,
+		RotationInterval: cfg.JWTKeyRotationInterval,
+		GracePeriod:      gracePeriod,
+	})
 }
 
 // TODO: Refactor app structure
@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// The least recently used entry goes first, and reading an entry counts as
+// using it
+func TestLRUEviction(t *testing.T) {
+	c := NewLRU[string, int](2, time.Hour)
+
+	c.Set("a", 1)
+	c.Set("b", 2)
+	c.Get("a")
+	c.Set("c", 3)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("b was kept, want it evicted as the least recently used")
+	}
+
+	for key, want := range map[string]int{"a": 1, "c": 3} {
+		if got, ok := c.Get(key); !ok || got != want {
+			t.Errorf("Get(%q) = %d, %v, want %d", key, got, ok, want)
+		}
+	}
+
+	if c.Len() != 2 {
+		t.Errorf("Got %d entries, want 2", c.Len())
+	}
+
+	// Overwriting doesn't grow the cache but does count as a use
+	c.Set("a", 10)
+	c.Set("d", 4)
+
+	if got, ok := c.Get("a"); !ok || got != 10 {
+		t.Errorf("Get(\"a\") = %d, %v, want 10", got, ok)
+	}
+
+	if _, ok := c.Get("c"); ok {
+		t.Error("c was kept, want it evicted")
+	}
+}
+
+func TestLRUTTL(t *testing.T) {
+	c := NewLRU[string, int](2, 20*time.Millisecond)
+
+	c.Set("a", 1)
+
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Fresh entry missing")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := c.Get("a"); ok {
+		t.Error("Expired entry was returned")
+	}
+
+	if c.Len() != 0 {
+		t.Errorf("Expired entry is still held, got %d entries", c.Len())
+	}
+
+	// Setting again restarts the clock
+	c.Set("a", 2)
+
+	if got, ok := c.Get("a"); !ok || got != 2 {
+		t.Errorf("Get(\"a\") = %d, %v, want 2", got, ok)
+	}
+}
+
+func TestLRUDelete(t *testing.T) {
+	c := NewLRU[int, string](2, time.Hour)
+
+	c.Set(1, "a")
+	c.Delete(1)
+	c.Delete(2)
+
+	if _, ok := c.Get(1); ok || c.Len() != 0 {
+		t.Errorf("Deleted entry still there, %d entries", c.Len())
+	}
+}
@@ -3,19 +3,165 @@ package config
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// Profiles. Development relaxes the checks in Validate and fills in insecure
+// defaults so the app runs without any setup.
+const (
+	ProfileDevelopment = "dev"
+	ProfileProduction  = "production"
+)
+
+// Only ever used in the dev profile
+const devJWTSecret = "examplesecret"
+
 type Config struct {
-	JWTSecret        string
+	// `dev` or `production`
+	Profile string
+	// `host:port`, or `unix:/path/to.sock` to listen on a Unix socket
+	ListenAddr string
+	// Where GET /metrics is served, apart from the API so it isn't public.
+	// Empty turns it off.
+	MetricsListenAddr string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// Serve HTTPS when both are set. Renewed certificates are picked up
+	// without a restart.
+	TLSCertFile            string
+	TLSKeyFile             string
+	JWTSecret              string
+	JWTIssuer              string
+	JWTSigningAlg          string
+	JWTKeysDir             string
+	JWTKeyRotationInterval time.Duration
+	JWTKeyGracePeriod      time.Duration
+	AccessTokenTTL         time.Duration
+	RefreshTokenTTL        time.Duration
+	// Origins of web frontends allowed to call the API, `*` for any
+	CORSAllowedOrigins   []string
+	CORSAllowCredentials bool
+	// How long browsers may cache preflight responses
+	CORSMaxAge time.Duration
+	// Read-through cache in front of the database for gyms and their ratings
+	StorageCacheEnabled bool
+	StorageCacheSize    int
+	StorageCacheTTL     time.Duration
+	// How long clients and CDNs may cache public data like gyms
+	CacheMaxAge time.Duration
+	// How long responses to requests with an Idempotency-Key are replayed
+	IdempotencyKeyTTL time.Duration
+	// Requests per minute and client, for login and signup, most routes, and
+	// public reads
+	RateLimitEnabled bool
+	RateLimitStrict  int
+	RateLimitDefault int
+	RateLimitRelaxed int
+	// Trust X-Real-IP / X-Forwarded-For, only when running behind a proxy
+	TrustProxyHeaders bool
+	// Accounts promoted to admin on startup
+	AdminUsernames []string
+	// Admin endpoints refuse admins without two-factor authentication
+	RequireAdmin2FA      bool
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+	// Base URL of the web app, used for links in emails
+	PublicURL string
+	// `log`, `file` or `smtp`
+	Mailer        string
+	MailFrom      string
+	MailDir       string
+	SMTPHost      string
+	SMTPPort      string
+	SMTPUsername  string
+	SMTPPassword  string
+	OIDCProviders []OIDCProviderConfig
+	// Serves a fake OpenID Connect provider under /mock-oidc, never in production
+	OIDCMockEnabled bool
+	// How long in flight requests get to finish once the server is asked to
+	// stop
+	ShutdownTimeout time.Duration
+	// How long to keep serving, reporting not ready, before draining starts.
+	// Gives load balancers time to stop sending new requests.
+	ShutdownDelay time.Duration
+	// Readiness checks that take longer count as failed
+	HealthCheckTimeout time.Duration
+	// How long readiness check results are reused
+	HealthCacheTTL time.Duration
+	// Directories we write to report not ready below this
+	HealthMinFreeDiskMB int
+	// `debug`, `info`, `warn` or `error`
+	LogLevel string
+	// `json` or `text`
+	LogFormat string
+	// `otlp`, `stdout`, `console` or `none`
+	TracingExporter string
+	// Share of new traces that are sampled, from 0 to 1
+	TracingSampleRatio float64
+	// A full connection string, either a `postgres://` URL or `key=value`
+	// pairs. Takes precedence over the DB_* settings below.
+	DatabaseURL      string
 	DatabaseUser     string
 	DatabasePassword string
 	DatabaseName     string
 	DatabaseHost     string
 	DatabasePort     string
+	// `disable`, `require`, `verify-ca` or `verify-full`
+	DatabaseSSLMode         string
+	DatabaseMaxOpenConns    int
+	DatabaseMaxIdleConns    int
+	DatabaseConnMaxLifetime time.Duration
+	DatabaseConnMaxIdleTime time.Duration
+}
+
+// Reads settings by their environment variable name from, highest precedence
+// first, command line flags, the environment and the config file. Problems
+// are collected instead of failing on the first one, so they can all be
+// reported at once.
+type loader struct {
+	flags map[string]string
+	file  map[string]string
+	// Every name looked up, to catch unknown keys in the file and flags
+	known map[string]bool
+	errs  []error
+}
+
+func (l *loader) lookup(varString string) (string, bool) {
+	l.known[varString] = true
+
+	if value, found := l.flags[varString]; found {
+		return value, true
+	}
+
+	// Empty variables count as unset, like the blank ones in .env.example
+	if value, found := os.LookupEnv(varString); found && value != "" {
+		return value, true
+	}
+
+	value, found := l.file[varString]
+
+	return value, found
 }
 
-func fetchEnv(varString string, fallbackString string) string {
-	env, found := os.LookupEnv(varString)
+func (l *loader) invalid(varString string, env string, want string) {
+	l.errs = append(l.errs, fmt.Errorf("%s: `%s` is not a valid %s", varString, env, want))
+}
+
+func (l *loader) fetchEnv(varString string, fallbackString string) string {
+	env, found := l.lookup(varString)
 
 	if !found {
 		return fallbackString
@@ -24,23 +170,277 @@ func fetchEnv(varString string, fallbackString string) string {
 	return env
 }
 
-func LoadConfig() *Config {
+func (l *loader) fetchBoolEnv(varString string, fallback bool) bool {
+	env, found := l.lookup(varString)
+
+	if !found {
+		return fallback
+	}
+
+	value, err := strconv.ParseBool(env)
+
+	if err != nil {
+		l.invalid(varString, env, "boolean")
+		return fallback
+	}
+
+	return value
+}
+
+// Durations use Go syntax, e.g. `15m` or `720h`
+func (l *loader) fetchDurationEnv(varString string, fallback time.Duration) time.Duration {
+	env, found := l.lookup(varString)
+
+	if !found {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(env)
+
+	if err != nil {
+		l.invalid(varString, env, "duration")
+		return fallback
+	}
+
+	return duration
+}
+
+func (l *loader) fetchIntEnv(varString string, fallback int) int {
+	env, found := l.lookup(varString)
+
+	if !found {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(env)
+
+	if err != nil {
+		l.invalid(varString, env, "integer")
+		return fallback
+	}
+
+	return value
+}
+
+func (l *loader) fetchFloatEnv(varString string, fallback float64) float64 {
+	env, found := l.lookup(varString)
+
+	if !found {
+		return fallback
+	}
+
+	value, err := strconv.ParseFloat(env, 64)
+
+	if err != nil {
+		l.invalid(varString, env, "number")
+		return fallback
+	}
+
+	return value
+}
+
+// Comma separated values, empty entries are dropped
+func (l *loader) fetchListEnv(varString string) []string {
+	values := []string{}
+
+	for _, value := range strings.Split(l.fetchEnv(varString, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// Loads the config from a JSON file, the environment and command line flags,
+// in increasing order of precedence, and validates it.
+//
+// The file is given with `-config` or CONFIG_FILE. Every setting is named
+// after its environment variable, in the file (`"JWT_ISSUER"` or
+// `"jwt_issuer"`) as well as in flags (`-jwt-issuer`).
+func Load(args []string) (*Config, error) {
+	flags, configFile, err := parseFlags(args)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l := &loader{flags: flags, known: map[string]bool{}}
+
+	if configFile == "" {
+		configFile = l.fetchEnv("CONFIG_FILE", "")
+	}
+
+	if configFile != "" {
+		if l.file, err = readConfigFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	profile := l.fetchEnv("APP_PROFILE", ProfileProduction)
+	isDev := profile == ProfileDevelopment
+
+	// Local databases rarely have TLS set up, anything else should
+	defaultSSLMode := "require"
+
+	if isDev {
+		defaultSSLMode = "disable"
+	}
+
 	config := &Config{
-		JWTSecret:        fetchEnv("JWT_SECRET", "examplesecret"),
-		DatabaseUser:     fetchEnv("DB_USER", "postgres"),
-		DatabasePassword: fetchEnv("DB_PASSWORD", "gogym"),
-		DatabaseName:     fetchEnv("DB_NAME", "postgres"),
-		DatabaseHost:     fetchEnv("DB_HOST", "localhost"),
-		DatabasePort:     fetchEnv("DB_PORT", "5432"),
+		Profile:           profile,
+		ListenAddr:        l.fetchEnv("LISTEN_ADDR", ":8000"),
+		MetricsListenAddr: l.fetchEnv("METRICS_LISTEN_ADDR", ":9090"),
+		ReadTimeout:       l.fetchDurationEnv("READ_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout: l.fetchDurationEnv("READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      l.fetchDurationEnv("WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       l.fetchDurationEnv("IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    l.fetchIntEnv("MAX_HEADER_BYTES", 64<<10),
+		TLSCertFile:       l.fetchEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:        l.fetchEnv("TLS_KEY_FILE", ""),
+		JWTSecret:         l.fetchEnv("JWT_SECRET", ""),
+		JWTIssuer:         l.fetchEnv("JWT_ISSUER", "go-gym"),
+		JWTSigningAlg:     l.fetchEnv("JWT_SIGNING_ALG", "RS256"),
+		JWTKeysDir:        l.fetchEnv("JWT_KEYS_DIR", ""),
+		// A new key every week, the old one keeps verifying for a day
+		JWTKeyRotationInterval:  l.fetchDurationEnv("JWT_KEY_ROTATION_INTERVAL", 7*24*time.Hour),
+		JWTKeyGracePeriod:       l.fetchDurationEnv("JWT_KEY_GRACE_PERIOD", 24*time.Hour),
+		AccessTokenTTL:          l.fetchDurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		RefreshTokenTTL:         l.fetchDurationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		CORSAllowedOrigins:      l.fetchListEnv("CORS_ALLOWED_ORIGINS"),
+		CORSAllowCredentials:    l.fetchBoolEnv("CORS_ALLOW_CREDENTIALS", false),
+		CORSMaxAge:              l.fetchDurationEnv("CORS_MAX_AGE", 10*time.Minute),
+		StorageCacheEnabled:     l.fetchBoolEnv("STORAGE_CACHE_ENABLED", true),
+		StorageCacheSize:        l.fetchIntEnv("STORAGE_CACHE_SIZE", 1000),
+		StorageCacheTTL:         l.fetchDurationEnv("STORAGE_CACHE_TTL", 30*time.Second),
+		CacheMaxAge:             l.fetchDurationEnv("CACHE_MAX_AGE", time.Minute),
+		IdempotencyKeyTTL:       l.fetchDurationEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour),
+		RateLimitEnabled:        l.fetchBoolEnv("RATE_LIMIT_ENABLED", true),
+		RateLimitStrict:         l.fetchIntEnv("RATE_LIMIT_STRICT", 10),
+		RateLimitDefault:        l.fetchIntEnv("RATE_LIMIT_DEFAULT", 120),
+		RateLimitRelaxed:        l.fetchIntEnv("RATE_LIMIT_RELAXED", 600),
+		TrustProxyHeaders:       l.fetchBoolEnv("TRUST_PROXY_HEADERS", false),
+		AdminUsernames:          l.fetchListEnv("ADMIN_USERNAMES"),
+		RequireAdmin2FA:         l.fetchBoolEnv("REQUIRE_ADMIN_2FA", false),
+		PasswordResetTTL:        l.fetchDurationEnv("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL:    l.fetchDurationEnv("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+		PublicURL:               l.fetchEnv("PUBLIC_URL", "http://localhost:8000"),
+		Mailer:                  l.fetchEnv("MAILER", "log"),
+		MailFrom:                l.fetchEnv("MAIL_FROM", "Go Gym <no-reply@localhost>"),
+		MailDir:                 l.fetchEnv("MAIL_DIR", "./mail"),
+		SMTPHost:                l.fetchEnv("SMTP_HOST", "localhost"),
+		SMTPPort:                l.fetchEnv("SMTP_PORT", "587"),
+		SMTPUsername:            l.fetchEnv("SMTP_USERNAME", ""),
+		SMTPPassword:            l.fetchEnv("SMTP_PASSWORD", ""),
+		OIDCProviders:           l.loadOIDCProviders(),
+		OIDCMockEnabled:         l.fetchBoolEnv("OIDC_MOCK_ENABLED", false),
+		ShutdownTimeout:         l.fetchDurationEnv("SHUTDOWN_TIMEOUT", 15*time.Second),
+		ShutdownDelay:           l.fetchDurationEnv("SHUTDOWN_DELAY", 0),
+		HealthCheckTimeout:      l.fetchDurationEnv("HEALTH_CHECK_TIMEOUT", 2*time.Second),
+		HealthCacheTTL:          l.fetchDurationEnv("HEALTH_CACHE_TTL", 5*time.Second),
+		HealthMinFreeDiskMB:     l.fetchIntEnv("HEALTH_MIN_FREE_DISK_MB", 100),
+		LogLevel:                l.fetchEnv("LOG_LEVEL", "info"),
+		LogFormat:               l.fetchEnv("LOG_FORMAT", "json"),
+		TracingExporter:         l.fetchEnv("OTEL_TRACES_EXPORTER", "none"),
+		TracingSampleRatio:      l.fetchFloatEnv("TRACES_SAMPLE_RATIO", 1),
+		DatabaseURL:             l.fetchEnv("DATABASE_URL", ""),
+		DatabaseUser:            l.fetchEnv("DB_USER", "postgres"),
+		DatabasePassword:        l.fetchEnv("DB_PASSWORD", ""),
+		DatabaseName:            l.fetchEnv("DB_NAME", "postgres"),
+		DatabaseHost:            l.fetchEnv("DB_HOST", "localhost"),
+		DatabasePort:            l.fetchEnv("DB_PORT", "5432"),
+		DatabaseSSLMode:         l.fetchEnv("DB_SSLMODE", defaultSSLMode),
+		DatabaseMaxOpenConns:    l.fetchIntEnv("DB_MAX_OPEN_CONNS", 25),
+		DatabaseMaxIdleConns:    l.fetchIntEnv("DB_MAX_IDLE_CONNS", 25),
+		DatabaseConnMaxLifetime: l.fetchDurationEnv("DB_CONN_MAX_LIFETIME", 30*time.Minute),
+		DatabaseConnMaxIdleTime: l.fetchDurationEnv("DB_CONN_MAX_IDLE_TIME", 5*time.Minute),
+	}
+
+	if isDev {
+		config.applyDevDefaults()
 	}
 
-	return config
+	l.passThrough(passThroughSettings...)
+	l.checkUnknown()
+
+	if errs := append(l.errs, config.problems()...); len(errs) > 0 {
+		return nil, configError(errs)
+	}
+
+	return config, nil
+}
+
+// Settings the OpenTelemetry SDK reads from the environment itself
+var passThroughSettings = []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_SERVICE_NAME"}
+
+// Copies settings given as flags or in the config file to the environment,
+// for libraries that only look there
+func (l *loader) passThrough(names ...string) {
+	for _, name := range names {
+		value, found := l.lookup(name)
+
+		if !found {
+			continue
+		}
+
+		if err := os.Setenv(name, value); err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+}
+
+// Insecure settings that make `make run` work against the docker-compose DB
+func (c *Config) applyDevDefaults() {
+	if c.JWTSecret == "" {
+		c.JWTSecret = devJWTSecret
+	}
+
+	if c.DatabasePassword == "" && c.DatabaseURL == "" {
+		c.DatabasePassword = "gogym"
+	}
+}
+
+// Providers are listed in OIDC_PROVIDERS, each configured through
+// OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET and
+// optionally OIDC_<NAME>_SCOPES
+func (l *loader) loadOIDCProviders() []OIDCProviderConfig {
+	providers := []OIDCProviderConfig{}
+
+	for _, name := range l.fetchListEnv("OIDC_PROVIDERS") {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+
+		providers = append(providers, OIDCProviderConfig{
+			Name:         strings.ToLower(name),
+			Issuer:       l.fetchEnv(prefix+"ISSUER", ""),
+			ClientID:     l.fetchEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: l.fetchEnv(prefix+"CLIENT_SECRET", ""),
+			Scopes:       l.fetchListEnv(prefix + "SCOPES"),
+		})
+	}
+
+	return providers
 }
 
+// Connection string for lib/pq. DATABASE_URL wins when set.
 func (c *Config) PostgreSQLConnStr() string {
+	if c.DatabaseURL != "" {
+		return c.DatabaseURL
+	}
+
 	return fmt.Sprintf(
-		"host=%s user=%s dbname=%s password=%s sslmode=disable",
-		c.DatabaseHost, c.DatabaseUser, c.DatabaseName, c.DatabasePassword,
+		"host=%s port=%s user=%s dbname=%s password=%s sslmode=%s",
+		connStrValue(c.DatabaseHost),
+		connStrValue(c.DatabasePort),
+		connStrValue(c.DatabaseUser),
+		connStrValue(c.DatabaseName),
+		connStrValue(c.DatabasePassword),
+		connStrValue(c.DatabaseSSLMode),
 	)
+}
+
+// Quotes values so passwords with spaces or quotes survive
+func connStrValue(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
 
+	return "'" + replacer.Replace(value) + "'"
 }
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Returned by Load for `-h` and `-help`
+var ErrHelp = errors.New("Usage: gogym [-config file.json] [-setting-name=value ...], settings are named after their environment variables")
+
+// Parses `-name=value`, `-name value` and, for booleans, a bare `-name`.
+// Negative numbers are values, so `-offset -5` sets OFFSET to -5.
+// Names are turned into environment variable names: `-db-host` sets DB_HOST.
+// `-config` is special and names the config file.
+func parseFlags(args []string) (map[string]string, string, error) {
+	flags := map[string]string{}
+	configFile := ""
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return nil, "", fmt.Errorf("Unexpected argument `%s`", arg)
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+
+		if name == "h" || name == "help" {
+			return nil, "", ErrHelp
+		}
+
+		if !hasValue {
+			if i+1 < len(args) && !isFlag(args[i+1]) {
+				i++
+				value = args[i]
+			} else {
+				value = "true"
+			}
+		}
+
+		if name == "config" {
+			configFile = value
+			continue
+		}
+
+		flags[settingName(name)] = value
+	}
+
+	return flags, configFile, nil
+}
+
+func isFlag(arg string) bool {
+	if !strings.HasPrefix(arg, "-") {
+		return false
+	}
+
+	_, err := strconv.ParseFloat(arg, 64)
+
+	return err != nil
+}
+
+// Reads a flat JSON object. Lists may be given as arrays, everything else as
+// the same string the environment variable would hold, numbers and booleans
+// are accepted as well.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error reading config file: %w", err)
+	}
+
+	var raw map[string]any
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("Error parsing config file %s: %w", path, err)
+	}
+
+	settings := map[string]string{}
+
+	for key, value := range raw {
+		str, err := settingValue(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing config file %s: %s %w", path, key, err)
+		}
+
+		settings[settingName(key)] = str
+	}
+
+	return settings, nil
+}
+
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, 0, len(v))
+
+		for _, item := range v {
+			str, err := settingValue(item)
+
+			if err != nil {
+				return "", err
+			}
+
+			items = append(items, str)
+		}
+
+		return strings.Join(items, ","), nil
+	}
+
+	return "", fmt.Errorf("must be a string, number, boolean or list")
+}
+
+// `db-host`, `db.host` and `db_host` all become DB_HOST
+func settingName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// Unknown names in the file or flags are most likely typos, which would
+// otherwise silently leave the default in place
+func (l *loader) checkUnknown() {
+	sources := []struct {
+		name     string
+		settings map[string]string
+	}{
+		{"flag", l.flags},
+		{"config file setting", l.file},
+	}
+
+	for _, source := range sources {
+		var unknown []string
+
+		for name := range source.settings {
+			if !l.known[name] {
+				unknown = append(unknown, name)
+			}
+		}
+
+		slices.Sort(unknown)
+
+		for _, name := range unknown {
+			l.errs = append(l.errs, fmt.Errorf("Unknown %s %s", source.name, name))
+		}
+	}
+}
+
+func configError(errs []error) error {
+	messages := make([]string, 0, len(errs))
+
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Errorf("Invalid config: %s", strings.Join(messages, "; "))
+}
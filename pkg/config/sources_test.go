@@ -0,0 +1,103 @@
+package config
+
+import (
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		flags      map[string]string
+		configFile string
+	}{
+		{"equals", []string{"-db-host=db"}, map[string]string{"DB_HOST": "db"}, ""},
+		{"separate value", []string{"-db-host", "db"}, map[string]string{"DB_HOST": "db"}, ""},
+		{"double dash", []string{"--db-host", "db"}, map[string]string{"DB_HOST": "db"}, ""},
+		{"bare boolean", []string{"-cors-allow-credentials", "-db-host", "db"}, map[string]string{"CORS_ALLOW_CREDENTIALS": "true", "DB_HOST": "db"}, ""},
+		{"trailing boolean", []string{"-trust-proxy-headers"}, map[string]string{"TRUST_PROXY_HEADERS": "true"}, ""},
+		{"negative number", []string{"-shutdown-delay", "-5", "-max-header-bytes", "-1.5"}, map[string]string{"SHUTDOWN_DELAY": "-5", "MAX_HEADER_BYTES": "-1.5"}, ""},
+		{"dots", []string{"-db.host=db"}, map[string]string{"DB_HOST": "db"}, ""},
+		{"config file", []string{"-config", "gogym.json", "-db-host=db"}, map[string]string{"DB_HOST": "db"}, "gogym.json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, configFile, err := parseFlags(tt.args)
+
+			if err != nil {
+				t.Fatalf("Error parsing %q: %v", tt.args, err)
+			}
+
+			if !maps.Equal(flags, tt.flags) || configFile != tt.configFile {
+				t.Errorf("Got %v and config file %q, want %v and %q", flags, configFile, tt.flags, tt.configFile)
+			}
+		})
+	}
+}
+
+func TestParseFlagsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"stray argument", []string{"-db-host=db", "db"}},
+		{"lone dash", []string{"-"}},
+		{"end of flags", []string{"--"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := parseFlags(tt.args); err == nil {
+				t.Errorf("Parsed %q, want an error", tt.args)
+			}
+		})
+	}
+
+	for _, args := range [][]string{{"-h"}, {"--help"}} {
+		if _, _, err := parseFlags(args); !errors.Is(err, ErrHelp) {
+			t.Errorf("Got %v for %q, want ErrHelp", err, args)
+		}
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `{"db_host": "db", "db-port": 5433, "cors_allow_credentials": true, "CORS_ALLOWED_ORIGINS": ["https://a.example", "https://b.example"]}`)
+
+	settings, err := readConfigFile(path)
+
+	if err != nil {
+		t.Fatalf("Error reading config file: %v", err)
+	}
+
+	want := map[string]string{
+		"DB_HOST":                "db",
+		"DB_PORT":                "5433",
+		"CORS_ALLOW_CREDENTIALS": "true",
+		"CORS_ALLOWED_ORIGINS":   "https://a.example,https://b.example",
+	}
+
+	if !maps.Equal(settings, want) {
+		t.Errorf("Got %v, want %v", settings, want)
+	}
+
+	if _, err := readConfigFile(writeConfigFile(t, `{"db_host": {"name": "db"}}`)); err == nil {
+		t.Error("Read an object setting, want an error")
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "gogym.json")
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+
+	return path
+}
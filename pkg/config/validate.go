@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// HS256 secrets shorter than this can be brute forced from a single token
+const minJWTSecretBytes = 32
+
+// Secrets that have been committed to this repo at some point
+var knownWeakSecrets = []string{devJWTSecret, "secret", "changeme", "gogym"}
+
+// Checks the settings make sense together. Outside the dev profile it also
+// refuses weak secrets and features meant for local use only.
+func (c *Config) Validate() error {
+	if errs := c.problems(); len(errs) > 0 {
+		return configError(errs)
+	}
+
+	return nil
+}
+
+func (c *Config) problems() []error {
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	isDev := c.Profile == ProfileDevelopment
+
+	check(isDev || c.Profile == ProfileProduction, "APP_PROFILE must be `%s` or `%s`", ProfileDevelopment, ProfileProduction)
+
+	check(c.ListenAddr != "", "LISTEN_ADDR is required")
+	// Every connection on a socket comes from the proxy, only its headers tell
+	// clients apart for rate limits and login lockouts
+	check(!strings.HasPrefix(c.ListenAddr, "unix:") || c.TrustProxyHeaders, "TRUST_PROXY_HEADERS is required when LISTEN_ADDR is a unix: socket")
+	check(c.MetricsListenAddr == "" || c.MetricsListenAddr != c.ListenAddr, "METRICS_LISTEN_ADDR must differ from LISTEN_ADDR")
+	check(c.ReadTimeout >= 0 && c.ReadHeaderTimeout >= 0 && c.WriteTimeout >= 0 && c.IdleTimeout >= 0, "Server timeouts can't be negative")
+	check(c.MaxHeaderBytes > 0, "MAX_HEADER_BYTES must be positive")
+	check((c.TLSCertFile == "") == (c.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be positive")
+	check(c.ShutdownDelay >= 0, "SHUTDOWN_DELAY can't be negative")
+
+	check(c.HealthCheckTimeout > 0, "HEALTH_CHECK_TIMEOUT must be positive")
+	check(c.HealthCacheTTL >= 0 && c.HealthMinFreeDiskMB >= 0, "Health check settings can't be negative")
+
+	check(slices.Contains([]string{"HS256", "RS256", "EdDSA"}, c.JWTSigningAlg), "JWT_SIGNING_ALG must be HS256, RS256 or EdDSA")
+
+	if c.JWTSigningAlg == "HS256" {
+		check(c.JWTSecret != "", "JWT_SECRET is required with HS256")
+
+		if !isDev && c.JWTSecret != "" {
+			check(len(c.JWTSecret) >= minJWTSecretBytes, "JWT_SECRET must be at least %d bytes long", minJWTSecretBytes)
+			check(!slices.Contains(knownWeakSecrets, c.JWTSecret), "JWT_SECRET is a well known example value")
+		}
+	}
+
+	check(!c.CORSAllowCredentials || !slices.Contains(c.CORSAllowedOrigins, "*"), "CORS_ALLOW_CREDENTIALS can't be used with `*` in CORS_ALLOWED_ORIGINS")
+	check(c.CORSMaxAge >= 0, "CORS_MAX_AGE can't be negative")
+
+	check(c.StorageCacheSize > 0 && c.StorageCacheTTL > 0, "STORAGE_CACHE_SIZE and STORAGE_CACHE_TTL must be positive")
+	check(c.CacheMaxAge >= 0, "CACHE_MAX_AGE can't be negative")
+	check(c.IdempotencyKeyTTL > 0, "IDEMPOTENCY_KEY_TTL must be positive")
+	check(c.RateLimitStrict > 0 && c.RateLimitDefault > 0 && c.RateLimitRelaxed > 0, "Rate limits must be positive")
+
+	check(c.AccessTokenTTL > 0 && c.RefreshTokenTTL > 0, "Token TTLs must be positive")
+	check(c.PasswordResetTTL > 0 && c.EmailVerificationTTL > 0, "Account token TTLs must be positive")
+
+	publicURL, err := url.Parse(c.PublicURL)
+	check(err == nil && publicURL.IsAbs(), "PUBLIC_URL must be an absolute URL")
+
+	check(slices.Contains([]string{"log", "file", "smtp"}, c.Mailer), "MAILER must be log, file or smtp")
+
+	for _, provider := range c.OIDCProviders {
+		check(provider.Issuer != "" && provider.ClientID != "", "OIDC provider %s needs an issuer and a client ID", provider.Name)
+	}
+
+	check(isDev || !c.OIDCMockEnabled, "OIDC_MOCK_ENABLED is only allowed in the dev profile")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "LOG_LEVEL must be debug, info, warn or error")
+	check(c.LogFormat == "json" || c.LogFormat == "text", "LOG_FORMAT must be json or text")
+
+	check(slices.Contains([]string{"otlp", "stdout", "console", "none"}, c.TracingExporter), "OTEL_TRACES_EXPORTER must be otlp, stdout, console or none")
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "TRACES_SAMPLE_RATIO must be between 0 and 1")
+
+	if c.DatabaseURL == "" {
+		check(slices.Contains([]string{"disable", "require", "verify-ca", "verify-full"}, c.DatabaseSSLMode), "DB_SSLMODE must be disable, require, verify-ca or verify-full")
+
+		if !isDev {
+			check(c.DatabasePassword != "", "DB_PASSWORD is required, or set DATABASE_URL")
+			check(!slices.Contains(knownWeakSecrets, c.DatabasePassword), "DB_PASSWORD is a well known example value")
+		}
+	}
+
+	check(c.DatabaseMaxOpenConns >= 0 && c.DatabaseMaxIdleConns >= 0, "DB connection limits can't be negative")
+	check(c.DatabaseConnMaxLifetime >= 0 && c.DatabaseConnMaxIdleTime >= 0, "DB connection lifetimes can't be negative")
+
+	return errs
+}
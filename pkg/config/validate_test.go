@@ -0,0 +1,92 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func validConfig(profile string) *Config {
+	return &Config{
+		Profile:              profile,
+		ListenAddr:           ":8000",
+		MaxHeaderBytes:       1 << 16,
+		ShutdownTimeout:      1,
+		HealthCheckTimeout:   1,
+		JWTSigningAlg:        "HS256",
+		JWTSecret:            strings.Repeat("s", minJWTSecretBytes),
+		StorageCacheSize:     1,
+		StorageCacheTTL:      1,
+		IdempotencyKeyTTL:    1,
+		RateLimitStrict:      1,
+		RateLimitDefault:     1,
+		RateLimitRelaxed:     1,
+		AccessTokenTTL:       1,
+		RefreshTokenTTL:      1,
+		PasswordResetTTL:     1,
+		EmailVerificationTTL: 1,
+		PublicURL:            "https://gym.example",
+		Mailer:               "log",
+		LogLevel:             "info",
+		LogFormat:            "json",
+		TracingExporter:      "none",
+		DatabasePassword:     "not-a-known-password",
+		DatabaseSSLMode:      "require",
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		// Empty when valid in both profiles
+		production string
+		dev        string
+	}{
+		{"valid", func(c *Config) {}, "", ""},
+		{"short JWT secret", func(c *Config) { c.JWTSecret = "short" }, "JWT_SECRET must be at least 32 bytes long", ""},
+		{"example JWT secret", func(c *Config) { c.JWTSecret = devJWTSecret }, "JWT_SECRET is a well known example value", ""},
+		{"missing JWT secret", func(c *Config) { c.JWTSecret = "" }, "JWT_SECRET is required with HS256", "JWT_SECRET is required with HS256"},
+		{"JWT secret unused", func(c *Config) { c.JWTSigningAlg = "EdDSA"; c.JWTSecret = "short" }, "", ""},
+		{"missing DB password", func(c *Config) { c.DatabasePassword = "" }, "DB_PASSWORD is required, or set DATABASE_URL", ""},
+		{"known DB password", func(c *Config) { c.DatabasePassword = "gogym" }, "DB_PASSWORD is a well known example value", ""},
+		{"DB password unused", func(c *Config) { c.DatabaseURL = "postgres://db/gym"; c.DatabasePassword = "" }, "", ""},
+		{"mock OIDC", func(c *Config) { c.OIDCMockEnabled = true }, "OIDC_MOCK_ENABLED is only allowed in the dev profile", ""},
+		{"console exporter", func(c *Config) { c.TracingExporter = "console" }, "", ""},
+		{"unknown exporter", func(c *Config) { c.TracingExporter = "jaeger" }, "OTEL_TRACES_EXPORTER must be otlp, stdout, console or none", "OTEL_TRACES_EXPORTER must be otlp, stdout, console or none"},
+		{"unix socket", func(c *Config) { c.ListenAddr = "unix:/run/gogym.sock" }, "TRUST_PROXY_HEADERS is required", "TRUST_PROXY_HEADERS is required"},
+		{"unix socket behind a proxy", func(c *Config) { c.ListenAddr = "unix:/run/gogym.sock"; c.TrustProxyHeaders = true }, "", ""},
+		{"metrics on the API address", func(c *Config) { c.MetricsListenAddr = c.ListenAddr }, "METRICS_LISTEN_ADDR must differ from LISTEN_ADDR", "METRICS_LISTEN_ADDR must differ from LISTEN_ADDR"},
+		{"credentials with any origin", func(c *Config) { c.CORSAllowCredentials = true; c.CORSAllowedOrigins = []string{"*"} }, "CORS_ALLOW_CREDENTIALS", "CORS_ALLOW_CREDENTIALS"},
+	}
+
+	for _, tt := range tests {
+		for profile, want := range map[string]string{ProfileProduction: tt.production, ProfileDevelopment: tt.dev} {
+			t.Run(tt.name+" "+profile, func(t *testing.T) {
+				config := validConfig(profile)
+				tt.modify(config)
+
+				err := config.Validate()
+
+				if want == "" {
+					if err != nil {
+						t.Errorf("Got %v, want no error", err)
+					}
+
+					return
+				}
+
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("Got %v, want %q", err, want)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateProfile(t *testing.T) {
+	err := validConfig("staging").Validate()
+
+	if err == nil || !strings.Contains(err.Error(), "APP_PROFILE must be `dev` or `production`") {
+		t.Errorf("Got %v, want an APP_PROFILE error", err)
+	}
+}
@@ -5,23 +5,62 @@ import (
 )
 
 type CreateAccountRequest struct {
-	UserName string `json:"userName"`
-	Password string `json:"password"`
+	UserName string `json:"userName" validate:"required,min=3,max=100,username"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,password"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required"`
 }
 
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type Account struct {
-	ID        int       `json:"id"`
-	UserName  string    `json:"userName"`
-	Password  string    `json:"password"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID       int    `json:"id"`
+	UserName string `json:"userName"`
+	// bcrypt hash, never sent to clients
+	Password string `json:"-"`
+	Role     string `json:"role"`
+	// Only set once verified, until then the address lives in its
+	// verification token. Accounts created before emails were required have
+	// none.
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// Set as soon as TOTP enrollment starts, but only used once confirmed
+	TOTPSecret    string     `json:"-"`
+	TOTPEnabledAt *time.Time `json:"totpEnabledAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
 }
 
+// Emails are added by VerifyAccountEmail once the owner proves they have it
 func NewAccount(userName string, password string) *Account {
 	return &Account{
 		UserName:  userName,
 		Password:  password,
+		Role:      RoleUser,
 		CreatedAt: time.Now().UTC(),
 		UpdatedAt: time.Now().UTC(),
 	}
 }
+
+func (a *Account) IsAdmin() bool {
+	return a.Role == RoleAdmin
+}
+
+func (a *Account) IsTOTPEnabled() bool {
+	return a.TOTPSecret != "" && a.TOTPEnabledAt != nil
+}
+
+func (a *Account) IsEmailVerified() bool {
+	return a.Email != "" && a.EmailVerifiedAt != nil
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"time"
+)
+
+// What an AccountToken can be redeemed for
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeVerifyEmail   = "verify_email"
+)
+
+// A single use token emailed to the owner of an account, e.g. for password
+// resets. Like refresh tokens only the hash is stored.
+type AccountToken struct {
+	ID        int    `json:"id"`
+	AccountID int    `json:"accountId"`
+	Purpose   string `json:"purpose"`
+	TokenHash string `json:"-"`
+	// The address being verified, for PurposeVerifyEmail
+	Email     string     `json:"email"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func NewAccountToken(accountID int, purpose string, tokenHash string, ttl time.Duration) *AccountToken {
+	now := time.Now().UTC()
+
+	return &AccountToken{
+		AccountID: accountID,
+		Purpose:   purpose,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+// Either field identifies the account
+type ForgotPasswordRequest struct {
+	Username string `json:"username" validate:"max=100"`
+	Email    string `json:"email" validate:"max=254"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required,password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,password"`
+}
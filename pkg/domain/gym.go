@@ -5,17 +5,34 @@ import (
 )
 
 type CreateGymRequest struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=2000"`
+}
+
+type UpdateGymRequest struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=2000"`
 }
 
 type Gym struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Rating      float32   `json:"rating"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Rating      float32 `json:"rating"`
+	RatingCount int     `json:"ratingCount"`
+	// When the newest rating was posted or changed, nil without ratings
+	LastRatedAt *time.Time `json:"lastRatedAt"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// The gym changes with its ratings, since it shows their average
+func (g *Gym) LastModified() time.Time {
+	if g.LastRatedAt != nil && g.LastRatedAt.After(g.UpdatedAt) {
+		return *g.LastRatedAt
+	}
+
+	return g.UpdatedAt
 }
 
 func NewGym(name string, description string) *Gym {
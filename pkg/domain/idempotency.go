@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"time"
+)
+
+// The response to a request sent with an Idempotency-Key, replayed when the
+// client retries it. ResponseStatus stays zero while the first request is
+// still being handled.
+type IdempotencyKey struct {
+	ID int `json:"id"`
+	// Who sent the key, like `account:42`. Keys of different clients never
+	// collide.
+	Scope string `json:"scope"`
+	Key   string `json:"key"`
+	// SHA-256 of the method, route and body, so reusing a key for another
+	// request can be caught
+	RequestHash         string    `json:"-"`
+	ResponseStatus      int       `json:"responseStatus"`
+	ResponseContentType string    `json:"responseContentType"`
+	ResponseBody        []byte    `json:"-"`
+	ExpiresAt           time.Time `json:"expiresAt"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+func NewIdempotencyKey(scope string, key string, requestHash string, ttl time.Duration) *IdempotencyKey {
+	now := time.Now().UTC()
+
+	return &IdempotencyKey{
+		Scope:       scope,
+		Key:         key,
+		RequestHash: requestHash,
+		ExpiresAt:   now.Add(ttl),
+		CreatedAt:   now,
+	}
+}
+
+func (k *IdempotencyKey) Completed() bool {
+	return k.ResponseStatus != 0
+}
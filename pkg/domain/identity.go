@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"time"
+)
+
+// Links an account to a user of an external OpenID Connect provider
+type Identity struct {
+	ID        int       `json:"id"`
+	AccountID int       `json:"accountId"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewIdentity(accountID int, provider string, subject string, email string) *Identity {
+	return &Identity{
+		AccountID: accountID,
+		Provider:  provider,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,19 @@
+package domain
+
+import (
+	"time"
+)
+
+// Failed login bookkeeping for one key, which is either a username or a
+// client IP. Both are tracked whether or not an account exists, so lockouts
+// don't reveal which usernames are taken.
+type LoginAttempts struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil"`
+}
+
+func (a *LoginAttempts) IsLocked() bool {
+	return a.LockedUntil != nil && time.Now().UTC().Before(*a.LockedUntil)
+}
@@ -5,13 +5,18 @@ import (
 )
 
 type CreateRatingRequest struct {
-	Rating int    `json:"rating"`
-	Review string `json:"review"`
+	Rating int    `json:"rating" validate:"required,min=1,max=5"`
+	Review string `json:"review" validate:"max=2000"`
 }
 
+// Shown instead of the author once they delete their account
+const DeletedUserName = "[deleted]"
+
 type Rating struct {
-	ID        int       `json:"id"`
-	GymID     int       `json:"gymId"`
+	ID    int `json:"id"`
+	GymID int `json:"gymId"`
+	// Zero once the author deleted their account
+	AccountID int       `json:"accountId"`
 	Rating    int       `json:"rating"`
 	UserName  string    `json:"userName"`
 	Review    string    `json:"review"`
@@ -19,9 +24,10 @@ type Rating struct {
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
-func NewRating(gymID int, rating int, userName string, review string) *Rating {
+func NewRating(gymID int, accountID int, rating int, userName string, review string) *Rating {
 	return &Rating{
 		GymID:     gymID,
+		AccountID: accountID,
 		Rating:    rating,
 		UserName:  userName,
 		Review:    review,
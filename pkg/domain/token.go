@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"time"
+)
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+// A RefreshToken is the server side record of an issued refresh token. Only
+// the hash of the token is stored, the raw value is handed to the client once.
+//
+// Every refresh rotates the token: the old one is marked as used and a new
+// one is issued within the same family. Presenting a used token again means
+// it leaked, so the whole family gets revoked.
+type RefreshToken struct {
+	ID        int        `json:"id"`
+	AccountID int        `json:"accountId"`
+	FamilyID  string     `json:"familyId"`
+	TokenHash string     `json:"-"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func NewRefreshToken(accountID int, familyID string, tokenHash string, ttl time.Duration) *RefreshToken {
+	now := time.Now().UTC()
+
+	return &RefreshToken{
+		AccountID: accountID,
+		FamilyID:  familyID,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+}
+
+func (t *RefreshToken) IsExpired() bool {
+	return time.Now().UTC().After(t.ExpiresAt)
+}
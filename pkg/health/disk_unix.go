@@ -0,0 +1,29 @@
+//go:build unix
+
+package health
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+)
+
+// Fails when the filesystem holding `path` has less than `minFree` bytes
+// available
+func DiskSpace(path string, minFree uint64) Check {
+	return func(context.Context) error {
+		var stat syscall.Statfs_t
+
+		if err := syscall.Statfs(path, &stat); err != nil {
+			return fmt.Errorf("Error reading disk usage of %s: %w", path, err)
+		}
+
+		free := stat.Bavail * uint64(stat.Bsize)
+
+		if free < minFree {
+			return fmt.Errorf("Only %d MB free on %s", free>>20, path)
+		}
+
+		return nil
+	}
+}
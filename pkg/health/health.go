@@ -0,0 +1,126 @@
+// Package health runs the checks behind the readiness endpoint. Results are
+// cached for a short while, so a load balancer polling every replica doesn't
+// turn into a steady stream of queries against the database.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Returns nil when the dependency is usable
+type Check func(ctx context.Context) error
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+type CheckResult struct {
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs float64   `json:"durationMs"`
+	CheckedAt  time.Time `json:"checkedAt"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+type Registry struct {
+	// Every check gets this long before it counts as failed
+	timeout time.Duration
+	// How long a result is reused before the check runs again
+	cacheTTL time.Duration
+
+	mu     sync.RWMutex
+	checks []*registeredCheck
+}
+
+type registeredCheck struct {
+	name  string
+	check Check
+
+	// Held while the check runs, so concurrent requests wait for one run
+	// instead of starting their own
+	mu     sync.Mutex
+	result CheckResult
+}
+
+func NewRegistry(timeout time.Duration, cacheTTL time.Duration) *Registry {
+	return &Registry{timeout: timeout, cacheTTL: cacheTTL}
+}
+
+func (r *Registry) Register(name string, check Check) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.checks = append(r.checks, &registeredCheck{name: name, check: check})
+}
+
+// Runs every check whose cached result expired, in parallel
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	checks := r.checks
+	r.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+
+	var wg sync.WaitGroup
+
+	for i, check := range checks {
+		wg.Go(func() {
+			results[i] = r.run(ctx, check)
+		})
+	}
+
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(checks))}
+
+	for i, check := range checks {
+		report.Checks[check.name] = results[i]
+
+		if results[i].Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+
+	return report
+}
+
+func (r *Registry) run(ctx context.Context, check *registeredCheck) CheckResult {
+	check.mu.Lock()
+	defer check.mu.Unlock()
+
+	if !check.result.CheckedAt.IsZero() && time.Since(check.result.CheckedAt) < r.cacheTTL {
+		return check.result
+	}
+
+	// A client giving up shouldn't fail the check for everyone sharing the
+	// cached result
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.check(ctx)
+
+	check.result = CheckResult{
+		Status:     StatusOK,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt:  start,
+	}
+
+	if err != nil {
+		check.result.Status = StatusFail
+		check.result.Error = err.Error()
+	}
+
+	return check.result
+}
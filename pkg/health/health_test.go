@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Counts its runs and returns `err`
+type stubCheck struct {
+	runs atomic.Int32
+	err  error
+}
+
+func (c *stubCheck) check(context.Context) error {
+	c.runs.Add(1)
+	return c.err
+}
+
+func TestRunReport(t *testing.T) {
+	r := NewRegistry(time.Second, 0)
+
+	ok := &stubCheck{}
+	r.Register("ok", ok.check)
+
+	if report := r.Run(context.Background()); !report.OK() || report.Checks["ok"].Status != StatusOK {
+		t.Fatalf("Got %+v, want ok", report)
+	}
+
+	failing := &stubCheck{err: errors.New("database down")}
+	r.Register("db", failing.check)
+
+	report := r.Run(context.Background())
+
+	if report.OK() || report.Status != StatusFail {
+		t.Errorf("Got status %s with a failing check, want fail", report.Status)
+	}
+
+	if result := report.Checks["db"]; result.Status != StatusFail || result.Error != "database down" || result.CheckedAt.IsZero() {
+		t.Errorf("Got %+v for the failing check", result)
+	}
+
+	if result := report.Checks["ok"]; result.Status != StatusOK || result.Error != "" {
+		t.Errorf("Got %+v for the passing check", result)
+	}
+}
+
+func TestRunEmpty(t *testing.T) {
+	if report := NewRegistry(time.Second, 0).Run(context.Background()); !report.OK() || len(report.Checks) != 0 {
+		t.Errorf("Got %+v, want ok without checks", report)
+	}
+}
+
+func TestResultsCached(t *testing.T) {
+	cached := NewRegistry(time.Second, time.Hour)
+	uncached := NewRegistry(time.Second, 0)
+
+	stubs := []*stubCheck{{}, {}}
+	cached.Register("db", stubs[0].check)
+	uncached.Register("db", stubs[1].check)
+
+	for range 3 {
+		cached.Run(context.Background())
+		uncached.Run(context.Background())
+	}
+
+	if runs := stubs[0].runs.Load(); runs != 1 {
+		t.Errorf("Cached check ran %d times, want 1", runs)
+	}
+
+	if runs := stubs[1].runs.Load(); runs != 3 {
+		t.Errorf("Uncached check ran %d times, want 3", runs)
+	}
+}
+
+// Failures are cached too, the database isn't hit harder while it's down
+func TestFailuresCached(t *testing.T) {
+	r := NewRegistry(time.Second, time.Hour)
+
+	failing := &stubCheck{err: errors.New("database down")}
+	r.Register("db", failing.check)
+
+	r.Run(context.Background())
+
+	if report := r.Run(context.Background()); report.OK() || failing.runs.Load() != 1 {
+		t.Errorf("Got %s after %d runs, want the cached failure", report.Status, failing.runs.Load())
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	r := NewRegistry(10*time.Millisecond, 0)
+
+	r.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	report := r.Run(context.Background())
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %v with a 10ms timeout", elapsed)
+	}
+
+	if result := report.Checks["slow"]; result.Status != StatusFail || result.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Got %+v, want a deadline failure", result)
+	}
+}
+
+// A client hanging up doesn't fail the result everyone else shares
+func TestCheckIgnoresCallerCancellation(t *testing.T) {
+	r := NewRegistry(time.Second, time.Hour)
+
+	r.Register("db", func(ctx context.Context) error {
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if report := r.Run(ctx); !report.OK() {
+		t.Errorf("Got %+v, want ok", report)
+	}
+}
+
+// Concurrent runs wait for the one in flight instead of starting their own
+func TestConcurrentRunsShareCheck(t *testing.T) {
+	r := NewRegistry(time.Second, time.Hour)
+
+	release := make(chan struct{})
+	var runs atomic.Int32
+
+	r.Register("db", func(context.Context) error {
+		runs.Add(1)
+		<-release
+		return nil
+	})
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Go(func() {
+			r.Run(context.Background())
+		})
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("Check ran %d times, want 1", got)
+	}
+}
@@ -1,13 +1,26 @@
 package http
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/grez-lucas/go-gym/pkg/config"
 	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/health"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+	"github.com/grez-lucas/go-gym/pkg/mail"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/oidc"
+	"github.com/grez-lucas/go-gym/pkg/ratelimit"
 	"github.com/grez-lucas/go-gym/pkg/storage"
 )
 
@@ -20,160 +33,346 @@ func WriteJSON(w http.ResponseWriter, status int, v any) error {
 type APIServer struct {
 	listenAddr string
 	// This way we can abstract the DB to anything that implements the Storage interface
-	store storage.Storage
+	store  storage.Storage
+	config *config.Config
+	// Signs and verifies our JWTs
+	keys   *keys.Manager
+	mailer mail.Mailer
+	// OpenID Connect providers users can sign in with, by name
+	oidcProviders map[string]*oidc.Provider
+	// Holds the rate limiting buckets
+	limiter    ratelimit.Backend
+	rateLimits rateLimitPolicies
+	// Checks behind /readyz
+	health *health.Registry
+	// Set once shutdown starts, /readyz reports the server as not ready from
+	// then on
+	draining atomic.Bool
+	// The OpenAPI document, built on first use
+	openAPISpec func() ([]byte, error)
+	// Compared against on logins of unknown users, see handleLogin
+	dummyPasswordHash string
+	// Work outliving its request, like sending emails. Shutdown waits for it.
+	background sync.WaitGroup
 }
 
 type APIFunc func(http.ResponseWriter, *http.Request) error
 
-type APIError struct {
-	Error string `json:"error"`
-}
-
 // To decorate our APIFunc into an HTTP handler
 // This way we can handle errors in our func logic, not the handler
 func makeHTTPHandleFunc(f APIFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		err := f(w, req)
 		if err != nil {
-			// Handle the error
-			WriteJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+			// Storage errors get their own status, see errorStatus
+			writeError(w, req, err)
 		}
 	}
 }
 
-func NewAPIServer(listenAddr string, store storage.Storage) *APIServer {
-	return &APIServer{
+func NewAPIServer(listenAddr string, store storage.Storage, config *config.Config, keys *keys.Manager, mailer mail.Mailer, limiter ratelimit.Backend, health *health.Registry) (*APIServer, error) {
+	s := &APIServer{
 		listenAddr: listenAddr,
 		store:      store,
+		config:     config,
+		keys:       keys,
+		mailer:     mailer,
+		limiter:    limiter,
+		rateLimits: newRateLimitPolicies(config),
+		health:     health,
+
+		oidcProviders: newOIDCProviders(config),
 	}
-}
-
-// Function to start our server up
-func (s *APIServer) Run() {
 
-	router := http.NewServeMux()
+	s.openAPISpec = sync.OnceValues(s.buildOpenAPISpec)
 
-	router.HandleFunc("GET /healthcheck", makeHTTPHandleFunc(s.handleGetHealthcheck))
-	router.HandleFunc("GET /login", makeHTTPHandleFunc(s.handleGetLogin))
-	router.HandleFunc("GET /gyms", makeHTTPHandleFunc(s.handleGetGyms))
-	router.HandleFunc("GET /gyms/{id}", makeHTTPHandleFunc(s.handleGetGym))
-	router.HandleFunc("POST /gyms", makeHTTPHandleFunc(s.handleCreateGym))
-	router.HandleFunc("DELETE /gyms/{id}", makeHTTPHandleFunc(s.handleDeleteGym))
-	router.HandleFunc("POST /gyms/{id}/ratings", WithJWTAuth(makeHTTPHandleFunc(s.handleRateGym)))
-	router.HandleFunc("GET /accounts", WithJWTAuth(makeHTTPHandleFunc(s.handleGetAccounts)))
-	router.HandleFunc("POST /accounts", makeHTTPHandleFunc(s.handleCreateAccount))
+	// Built here so the first login doesn't wait for it. Without it logins
+	// for unknown usernames would answer faster and give them away.
+	dummyPasswordHash, err := storage.NewDummyPasswordHash()
 
-	server := http.Server{
-		Addr:    s.listenAddr,
-		Handler: router,
+	if err != nil {
+		return nil, fmt.Errorf("Error generating dummy password hash: %w", err)
 	}
-	log.Println("Starting JSON API on port: ", s.listenAddr)
-	server.ListenAndServe()
 
+	s.dummyPasswordHash = dummyPasswordHash
+
+	return s, nil
 }
 
-// Handlers: a handler handles a specific route
-// name convention is handleFooBar
-func (s *APIServer) handleGetHealthcheck(w http.ResponseWriter, req *http.Request) error {
-	log.Println("Received Healthcheck request")
+// Serves the API until `ctx` is cancelled, then drains in flight requests for
+// up to config.ShutdownTimeout. Returns nil after a clean shutdown.
+func (s *APIServer) Run(ctx context.Context) error {
+
+	router := s.router()
+
+	server := &http.Server{
+		Handler:           s.middleware(router),
+		ReadTimeout:       s.config.ReadTimeout,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		MaxHeaderBytes:    s.config.MaxHeaderBytes,
+		// Failed TLS handshakes and the like
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
 
-	return WriteJSON(w, http.StatusOK, "Healthcheck - OK")
-}
+	// Config validation makes sure the key file is set too
+	useTLS := s.config.TLSCertFile != ""
 
-func (s *APIServer) handleGetLogin(w http.ResponseWriter, req *http.Request) error {
-	var loginRequest LoginRequest
+	if useTLS {
+		reloader, err := newCertReloader(s.config.TLSCertFile, s.config.TLSKeyFile)
 
-	if err := json.NewDecoder(req.Body).Decode(&loginRequest); err != nil {
-		return err
+		if err != nil {
+			return err
+		}
+
+		go reloader.Run(ctx)
+
+		server.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: reloader.GetCertificate,
+		}
 	}
 
-	acc, err := s.store.GetAccountByUsername(loginRequest.Username)
+	listener, err := listen(s.listenAddr)
 
 	if err != nil {
-		return err
+		return fmt.Errorf("Error listening on %s: %w", s.listenAddr, err)
 	}
 
-	// Validate password
+	if s.config.MetricsListenAddr != "" {
+		metricsServer, err := s.serveMetrics()
 
-	if !storage.VerifyHashedPassword(loginRequest.Password, acc.Password) {
-		return fmt.Errorf("Invalid Password")
+		if err != nil {
+			listener.Close()
+			return err
+		}
+
+		// Scraped until the very end, draining included
+		defer metricsServer.Close()
 	}
 
-	token, err := CreateJWT(acc)
+	s.background.Go(func() { s.pruneIdempotencyKeys(ctx) })
 
-	if err != nil {
+	serveErr := make(chan error, 1)
+
+	go func() {
+		slog.Info("Starting JSON API", "addr", s.listenAddr, "tls", useTLS)
+
+		if useTLS {
+			// Certificates come from TLSConfig
+			serveErr <- server.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
 		return err
+	case <-ctx.Done():
 	}
 
-	resp := LoginResponse{Token: token, AccountID: acc.ID}
+	return s.shutdown(server)
+}
 
-	return WriteJSON(w, http.StatusOK, resp)
+// Every request goes through these, in order, before reaching its route.
+// Recovery sits inside logging, tracing and metrics so they see the 500.
+func (s *APIServer) middleware(router *http.ServeMux) http.Handler {
+	return Chain(router,
+		withRoutePattern(router),
+		withRequestLogging,
+		withTracing,
+		withMetrics,
+		withRecovery,
+		withSecurityHeaders(s.config.TrustProxyHeaders),
+		s.withCORS,
+		withCompression,
+	)
 }
 
-func (s *APIServer) handleGetGyms(w http.ResponseWriter, req *http.Request) error {
-	log.Println("Received method to GET all gyms")
+func (s *APIServer) shutdown(server *http.Server) error {
+	s.draining.Store(true)
 
-	gyms, err := s.store.GetGyms()
+	// New connections should go to other replicas from now on
+	server.SetKeepAlivesEnabled(false)
 
-	if err != nil {
-		return err
+	if s.config.ShutdownDelay > 0 {
+		slog.Info("Shutting down, waiting for load balancers", "delay", s.config.ShutdownDelay)
+		time.Sleep(s.config.ShutdownDelay)
+	}
+
+	slog.Info("Draining in flight requests", "timeout", s.config.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		// Out of time, cut off whatever is left
+		server.Close()
+		return fmt.Errorf("Error draining requests: %w", err)
 	}
 
-	return WriteJSON(w, http.StatusOK, gyms)
+	// Emails of finished requests may still be on their way, they get
+	// whatever is left of the timeout
+	if err := s.waitBackground(ctx); err != nil {
+		return fmt.Errorf("Error finishing background work: %w", err)
+	}
+
+	slog.Info("Server stopped")
+
+	return nil
 }
 
-func (s *APIServer) handleGetGym(w http.ResponseWriter, req *http.Request) error {
-	id, err := GetID(req)
+func (s *APIServer) waitBackground(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		s.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Handlers: a handler handles a specific route
+// name convention is handleFooBar
+func (s *APIServer) handleGetGyms(w http.ResponseWriter, req *http.Request) error {
+	gyms, err := s.store.GetGyms(req.Context())
+
 	if err != nil {
 		return err
 	}
-	log.Println("Received method to GET a gym with id:", id)
 
-	gym, err := s.store.GetGymByID(id)
+	// Deleted gyms are gone from the list, the time of the last deletion is
+	// how it still moves forward
+	lastModified, err := s.store.GetLastGymDeletion(req.Context())
 
 	if err != nil {
 		return err
 	}
 
-	// Get the average rating for said gym
-	avgRating, err := s.store.GetAverageRating(id)
+	// Cheaper than hashing the whole list, and changes with any gym in it
+	versions := []any{len(gyms), lastModified.UnixNano()}
+
+	for _, gym := range gyms {
+		versions = append(versions, gym.ID, gym.LastModified().UnixNano(), gym.RatingCount, gym.Rating)
+
+		if gym.LastModified().After(lastModified) {
+			lastModified = gym.LastModified()
+		}
+	}
 
+	return writeCacheableJSON(w, req, s.publicCacheControl(), mapSlice(gyms, NewGymV1), validators{
+		etag:         weakETag(versions...),
+		lastModified: lastModified,
+	})
+}
+
+func (s *APIServer) handleGetGym(w http.ResponseWriter, req *http.Request) error {
+	id, err := GetID(req)
 	if err != nil {
 		return err
 	}
+	slog.DebugContext(req.Context(), "Fetching gym", "gym_id", id)
+
+	// Comes with the average rating
+	gym, err := s.store.GetGymByID(req.Context(), id)
 
-	gym.Rating = avgRating
+	if err != nil {
+		return err
+	}
 
-	return WriteJSON(w, http.StatusOK, gym)
+	// Strong ETag, hashed from the body
+	return writeCacheableJSON(w, req, s.publicCacheControl(), NewGymV1(gym), validators{lastModified: gym.LastModified()})
 }
 
 func (s *APIServer) handleCreateGym(w http.ResponseWriter, req *http.Request) error {
 	createGymRequest := new(domain.CreateGymRequest)
 
 	// Decode the json using our request struct
-	if err := json.NewDecoder(req.Body).Decode(createGymRequest); err != nil {
+	if err := decodeJSON(w, req, createGymRequest); err != nil {
 		return err
 	}
 
 	gym := domain.NewGym(createGymRequest.Name, createGymRequest.Description) // Interface for passed gym parameters
 
-	createdGym, err := s.store.CreateGym(gym)
+	createdGym, err := s.store.CreateGym(req.Context(), gym)
 
 	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusCreated, createdGym)
+	return WriteJSON(w, http.StatusCreated, NewGymV1(createdGym))
 }
 
-func (s *APIServer) handleRateGym(w http.ResponseWriter, req *http.Request) error {
+func (s *APIServer) handleUpdateGym(w http.ResponseWriter, req *http.Request) error {
+	id, err := GetID(req)
+	if err != nil {
+		return err
+	}
+
+	updateGymRequest := new(domain.UpdateGymRequest)
+	if err := decodeJSON(w, req, updateGymRequest); err != nil {
+		return err
+	}
+
+	gym := &domain.Gym{
+		ID:          id,
+		Name:        updateGymRequest.Name,
+		Description: updateGymRequest.Description,
+		UpdatedAt:   time.Now().UTC(),
+	}
+
+	if err := s.store.UpdateGym(req.Context(), gym); err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "Gym updated", "gym_id", id)
+
+	// Read back for the ratings and creation time
+	updatedGym, err := s.store.GetGymByID(req.Context(), id)
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, NewGymV1(updatedGym))
+}
+
+// Who a rating is posted as: the account, or for API keys the key's name
+// without an account
+func (s *APIServer) rater(req *http.Request) (int, string, error) {
+	if apiKey, ok := APIKeyFromContext(req.Context()); ok {
+		return 0, apiKey.Name, nil
+	}
+
 	accountID, ok := AccountIDFromContext(req.Context())
 
 	if !ok {
-		return WriteJSON(w, http.StatusUnauthorized, APIError{Error: "Unable to retrieve ID from context"})
+		return 0, "", unauthorized("Unable to retrieve ID from context")
 	}
 
-	acc, err := s.store.GetAccountByID(int(accountID))
+	acc, err := s.store.GetAccountByID(req.Context(), int(accountID))
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	if !acc.IsEmailVerified() {
+		return 0, "", forbidden("Verify your email address before rating gyms")
+	}
+
+	return acc.ID, acc.UserName, nil
+}
+
+func (s *APIServer) handleRateGym(w http.ResponseWriter, req *http.Request) error {
+	accountID, userName, err := s.rater(req)
 
 	if err != nil {
 		return err
@@ -183,37 +382,35 @@ func (s *APIServer) handleRateGym(w http.ResponseWriter, req *http.Request) erro
 	if err != nil {
 		return err
 	}
-	log.Println("Received method to RATE gym with id:", gymId)
+	slog.DebugContext(req.Context(), "Rating gym", "gym_id", gymId)
 
 	createRatingRequest := new(domain.CreateRatingRequest)
-	if err := json.NewDecoder(req.Body).Decode(createRatingRequest); err != nil {
+	if err := decodeJSON(w, req, createRatingRequest); err != nil {
 		return err
 	}
 
-	gym, err := s.store.GetGymByID(gymId)
-
-	if err != nil {
+	// Fails with a 404 for unknown gyms
+	if _, err := s.store.GetGymByID(req.Context(), gymId); err != nil {
 		return err
 	}
 
-	if gym == nil {
-		return WriteJSON(w, http.StatusNotFound, fmt.Sprintf("Gym with ID: %d not found.", gymId))
-	}
-
 	rating := domain.NewRating(
 		gymId,
+		accountID,
 		createRatingRequest.Rating,
-		acc.UserName,
+		userName,
 		createRatingRequest.Review,
 	)
 
-	createdRating, err := s.store.CreateRating(rating)
+	createdRating, err := s.store.CreateRating(req.Context(), rating)
 
 	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusCreated, createdRating)
+	metrics.RatingsCreated.Inc()
+
+	return WriteJSON(w, http.StatusCreated, NewRatingV1(createdRating))
 }
 
 func (s *APIServer) handleDeleteGym(w http.ResponseWriter, req *http.Request) error {
@@ -221,9 +418,9 @@ func (s *APIServer) handleDeleteGym(w http.ResponseWriter, req *http.Request) er
 	if err != nil {
 		return err
 	}
-	log.Println("Received method to DELETE gym with id:", id)
+	slog.InfoContext(req.Context(), "Deleting gym", "gym_id", id)
 
-	if err = s.store.DeleteGym(id); err != nil {
+	if err = s.store.DeleteGym(req.Context(), id); err != nil {
 		return err
 	}
 
@@ -235,7 +432,7 @@ func GetID(req *http.Request) (int, error) {
 
 	id, err := strconv.Atoi(reqId)
 	if err != nil {
-		return id, fmt.Errorf("Invalid id given %s", reqId)
+		return id, badRequest("Invalid id given %s", reqId)
 	}
 
 	return id, nil
@@ -243,37 +440,51 @@ func GetID(req *http.Request) (int, error) {
 
 func (s *APIServer) handleCreateAccount(w http.ResponseWriter, req *http.Request) error {
 	createAccountRequest := new(domain.CreateAccountRequest)
-	if err := json.NewDecoder(req.Body).Decode(createAccountRequest); err != nil {
+	if err := decodeJSON(w, req, createAccountRequest); err != nil {
 		return err
 	}
 
-	account := domain.NewAccount(createAccountRequest.UserName, createAccountRequest.Password)
+	_, err := s.store.GetAccountByEmail(req.Context(), createAccountRequest.Email)
 
-	createdAccount, err := s.store.CreateAccount(account)
+	if err == nil {
+		return conflict("Email address already in use")
+	}
 
-	if err != nil {
+	if !errors.Is(err, storage.ErrNotFound) {
 		return err
 	}
 
-	// Create a JWT for said account
+	// The address is only attached once verified, see sendEmailVerification
+	account := domain.NewAccount(createAccountRequest.UserName, createAccountRequest.Password)
 
-	tokenStr, err := CreateJWT(createdAccount)
+	createdAccount, err := s.store.CreateAccount(req.Context(), account)
 
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Created JWT Token `%s` for account `%d`", tokenStr, createdAccount.ID)
+	slog.InfoContext(req.Context(), "Account created", "account_id", createdAccount.ID)
+	metrics.AccountsCreated.WithLabelValues("signup").Inc()
+
+	// The email is verified by following the link, until then the account
+	// can't post ratings
+	ctx := context.WithoutCancel(req.Context())
+
+	s.background.Go(func() {
+		if err := s.sendEmailVerification(ctx, createdAccount, createAccountRequest.Email); err != nil {
+			slog.ErrorContext(ctx, "Error sending verification email", "account_id", createdAccount.ID, "error", err)
+		}
+	})
 
-	return WriteJSON(w, http.StatusCreated, createdAccount)
+	return WriteJSON(w, http.StatusCreated, NewAccountV1(createdAccount))
 }
 
 func (s *APIServer) handleGetAccounts(w http.ResponseWriter, req *http.Request) error {
-	accounts, err := s.store.GetAccounts()
+	accounts, err := s.store.GetAccounts(req.Context())
 
 	if err != nil {
 		return err
 	}
 
-	return WriteJSON(w, http.StatusOK, accounts)
+	return WriteJSON(w, http.StatusOK, mapSlice(accounts, NewAccountV1))
 }
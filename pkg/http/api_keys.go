@@ -0,0 +1,204 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+const (
+	apiKeyHeader = "x-api-key"
+	apiKeyPrefix = "gogym"
+)
+
+const ContextAPIKeyKey ContextKey = "apiKey"
+
+func APIKeyFromContext(ctx context.Context) (*domain.APIKey, bool) {
+	v, ok := ctx.Value(ContextAPIKeyKey).(*domain.APIKey)
+
+	return v, ok
+}
+
+type CreateAPIKeyResponse struct {
+	APIKey APIKeyV1 `json:"apiKey"`
+	// The full key, only ever shown in this response
+	Key string `json:"key"`
+}
+
+// Generates `gogym_<prefix>_<secret>`, returning the key and its prefix
+func generateAPIKey() (string, string, error) {
+	prefix, err := generateOpaqueToken(6)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	// The prefix must not contain our separator
+	prefix = strings.ReplaceAll(prefix, "_", "x")
+
+	secret, err := generateOpaqueToken(32)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	return fmt.Sprintf("%s_%s_%s", apiKeyPrefix, prefix, secret), prefix, nil
+}
+
+// Splits `gogym_<prefix>_<secret>` and returns the prefix
+func parseAPIKey(key string) (string, bool) {
+	parts := strings.SplitN(key, "_", 3)
+
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+func (s *APIServer) authenticateAPIKey(ctx context.Context, key string) (*domain.APIKey, error) {
+	prefix, ok := parseAPIKey(key)
+
+	if !ok {
+		return nil, fmt.Errorf("Malformed API key")
+	}
+
+	apiKey, err := s.store.GetAPIKeyByPrefix(ctx, prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, fmt.Errorf("Invalid API key `%s`", prefix)
+	}
+
+	if !apiKey.IsActive() {
+		return nil, fmt.Errorf("API key `%s` is expired or revoked", prefix)
+	}
+
+	if err := s.store.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		slog.Warn("Error recording use of API key", "prefix", prefix, "error", err)
+	}
+
+	return apiKey, nil
+}
+
+// Lets services in with an API key in the `x-api-key` header, as long as the
+// key was granted `scope`. Requests without one go to `withoutKey` instead,
+// which checks whatever humans need instead. Handlers behind it find either an
+// account ID or an API key in the context.
+func (s *APIServer) WithAPIKeyAuth(scope string, withoutKey http.HandlerFunc, handlerFunc http.HandlerFunc) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, req *http.Request) {
+
+		key := req.Header.Get(apiKeyHeader)
+
+		if key == "" {
+			withoutKey(w, req)
+			return
+		}
+
+		apiKey, err := s.authenticateAPIKey(req.Context(), key)
+
+		if errors.Is(err, storage.ErrUnavailable) {
+			writeError(w, req, err)
+			return
+		}
+
+		if err != nil {
+			slog.InfoContext(req.Context(), "Rejected API key", "error", err)
+			metrics.AuthFailures.WithLabelValues("api_key").Inc()
+			WriteUnauthorized(w, req)
+			return
+		}
+
+		if !apiKey.HasScope(scope) {
+			writeError(w, req, forbidden("API key lacks the `%s` scope", scope))
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), ContextAPIKeyKey, apiKey)
+
+		handlerFunc(w, req.WithContext(ctx))
+	}
+}
+
+func (s *APIServer) handleCreateAPIKey(w http.ResponseWriter, req *http.Request) error {
+	accountID, ok := AccountIDFromContext(req.Context())
+
+	if !ok {
+		return unauthorized("Unable to retrieve ID from context")
+	}
+
+	createRequest := new(domain.CreateAPIKeyRequest)
+
+	if err := decodeJSON(w, req, createRequest); err != nil {
+		return err
+	}
+
+	for _, scope := range createRequest.Scopes {
+		if !slices.Contains(domain.APIKeyScopes, scope) {
+			return badRequest("Unknown scope `%s`", scope)
+		}
+	}
+
+	key, prefix, err := generateAPIKey()
+
+	if err != nil {
+		return err
+	}
+
+	apiKey := domain.NewAPIKey(
+		createRequest.Name,
+		prefix,
+		hashToken(key),
+		createRequest.Scopes,
+		int(accountID),
+		createRequest.ExpiresAt,
+	)
+
+	createdKey, err := s.store.CreateAPIKey(req.Context(), apiKey)
+
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "API key created", "prefix", prefix)
+
+	return WriteJSON(w, http.StatusCreated, CreateAPIKeyResponse{APIKey: NewAPIKeyV1(createdKey), Key: key})
+}
+
+func (s *APIServer) handleGetAPIKeys(w http.ResponseWriter, req *http.Request) error {
+	apiKeys, err := s.store.GetAPIKeys(req.Context())
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, mapSlice(apiKeys, NewAPIKeyV1))
+}
+
+func (s *APIServer) handleRevokeAPIKey(w http.ResponseWriter, req *http.Request) error {
+	id, err := GetID(req)
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.RevokeAPIKey(req.Context(), id); err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "API key revoked", "key_id", id)
+
+	return WriteJSON(w, http.StatusOK, map[string]int{"API key successfully revoked": id})
+}
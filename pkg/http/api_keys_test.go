@@ -0,0 +1,126 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+func TestCreateAPIKeyExpiry(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	admin := domain.NewAccount("root", "correct horse")
+	admin.Role = domain.RoleAdmin
+	admin = store.addAccount(admin)
+	adminToken, _ := s.CreateJWT(admin)
+
+	past := time.Now().Add(-time.Hour)
+
+	rec := doJSON(t, handler, "POST", "/admin/api-keys", domain.CreateAPIKeyRequest{Name: "kiosk", ExpiresAt: &past}, jwtHeader(adminToken), nil)
+
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expired on creation: got %d, want 422", rec.Code)
+	}
+
+	future := time.Now().Add(time.Hour)
+
+	var created CreateAPIKeyResponse
+
+	rec = doJSON(t, handler, "POST", "/admin/api-keys", domain.CreateAPIKeyRequest{Name: "kiosk", Scopes: []string{domain.ScopeGymsRead}, ExpiresAt: &future}, jwtHeader(adminToken), &created)
+
+	if rec.Code != http.StatusCreated || created.Key == "" {
+		t.Fatalf("Got %d %s, want 201 with a key", rec.Code, rec.Body.String())
+	}
+
+	if rec := get(handler, "/gyms", http.Header{"X-Api-Key": {created.Key}}); rec.Code != http.StatusOK {
+		t.Errorf("Using the new key: got %d, want 200", rec.Code)
+	}
+}
+
+// Creates a key with the scopes and returns it in full
+func addTestAPIKey(store *memoryStore, name string, scopes ...string) string {
+	key, prefix, _ := generateAPIKey()
+	store.addAPIKey(domain.NewAPIKey(name, prefix, hashToken(key), scopes, 0, nil))
+
+	return key
+}
+
+// The kiosk reads gyms, which stay public, but a key sent along is checked
+func TestGymsReadScope(t *testing.T) {
+	s, store := newGymsTestServer(t)
+	handler := s.router()
+
+	tests := []struct {
+		name string
+		key  string
+		want int
+	}{
+		{"no key", "", http.StatusOK},
+		{"key with the scope", addTestAPIKey(store, "kiosk", domain.ScopeGymsRead), http.StatusOK},
+		{"key without the scope", addTestAPIKey(store, "pipeline", domain.ScopeRatingsWrite), http.StatusForbidden},
+		{"unknown key", "gogym_nope_nope", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+
+			if tt.key != "" {
+				header.Set("X-Api-Key", tt.key)
+			}
+
+			for _, path := range []string{"/gyms", "/gyms/1"} {
+				if rec := get(handler, path, header); rec.Code != tt.want {
+					t.Errorf("%s: got %d, want %d", path, rec.Code, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// The pipeline posts ratings under the key's name, without an account
+func TestRatingsWriteScope(t *testing.T) {
+	s, store := newGymsTestServer(t)
+	handler := s.router()
+
+	rating := domain.CreateRatingRequest{Rating: 4, Review: "Imported"}
+
+	kiosk := http.Header{"X-Api-Key": {addTestAPIKey(store, "kiosk", domain.ScopeGymsRead)}}
+
+	if rec := doJSON(t, handler, "POST", "/gyms/1/ratings", rating, kiosk, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Key without the scope: got %d, want 403", rec.Code)
+	}
+
+	if rec := doJSON(t, handler, "POST", "/gyms/1/ratings", rating, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("No credentials: got %d, want 401", rec.Code)
+	}
+
+	pipeline := http.Header{"X-Api-Key": {addTestAPIKey(store, "pipeline", domain.ScopeRatingsWrite)}}
+
+	var created RatingV1
+
+	if rec := doJSON(t, handler, "POST", "/gyms/1/ratings", rating, pipeline, &created); rec.Code != http.StatusCreated {
+		t.Fatalf("Got %d %s, want 201", rec.Code, rec.Body.String())
+	}
+
+	if created.GymID != 1 || created.UserName != "pipeline" || created.Rating != 4 {
+		t.Errorf("Got %+v", created)
+	}
+
+	if ratings, _ := store.GetRatingsByAccount(context.Background(), 0); len(ratings) != 1 {
+		t.Errorf("Got %d ratings without an account, want 1", len(ratings))
+	}
+
+	// Accounts still need a verified address
+	alice := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	token, _ := s.CreateJWT(alice)
+
+	if rec := doJSON(t, handler, "POST", "/gyms/1/ratings", rating, jwtHeader(token), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Unverified account: got %d, want 403", rec.Code)
+	}
+}
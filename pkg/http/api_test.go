@@ -0,0 +1,181 @@
+package http
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// Emails sent after their request finished must not be cut off by shutdown,
+// but they can't hold it up past the timeout either
+func TestShutdownWaitsForBackgroundWork(t *testing.T) {
+	s := newTestServer(t, &config.Config{ShutdownTimeout: time.Second})
+
+	var finished atomic.Bool
+
+	s.background.Go(func() {
+		time.Sleep(50 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	if err := s.shutdown(&http.Server{}); err != nil {
+		t.Fatalf("Error shutting down: %v", err)
+	}
+
+	if !finished.Load() {
+		t.Error("Shutdown returned before background work finished")
+	}
+
+	s = newTestServer(t, &config.Config{ShutdownTimeout: 10 * time.Millisecond})
+	stuck := make(chan struct{})
+	defer close(stuck)
+
+	s.background.Go(func() { <-stuck })
+
+	if err := s.shutdown(&http.Server{}); err == nil {
+		t.Error("Shutdown waited for stuck background work past its timeout")
+	}
+}
+
+// The listing shows emails and roles, so logging in isn't enough to see it
+func TestGetAccountsAdminOrAPIKey(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	user := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	admin := domain.NewAccount("root", "correct horse")
+	admin.Role = domain.RoleAdmin
+	admin = store.addAccount(admin)
+
+	userToken, _ := s.CreateJWT(user)
+	adminToken, _ := s.CreateJWT(admin)
+
+	if rec := doJSON(t, handler, "GET", "/accounts", nil, jwtHeader(userToken), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("User JWT: got %d, want 403", rec.Code)
+	}
+
+	var accounts []AccountV1
+
+	if rec := doJSON(t, handler, "GET", "/accounts", nil, jwtHeader(adminToken), &accounts); rec.Code != http.StatusOK || len(accounts) != 2 {
+		t.Errorf("Admin JWT: got %d with %d accounts, want 200 with 2", rec.Code, len(accounts))
+	}
+
+	for _, scopes := range [][]string{nil, {domain.ScopeAccountsRead}} {
+		key, prefix, _ := generateAPIKey()
+		store.addAPIKey(domain.NewAPIKey("service", prefix, hashToken(key), scopes, admin.ID, nil))
+
+		want := http.StatusForbidden
+		if len(scopes) > 0 {
+			want = http.StatusOK
+		}
+
+		if rec := doJSON(t, handler, "GET", "/accounts", nil, http.Header{"X-Api-Key": {key}}, nil); rec.Code != want {
+			t.Errorf("API key with scopes %v: got %d, want %d", scopes, rec.Code, want)
+		}
+	}
+}
+
+// Every scope a key can be granted opens some route, and routes only take
+// keys with a scope from the list
+func TestRouteScopes(t *testing.T) {
+	s, _ := newAuthTestServer(t, &config.Config{})
+
+	used := map[string]bool{}
+
+	for _, r := range s.routes() {
+		s.routeHandler(r)
+		used[r.scope] = true
+	}
+
+	for _, scope := range domain.APIKeyScopes {
+		if !used[scope] {
+			t.Errorf("No route requires the `%s` scope", scope)
+		}
+	}
+
+	invalid := []route{
+		{method: "GET", path: "/no-scope", auth: authAdminOrAPIKey},
+		{method: "GET", path: "/unknown-scope", auth: authAdminOrAPIKey, scope: "gyms:write"},
+		{method: "GET", path: "/scope-without-keys", auth: authAdmin, scope: domain.ScopeAccountsRead},
+	}
+
+	for _, r := range invalid {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Route %s didn't panic", r.pattern())
+				}
+			}()
+
+			s.routeHandler(r)
+		}()
+	}
+}
+
+// Routes without a scope ignore API keys, even ones with every scope
+func TestAPIKeyIgnoredWithoutScope(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	admin := domain.NewAccount("root", "correct horse")
+	admin.Role = domain.RoleAdmin
+	admin = store.addAccount(admin)
+
+	key, prefix, _ := generateAPIKey()
+	store.addAPIKey(domain.NewAPIKey("service", prefix, hashToken(key), domain.APIKeyScopes, admin.ID, nil))
+
+	for _, path := range []string{"/admin/api-keys", "/accounts/me"} {
+		if rec := doJSON(t, handler, "GET", path, nil, http.Header{"X-Api-Key": {key}}, nil); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s with an API key: got %d, want 401", path, rec.Code)
+		}
+	}
+}
+
+func TestUpdateGym(t *testing.T) {
+	s, store := newGymsTestServer(t)
+	handler := s.router()
+
+	admin := domain.NewAccount("root", "correct horse")
+	admin.Role = domain.RoleAdmin
+	admin = store.addAccount(admin)
+	adminToken, _ := s.CreateJWT(admin)
+
+	member := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	memberToken, _ := s.CreateJWT(member)
+
+	before := get(handler, "/gyms/1", nil)
+	update := domain.UpdateGymRequest{Name: "Iron Temple II", Description: "Now with showers"}
+
+	if rec := doJSON(t, handler, "PUT", "/gyms/1", update, jwtHeader(memberToken), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Member: got %d, want 403", rec.Code)
+	}
+
+	if rec := doJSON(t, handler, "PUT", "/gyms/99", update, jwtHeader(adminToken), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown gym: got %d, want 404", rec.Code)
+	}
+
+	if rec := doJSON(t, handler, "PUT", "/gyms/1", domain.UpdateGymRequest{}, jwtHeader(adminToken), nil); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("No name: got %d, want 422", rec.Code)
+	}
+
+	var gym GymV1
+
+	if rec := doJSON(t, handler, "PUT", "/gyms/1", update, jwtHeader(adminToken), &gym); rec.Code != http.StatusOK {
+		t.Fatalf("Got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+
+	if gym.ID != 1 || gym.Name != update.Name || gym.Description != update.Description {
+		t.Errorf("Got %+v", gym)
+	}
+
+	// Caches revalidating with the old validators get the new gym
+	after := get(handler, "/gyms/1", http.Header{"If-None-Match": {before.Header().Get("ETag")}})
+
+	if after.Code != http.StatusOK || after.Header().Get("Last-Modified") == before.Header().Get("Last-Modified") {
+		t.Errorf("Got %d with Last-Modified %q, want 200 with a newer one", after.Code, after.Header().Get("Last-Modified"))
+	}
+}
@@ -3,33 +3,45 @@ package http
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/grez-lucas/go-gym/pkg/config"
 	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+	"github.com/grez-lucas/go-gym/pkg/logging"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string `json:"username" validate:"required,max=100"`
+	Password string `json:"password" validate:"required"`
 }
 
 type LoginResponse struct {
-	Token     string `json:"token"`
-	AccountID int    `json:"ID"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // Seconds until `token` expires
+	AccountID    int    `json:"ID"`
+}
+
+// Claims carried by our access tokens. `accountID` is kept next to the
+// standard `sub` claim so existing clients can still read it.
+type AccessClaims struct {
+	AccountID int `json:"accountID"`
+	jwt.RegisteredClaims
 }
 
 type ContextKey string
 
 const ContextAccountKey ContextKey = "account"
 
-func WriteUnauthorized(w http.ResponseWriter) {
-
-	w.WriteHeader(http.StatusUnauthorized)
-	w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
-
+func WriteUnauthorized(w http.ResponseWriter, req *http.Request) {
+	writeError(w, req, unauthorized("Missing, invalid or expired credentials"))
 }
 
 func AccountIDFromContext(ctx context.Context) (int64, bool) {
@@ -41,71 +53,98 @@ func AccountIDFromContext(ctx context.Context) (int64, bool) {
 // To decorate certain HTTP handlers with JWT authentication (the ones who
 // require it)
 
-func WithJWTAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
-
-	log.Println("Calling JWT middleware")
+func (s *APIServer) WithJWTAuth(handlerFunc http.HandlerFunc) http.HandlerFunc {
 
 	return func(w http.ResponseWriter, req *http.Request) {
 
-		tokenString := req.Header.Get("x-jwt-token")
-
-		token, err := ValidateJWT(tokenString)
+		accountID, err := s.authenticateJWT(req)
 
 		if err != nil {
-			log.Printf("Error validating JWT: `%v`", err.Error())
-			WriteUnauthorized(w)
-			return
-		}
-
-		// Check if token is valid to extract claims
-		if !token.Valid {
-			log.Printf("Token is invalid \n")
-			WriteUnauthorized(w)
-			return
-		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-
-		if !ok {
-			log.Printf("Token has invalid claims \n")
-			WriteUnauthorized(w)
+			slog.InfoContext(req.Context(), "Rejected JWT", "error", err)
+			metrics.AuthFailures.WithLabelValues("jwt").Inc()
+			WriteUnauthorized(w, req)
 			return
 		}
 
-		accountID := int64(claims["accountID"].(float64))
-
 		// Store the ID in GoLang context
 		// So that we can pass it around to later methods which require auth
 
 		ctx := context.WithValue(req.Context(), ContextAccountKey, accountID)
+		logging.AddAttrs(ctx, slog.Int64("account_id", accountID))
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("gogym.account_id", accountID))
 
 		handlerFunc(w, req.WithContext(ctx))
 	}
 }
 
-func CreateJWT(account *domain.Account) (string, error) {
+// Returns the account ID from the `x-jwt-token` header
+func (s *APIServer) authenticateJWT(req *http.Request) (int64, error) {
+
+	tokenString := req.Header.Get("x-jwt-token")
+
+	token, err := s.ValidateJWT(tokenString)
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Check if token is valid to extract claims
+	if !token.Valid {
+		return 0, fmt.Errorf("Token is invalid")
+	}
+
+	claims, ok := token.Claims.(*AccessClaims)
+
+	if !ok || claims.AccountID == 0 {
+		return 0, fmt.Errorf("Token has invalid claims")
+	}
+
+	return int64(claims.AccountID), nil
+}
+
+// Creates a short lived access token for the account. Clients get a new one
+// through `POST /auth/refresh` once it expires.
+func (s *APIServer) CreateJWT(account *domain.Account) (string, error) {
+
+	now := time.Now().UTC()
+
+	jti, err := generateOpaqueToken(16)
 
-	claims := &jwt.MapClaims{
-		"expiresAt": 15000,
-		"accountID": account.ID,
+	if err != nil {
+		return "", err
 	}
 
-	secret := config.LoadConfig().JWTSecret
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	claims := &AccessClaims{
+		AccountID: account.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.config.JWTIssuer,
+			Subject:   strconv.Itoa(account.ID),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.config.AccessTokenTTL)),
+		},
+	}
 
-	return token.SignedString([]byte(secret))
+	return s.keys.Sign(claims)
 }
 
-func ValidateJWT(tokenString string) (*jwt.Token, error) {
+func (s *APIServer) ValidateJWT(tokenString string) (*jwt.Token, error) {
 
-	jwtSecret := config.LoadConfig().JWTSecret
+	return jwt.ParseWithClaims(tokenString, &AccessClaims{}, s.keys.Keyfunc,
+		// Don't forget to validate the alg is what you expect
+		jwt.WithValidMethods(s.keys.ValidMethods()),
+		jwt.WithIssuer(s.config.JWTIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
-	return jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: `%v`", t.Header["&alg"])
-		}
-		return []byte(jwtSecret), nil
-	})
+}
+
+// Public keys other services use to verify our tokens
+func (s *APIServer) handleGetJWKS(w http.ResponseWriter, req *http.Request) error {
+	// New keys are published for longer than this before they sign
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(keys.JWKSMaxAge.Seconds())))
 
+	return WriteJSON(w, http.StatusOK, s.keys.JWKS())
 }
@@ -0,0 +1,253 @@
+package http
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Smaller responses aren't worth the CPU, and may even grow
+const minCompressBytes = 1024
+
+var (
+	gzipWriters   = sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }}
+	brotliWriters = sync.Pool{New: func() any { return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression) }}
+)
+
+// Compresses responses with brotli or gzip, whichever the client prefers,
+// once they're big enough and of a type that compresses well
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"))
+
+		if encoding == "" || req.Method == http.MethodHead {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		writer := &compressWriter{ResponseWriter: w, encoding: encoding}
+
+		next.ServeHTTP(writer, req)
+
+		// Not deferred: after a panic the buffered part of the response is
+		// dropped, leaving withRecovery free to send a 500 instead
+		writer.Close()
+	})
+}
+
+// Picks `br` or `gzip` from an Accept-Encoding header, honoring q values and
+// preferring brotli on a tie
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		q := 1.0
+
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			parsed, err := strconv.ParseFloat(value, 64)
+
+			if err != nil {
+				continue
+			}
+
+			q = parsed
+		}
+
+		if (name != "br" && name != "gzip") || q <= 0 {
+			continue
+		}
+
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+
+	return best
+}
+
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		mediaType == "application/javascript"
+}
+
+// Holds back the first minCompressBytes of the response to decide whether to
+// compress it at all. Headers go out once that's decided.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.status != 0 || w.decided {
+		return
+	}
+
+	// Informational responses go out right away and don't end the headers
+	if status >= 100 && status < 200 {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.status = status
+
+	// Responses without a body have nothing to compress
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if w.decided {
+		return w.write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+
+	if len(w.buf) >= minCompressBytes {
+		w.decide(true)
+
+		if err := w.flushBuffer(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (w *compressWriter) write(b []byte) (int, error) {
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Sends the headers, compressed or not
+func (w *compressWriter) decide(bigEnough bool) {
+	w.decided = true
+
+	header := w.Header()
+
+	compress := bigEnough &&
+		header.Get("Content-Encoding") == "" &&
+		compressibleType(header.Get("Content-Type"))
+
+	// A strong ETag stands for these exact bytes, so one per encoding. It's
+	// tagged whether this response ends up compressed or not, since a 304
+	// can't know and has to send the same ETag as the 200 it stands for.
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoding+`"`)
+	}
+
+	if compress {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+
+		if w.encoding == "br" {
+			encoder := brotliWriters.Get().(*brotli.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *compressWriter) flushBuffer() error {
+	buf := w.buf
+	w.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := w.write(buf)
+
+	return err
+}
+
+// Ends the response. Short responses are sent as they are.
+func (w *compressWriter) Close() error {
+	// Nothing written, net/http sends an empty 200
+	if w.status == 0 && len(w.buf) == 0 && !w.decided {
+		return nil
+	}
+
+	if !w.decided {
+		w.decide(false)
+	}
+
+	if err := w.flushBuffer(); err != nil {
+		return err
+	}
+
+	if w.encoder == nil {
+		return nil
+	}
+
+	err := w.encoder.Close()
+
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		gzipWriters.Put(encoder)
+	case *brotli.Writer:
+		brotliWriters.Put(encoder)
+	}
+
+	w.encoder = nil
+
+	return err
+}
+
+// Streams whatever is buffered, compressed if big enough by now
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) >= minCompressBytes)
+	}
+
+	w.flushBuffer()
+
+	if flusher, ok := w.encoder.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"br", "br"},
+		{"gzip, br", "br"},
+		{"GZIP", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"br;q=oops, gzip", "gzip"},
+		{"deflate, *", ""},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func decompress(t *testing.T, encoding string, body []byte) []byte {
+	t.Helper()
+
+	var reader io.Reader
+
+	switch encoding {
+	case "br":
+		reader = brotli.NewReader(bytes.NewReader(body))
+	case "gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+
+		if err != nil {
+			t.Fatalf("Error reading gzip: %v", err)
+		}
+
+		reader = gzipReader
+	default:
+		return body
+	}
+
+	decompressed, err := io.ReadAll(reader)
+
+	if err != nil {
+		t.Fatalf("Error decompressing %s: %v", encoding, err)
+	}
+
+	return decompressed
+}
+
+// The API document is big enough to compress and served with a strong ETag
+func TestCompression(t *testing.T) {
+	s, _ := newAuthTestServer(t, &config.Config{})
+	handler := s.middleware(s.router())
+
+	plain := get(handler, "/openapi.json", nil)
+	plainETag := plain.Header().Get("ETag")
+
+	if plain.Code != http.StatusOK || plain.Header().Get("Content-Encoding") != "" || plain.Body.Len() < minCompressBytes {
+		t.Fatalf("Got %d with Content-Encoding %q and %d bytes", plain.Code, plain.Header().Get("Content-Encoding"), plain.Body.Len())
+	}
+
+	for _, encoding := range []string{"br", "gzip"} {
+		t.Run(encoding, func(t *testing.T) {
+			rec := get(handler, "/openapi.json", http.Header{"Accept-Encoding": {encoding}})
+
+			if rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != encoding {
+				t.Fatalf("Got %d with Content-Encoding %q, want 200 with %s", rec.Code, rec.Header().Get("Content-Encoding"), encoding)
+			}
+
+			if !slices.Contains(rec.Header().Values("Vary"), "Accept-Encoding") {
+				t.Errorf("Got Vary %q, want Accept-Encoding in it", rec.Header().Values("Vary"))
+			}
+
+			if rec.Body.Len() >= plain.Body.Len() {
+				t.Errorf("Got %d bytes, no smaller than %d", rec.Body.Len(), plain.Body.Len())
+			}
+
+			if body := decompress(t, encoding, rec.Body.Bytes()); !bytes.Equal(body, plain.Body.Bytes()) {
+				t.Error("Decompressed body differs from the plain one")
+			}
+
+			etag := rec.Header().Get("ETag")
+			want := plainETag[:len(plainETag)-1] + "-" + encoding + `"`
+
+			if etag != want {
+				t.Fatalf("Got ETag %q, want %q", etag, want)
+			}
+
+			revalidated := get(handler, "/openapi.json", http.Header{"Accept-Encoding": {encoding}, "If-None-Match": {etag}})
+
+			if revalidated.Code != http.StatusNotModified || revalidated.Header().Get("ETag") != etag || revalidated.Body.Len() != 0 {
+				t.Errorf("Revalidating got %d with ETag %q and %d bytes, want an empty 304 with %q", revalidated.Code, revalidated.Header().Get("ETag"), revalidated.Body.Len(), etag)
+			}
+		})
+	}
+
+	// Short responses aren't worth it
+	if rec := get(handler, "/gyms", http.Header{"Accept-Encoding": {"br"}}); rec.Code != http.StatusOK || rec.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Short response: got %d with Content-Encoding %q", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Lets shared caches like our CDN keep public data for config.CacheMaxAge,
+// after which clients revalidate with the validators below
+func (s *APIServer) publicCacheControl() string {
+	return fmt.Sprintf("public, max-age=%d", int(s.config.CacheMaxAge.Seconds()))
+}
+
+// Validators of a response. A strong ETag changes with every byte of the
+// body, a weak one only when the data behind it does.
+type validators struct {
+	etag         string
+	lastModified time.Time
+}
+
+func strongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Hashes the parts into a weak ETag, like weakETag(gym.ID, gym.UpdatedAt)
+func weakETag(parts ...any) string {
+	hash := sha256.New()
+
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%v\x00", part)
+	}
+
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+// Whether the client's copy is still current for If-Match and
+// If-Unmodified-Since, the 412 preconditions. If-Match wins when both are
+// sent, as RFC 9110 asks.
+func preconditionsHold(req *http.Request, v validators) bool {
+	if ifMatch := req.Header.Get("If-Match"); ifMatch != "" {
+		return etagMatches(ifMatch, v.etag, true)
+	}
+
+	ifUnmodifiedSince, err := http.ParseTime(req.Header.Get("If-Unmodified-Since"))
+
+	if err != nil || v.lastModified.IsZero() {
+		return true
+	}
+
+	return !v.lastModified.Truncate(time.Second).After(ifUnmodifiedSince)
+}
+
+// Whether the client's cached copy is still current. If-None-Match wins over
+// If-Modified-Since when both are sent, as RFC 9110 asks.
+func notModified(req *http.Request, v validators) bool {
+	if ifNoneMatch := req.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		return etagMatches(ifNoneMatch, v.etag, false)
+	}
+
+	ifModifiedSince, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+
+	if err != nil || v.lastModified.IsZero() {
+		return false
+	}
+
+	// The header only has second precision
+	return !v.lastModified.Truncate(time.Second).After(ifModifiedSince)
+}
+
+// Weak comparison for If-None-Match, strong comparison for If-Match, where
+// weak ETags never match. Clients send back the ETag withCompression gave
+// them, so its encoding suffix is dropped first.
+func etagMatches(header string, etag string, strong bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if candidate == "*" {
+			return true
+		}
+
+		if strong && (strings.HasPrefix(candidate, "W/") || strings.HasPrefix(etag, "W/")) {
+			continue
+		}
+
+		if strings.TrimPrefix(trimEncodingSuffix(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Encodings withCompression tags strong ETags with
+var etagEncodingSuffixes = []string{"-br", "-gzip"}
+
+// Turns `"abc-gzip"` back into `"abc"`
+func trimEncodingSuffix(etag string) string {
+	if strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	for _, suffix := range etagEncodingSuffixes {
+		if trimmed, found := strings.CutSuffix(etag, suffix+`"`); found {
+			return trimmed + `"`
+		}
+	}
+
+	return etag
+}
+
+// Sends a 412 or 304 when the conditional headers call for one, reporting
+// whether it did. The validators have to be set on `w` already.
+func answerConditional(w http.ResponseWriter, req *http.Request, valid validators) (bool, error) {
+	if !preconditionsHold(req, valid) {
+		w.Header().Del("Cache-Control")
+		return true, WriteProblem(w, req, http.StatusPreconditionFailed, "The resource changed since you fetched it")
+	}
+
+	if notModified(req, valid) {
+		w.WriteHeader(http.StatusNotModified)
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Like WriteJSON with a 200, but sends the validators, answers with a 304
+// when the client already has this version and with a 412 when it asked for
+// a version this isn't
+func writeCacheableJSON(w http.ResponseWriter, req *http.Request, cacheControl string, v any, valid validators) error {
+	body, err := json.Marshal(v)
+
+	if err != nil {
+		return err
+	}
+
+	// Same bytes WriteJSON would send
+	body = append(body, '\n')
+
+	if valid.etag == "" {
+		valid.etag = strongETag(body)
+	}
+
+	header := w.Header()
+	header.Set("ETag", valid.etag)
+	header.Set("Cache-Control", cacheControl)
+
+	if !valid.lastModified.IsZero() {
+		header.Set("Last-Modified", valid.lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if answered, err := answerConditional(w, req, valid); answered {
+		return err
+	}
+
+	header.Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+
+	return err
+}
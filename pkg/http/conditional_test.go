@@ -0,0 +1,153 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// A server with two gyms last changed an hour ago
+func newGymsTestServer(t *testing.T) (*APIServer, *memoryStore) {
+	t.Helper()
+
+	s, store := newAuthTestServer(t, &config.Config{CacheMaxAge: time.Minute})
+
+	for _, name := range []string{"Iron Temple", "Muscle Beach"} {
+		gym := domain.NewGym(name, "")
+		gym.UpdatedAt = time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+		store.CreateGym(context.Background(), gym)
+	}
+
+	return s, store
+}
+
+func get(handler http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestConditionalGetGym(t *testing.T) {
+	s, _ := newGymsTestServer(t)
+	handler := s.router()
+
+	first := get(handler, "/gyms/1", nil)
+	etag := first.Header().Get("ETag")
+	lastModified := first.Header().Get("Last-Modified")
+
+	if first.Code != http.StatusOK || etag == "" || lastModified == "" {
+		t.Fatalf("Got %d with ETag %q and Last-Modified %q", first.Code, etag, lastModified)
+	}
+
+	earlier := time.Now().Add(-2 * time.Hour).UTC().Format(http.TimeFormat)
+	later := time.Now().UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"matching If-None-Match", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"weak If-None-Match", http.Header{"If-None-Match": {"W/" + etag}}, http.StatusNotModified},
+		{"any If-None-Match", http.Header{"If-None-Match": {"*"}}, http.StatusNotModified},
+		{"stale If-None-Match", http.Header{"If-None-Match": {`"stale"`}}, http.StatusOK},
+		{"If-Modified-Since Last-Modified", http.Header{"If-Modified-Since": {lastModified}}, http.StatusNotModified},
+		{"If-Modified-Since before the change", http.Header{"If-Modified-Since": {earlier}}, http.StatusOK},
+		// If-None-Match wins
+		{"stale If-None-Match with If-Modified-Since", http.Header{"If-None-Match": {`"stale"`}, "If-Modified-Since": {later}}, http.StatusOK},
+		{"matching If-Match", http.Header{"If-Match": {etag}}, http.StatusOK},
+		{"stale If-Match", http.Header{"If-Match": {`"stale"`}}, http.StatusPreconditionFailed},
+		// If-Match compares strongly
+		{"weak If-Match", http.Header{"If-Match": {"W/" + etag}}, http.StatusPreconditionFailed},
+		{"If-Unmodified-Since now", http.Header{"If-Unmodified-Since": {later}}, http.StatusOK},
+		{"If-Unmodified-Since before the change", http.Header{"If-Unmodified-Since": {earlier}}, http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(handler, "/gyms/1", tt.header)
+
+			if rec.Code != tt.want {
+				t.Fatalf("Got %d %s, want %d", rec.Code, rec.Body.String(), tt.want)
+			}
+
+			if rec.Code == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag) {
+				t.Errorf("304 with body %q and ETag %q, want no body and %q", rec.Body.String(), rec.Header().Get("ETag"), etag)
+			}
+		})
+	}
+}
+
+// Each encoding of a gym is different bytes, so each gets its own strong
+// ETag, and revalidating with it works
+func TestGymETagPerEncoding(t *testing.T) {
+	s, _ := newGymsTestServer(t)
+	handler := s.middleware(s.router())
+
+	etags := map[string]string{}
+
+	for _, encoding := range []string{"", "gzip", "br"} {
+		rec := get(handler, "/gyms/1", http.Header{"Accept-Encoding": {encoding}})
+		etag := rec.Header().Get("ETag")
+
+		if rec.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%q: got %d with ETag %q", encoding, rec.Code, etag)
+		}
+
+		for other, otherETag := range etags {
+			if otherETag == etag {
+				t.Errorf("%q and %q share the ETag %s", encoding, other, etag)
+			}
+		}
+
+		etags[encoding] = etag
+
+		revalidated := get(handler, "/gyms/1", http.Header{"Accept-Encoding": {encoding}, "If-None-Match": {etag}})
+
+		if revalidated.Code != http.StatusNotModified || revalidated.Header().Get("ETag") != etag {
+			t.Errorf("%q: revalidating got %d with ETag %q, want 304 with %q", encoding, revalidated.Code, revalidated.Header().Get("ETag"), etag)
+		}
+
+		if rec := get(handler, "/gyms/1", http.Header{"Accept-Encoding": {encoding}, "If-Match": {etag}}); rec.Code != http.StatusOK {
+			t.Errorf("%q: If-Match with its own ETag got %d, want 200", encoding, rec.Code)
+		}
+	}
+}
+
+// Deleting a gym leaves the others untouched, the list must still change
+func TestConditionalGetGymsAfterDelete(t *testing.T) {
+	s, _ := newGymsTestServer(t)
+	handler := s.router()
+
+	first := get(handler, "/gyms", nil)
+	etag := first.Header().Get("ETag")
+	lastModified := first.Header().Get("Last-Modified")
+
+	if rec := get(handler, "/gyms", http.Header{"If-Modified-Since": {lastModified}}); rec.Code != http.StatusNotModified {
+		t.Fatalf("Unchanged list: got %d, want 304", rec.Code)
+	}
+
+	if rec := doJSON(t, handler, "DELETE", "/gyms/2", nil, nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("Delete: got %d %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := get(handler, "/gyms", http.Header{"If-Modified-Since": {lastModified}}); rec.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since after a delete: got %d, want 200", rec.Code)
+	}
+
+	if rec := get(handler, "/gyms", http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusOK {
+		t.Errorf("If-None-Match after a delete: got %d, want 200", rec.Code)
+	}
+}
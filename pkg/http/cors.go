@@ -0,0 +1,60 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Headers our clients send, and the ones browsers may let them read
+var (
+	corsAllowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	corsAllowedHeaders = []string{"Content-Type", "X-JWT-Token", "X-API-Key", "If-None-Match", "If-Modified-Since", requestIDHeader, idempotencyKeyHeader}
+	corsExposedHeaders = []string{requestIDHeader, "Retry-After", "ETag", "Last-Modified", idempotentReplayedHeader, "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+)
+
+// Lets the web frontend call us from the origins in CORS_ALLOWED_ORIGINS.
+// Requests from other origins get no CORS headers, so browsers block them.
+func (s *APIServer) withCORS(next http.Handler) http.Handler {
+	allowAll := slices.Contains(s.config.CORSAllowedOrigins, "*")
+	maxAge := strconv.Itoa(int(s.config.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+
+		if origin == "" {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		if !allowAll && !slices.Contains(s.config.CORSAllowedOrigins, origin) {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		header.Set("Access-Control-Allow-Origin", origin)
+
+		if s.config.CORSAllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		// Preflight, answered here instead of by the routes
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			header.Add("Vary", "Access-Control-Request-Method")
+			header.Add("Vary", "Access-Control-Request-Headers")
+			header.Set("Access-Control-Allow-Methods", strings.Join(corsAllowedMethods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			header.Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		header.Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+
+		next.ServeHTTP(w, req)
+	})
+}
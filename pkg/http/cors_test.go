@@ -0,0 +1,111 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+func newCORSTestHandler(t *testing.T, origins ...string) http.Handler {
+	t.Helper()
+
+	s, _ := newAuthTestServer(t, &config.Config{CORSAllowedOrigins: origins, CORSMaxAge: 10 * time.Minute})
+
+	return s.middleware(s.router())
+}
+
+func TestCORS(t *testing.T) {
+	handler := newCORSTestHandler(t, "https://app.example")
+
+	tests := []struct {
+		name       string
+		origin     string
+		wantOrigin string
+	}{
+		{"allowed origin", "https://app.example", "https://app.example"},
+		{"disallowed origin", "https://evil.example", ""},
+		{"no origin", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(handler, "/gyms", http.Header{"Origin": {tt.origin}})
+
+			// Other origins still get the response, browsers just won't
+			// hand it to the page
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Got %d, want 200", rec.Code)
+			}
+
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Got Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+
+			if exposed := rec.Header().Get("Access-Control-Expose-Headers"); (exposed != "") != (tt.wantOrigin != "") {
+				t.Errorf("Got Access-Control-Expose-Headers %q", exposed)
+			}
+
+			if tt.origin != "" && !slices.Contains(rec.Header().Values("Vary"), "Origin") {
+				t.Errorf("Got Vary %q, want Origin in it", rec.Header().Values("Vary"))
+			}
+		})
+	}
+}
+
+func preflight(handler http.Handler, origin string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("OPTIONS", "/gyms", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestCORSPreflight(t *testing.T) {
+	handler := newCORSTestHandler(t, "https://app.example")
+
+	rec := preflight(handler, "https://app.example")
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Got %d, want 204", rec.Code)
+	}
+
+	want := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example",
+		"Access-Control-Allow-Methods": "GET, POST, PUT, PATCH, DELETE",
+		"Access-Control-Max-Age":       "600",
+	}
+
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("Got %s %q, want %q", name, got, value)
+		}
+	}
+
+	if allowed := rec.Header().Get("Access-Control-Allow-Headers"); allowed == "" {
+		t.Error("Got no Access-Control-Allow-Headers")
+	}
+
+	// Disallowed origins aren't answered by us, the mux has no OPTIONS route
+	rec = preflight(handler, "https://evil.example")
+
+	if rec.Code == http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Origin") != "" || rec.Header().Get("Access-Control-Allow-Methods") != "" {
+		t.Errorf("Disallowed preflight: got %d with %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCORSAllowAll(t *testing.T) {
+	handler := newCORSTestHandler(t, "*")
+
+	// The origin is echoed, never a literal `*`
+	if got := preflight(handler, "https://anywhere.example").Header().Get("Access-Control-Allow-Origin"); got != "https://anywhere.example" {
+		t.Errorf("Got Access-Control-Allow-Origin %q, want the origin", got)
+	}
+}
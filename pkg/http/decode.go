@@ -0,0 +1,71 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/grez-lucas/go-gym/pkg/validation"
+)
+
+// None of our requests come close, anything bigger is a mistake or abuse
+const maxRequestBodyBytes = 1 << 20
+
+// Decodes the JSON body into `v` and validates it. Unknown fields, trailing
+// data and bodies over maxRequestBodyBytes are rejected, failed rules come
+// back as validation.Errors.
+func decodeJSON(w http.ResponseWriter, req *http.Request, v any) error {
+	req.Body = http.MaxBytesReader(w, req.Body, maxRequestBodyBytes)
+
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+
+	// Only whitespace may follow. More() would miss a stray `}` or `]`.
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxBytesErr *http.MaxBytesError
+
+		if errors.As(err, &maxBytesErr) {
+			return err
+		}
+
+		return badRequest("Request body must hold a single JSON object")
+	}
+
+	return validation.Validate(v)
+}
+
+// Turns decoder errors into messages that make sense to clients
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	var invalidErr *json.InvalidUnmarshalError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return badRequest("Request body can't be empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return badRequest("Request body is malformed JSON")
+	case errors.As(err, &syntaxErr):
+		return badRequest("Request body is malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		return validation.Errors{{Field: typeErr.Field, Message: fmt.Sprintf("must be a %s", typeErr.Type.String())}}
+	case errors.As(err, &maxBytesErr):
+		return err
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return badRequest("Unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	case errors.As(err, &invalidErr):
+		// Our bug, not the client's
+		return err
+	}
+
+	// Read errors, like the client hanging up halfway through
+	return badRequest("Request body couldn't be read")
+}
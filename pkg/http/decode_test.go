@@ -0,0 +1,54 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type decodeTestRequest struct {
+	Name string `json:"name" validate:"required"`
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// 0 when the body should decode
+		want int
+	}{
+		{"valid", `{"name":"alice"}`, 0},
+		{"trailing whitespace", "{\"name\":\"alice\"}\n\t ", 0},
+		{"empty", ``, http.StatusBadRequest},
+		{"malformed", `{"name":`, http.StatusBadRequest},
+		{"unknown field", `{"name":"alice","admin":true}`, http.StatusBadRequest},
+		{"wrong type", `{"name":1}`, http.StatusUnprocessableEntity},
+		{"failed rule", `{}`, http.StatusUnprocessableEntity},
+		{"trailing brace", `{"name":"alice"}}`, http.StatusBadRequest},
+		{"trailing bracket", `{"name":"alice"}]`, http.StatusBadRequest},
+		{"trailing garbage", `{"name":"alice"}garbage`, http.StatusBadRequest},
+		{"second object", `{"name":"alice"}{"name":"bob"}`, http.StatusBadRequest},
+		{"too large", `{"name":"alice"}` + strings.Repeat(" ", maxRequestBodyBytes), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+
+			err := decodeJSON(httptest.NewRecorder(), req, new(decodeTestRequest))
+
+			if tt.want == 0 {
+				if err != nil {
+					t.Errorf("Got %v, want no error", err)
+				}
+
+				return
+			}
+
+			if got := errorStatus(err); got != tt.want {
+				t.Errorf("Got %v (%d), want %d", err, got, tt.want)
+			}
+		})
+	}
+}
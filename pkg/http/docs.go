@@ -0,0 +1,70 @@
+package http
+
+import (
+	"embed"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// The docs page, served from the binary so it works offline and doesn't pull
+// in scripts from a CDN
+//
+//go:embed docs
+var docsFS embed.FS
+
+// Loosens the default policy just enough for the page to load its own
+// scripts and styles and call the API
+const docsContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; img-src 'self' data:; base-uri 'none'; form-action 'none'; frame-ancestors 'none'"
+
+func (s *APIServer) handleGetDocs(w http.ResponseWriter, req *http.Request) error {
+	return s.writeDocsFile(w, req, "index.html")
+}
+
+func (s *APIServer) handleGetDocsAsset(w http.ResponseWriter, req *http.Request) error {
+	asset := req.PathValue("asset")
+
+	// Only the page's own scripts and styles
+	if asset == "index.html" || path.Ext(asset) == "" {
+		return WriteProblem(w, req, http.StatusNotFound, "No such asset")
+	}
+
+	return s.writeDocsFile(w, req, asset)
+}
+
+func (s *APIServer) writeDocsFile(w http.ResponseWriter, req *http.Request, name string) error {
+	body, err := fs.ReadFile(docsFS, "docs/"+name)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return WriteProblem(w, req, http.StatusNotFound, "No such asset")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Security-Policy", docsContentSecurityPolicy)
+
+	return s.writeStatic(w, req, mime.TypeByExtension(path.Ext(name)), body)
+}
+
+// Serves bytes that only change with a deploy, revalidated by their hash
+func (s *APIServer) writeStatic(w http.ResponseWriter, req *http.Request, contentType string, body []byte) error {
+	etag := strongETag(body)
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", s.publicCacheControl())
+
+	if answered, err := answerConditional(w, req, validators{etag: etag}); answered {
+		return err
+	}
+
+	header.Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body)
+
+	return err
+}
@@ -0,0 +1,285 @@
+// Renders openapi.json into a page where every route can be tried out.
+// Everything is built with textContent so nothing from the document is ever
+// parsed as HTML.
+"use strict";
+
+const credentials = document.getElementById("credentials");
+
+credentials.addEventListener("submit", (event) => event.preventDefault());
+
+// Values for the security schemes in the document, by scheme name
+function credential(scheme) {
+  return credentials.elements[scheme === "jwt" ? "jwt" : "apiKey"].value;
+}
+
+function el(tag, attrs, ...children) {
+  const node = document.createElement(tag);
+
+  for (const [name, value] of Object.entries(attrs || {})) {
+    if (name === "class") {
+      node.className = value;
+    } else {
+      node.setAttribute(name, value);
+    }
+  }
+
+  for (const child of children) {
+    if (child === null || child === undefined) {
+      continue;
+    }
+
+    node.append(typeof child === "string" ? document.createTextNode(child) : child);
+  }
+
+  return node;
+}
+
+function resolve(spec, schema) {
+  if (schema && schema.$ref) {
+    return spec.components.schemas[schema.$ref.split("/").pop()];
+  }
+
+  return schema || {};
+}
+
+// A made up value matching the schema, to prefill bodies and show shapes
+function example(spec, schema, depth = 0) {
+  const name = schema && schema.$ref ? schema.$ref.split("/").pop() : null;
+  schema = resolve(spec, schema);
+
+  if (depth > 5) {
+    return name ? `<${name}>` : null;
+  }
+
+  if (schema.oneOf) {
+    return example(spec, schema.oneOf.find((s) => s.type !== "null"), depth + 1);
+  }
+
+  let type = schema.type;
+
+  if (Array.isArray(type)) {
+    type = type.find((t) => t !== "null");
+  }
+
+  switch (type) {
+    case "object": {
+      const value = {};
+
+      for (const [prop, propSchema] of Object.entries(schema.properties || {})) {
+        value[prop] = example(spec, propSchema, depth + 1);
+      }
+
+      if (schema.additionalProperties) {
+        value.key = example(spec, schema.additionalProperties, depth + 1);
+      }
+
+      return value;
+    }
+    case "array":
+      return [example(spec, schema.items, depth + 1)];
+    case "integer":
+      return schema.minimum ?? 1;
+    case "number":
+      return 4.5;
+    case "boolean":
+      return true;
+    case "string":
+      if (schema.format === "date-time") {
+        return new Date().toISOString();
+      }
+
+      if (schema.format === "email") {
+        return "user@example.com";
+      }
+
+      return "string";
+  }
+
+  return null;
+}
+
+function pretty(value) {
+  return JSON.stringify(value, null, 2);
+}
+
+function renderParameters(op) {
+  if (!op.parameters || op.parameters.length === 0) {
+    return null;
+  }
+
+  return el("section", {},
+    el("h4", {}, "Parameters"),
+    el("table", {},
+      el("tr", {}, el("th", {}, "Name"), el("th", {}, "In"), el("th", {}, "Description")),
+      ...op.parameters.map((p) =>
+        el("tr", {},
+          el("td", {}, el("code", {}, p.name), p.required ? " *" : ""),
+          el("td", {}, p.in),
+          el("td", {}, p.description || ""),
+        ),
+      ),
+    ),
+  );
+}
+
+function renderResponses(spec, op) {
+  const rows = Object.entries(op.responses).map(([status, response]) => {
+    const content = response.content || {};
+    const json = content["application/json"] || content["application/problem+json"];
+
+    return el("details", {},
+      el("summary", {}, el("code", {}, status), " ", response.description),
+      json ? el("pre", {}, pretty(example(spec, json.schema))) : el("p", {}, Object.keys(content).join(", ") || "No body"),
+    );
+  });
+
+  return el("section", {}, el("h4", {}, "Responses"), ...rows);
+}
+
+// A form sending the request with whatever was filled in
+function renderTry(spec, method, path, op) {
+  const inputs = (op.parameters || []).map((p) => ({
+    param: p,
+    input: el("input", { name: p.name, placeholder: p.in }),
+  }));
+
+  const body = op.requestBody
+    ? el("textarea", { spellcheck: "false" }, pretty(example(spec, op.requestBody.content["application/json"].schema)))
+    : null;
+
+  const output = el("pre", { hidden: "" });
+  const send = el("button", { type: "submit" }, "Send");
+
+  const form = el("form", { class: "try" },
+    el("h4", {}, "Try it"),
+    ...inputs.map(({ param, input }) => el("label", {}, el("code", {}, param.name), input)),
+    body,
+    send,
+    output,
+  );
+
+  form.addEventListener("submit", async (event) => {
+    event.preventDefault();
+
+    let url = path;
+    const query = new URLSearchParams();
+    const headers = {};
+
+    for (const { param, input } of inputs) {
+      if (param.in === "path") {
+        url = url.replace(`{${param.name}}`, encodeURIComponent(input.value));
+      } else if (param.in === "query" && input.value !== "") {
+        query.set(param.name, input.value);
+      } else if (param.in === "header" && input.value !== "") {
+        headers[param.name] = input.value;
+      }
+    }
+
+    for (const requirement of op.security || []) {
+      for (const scheme of Object.keys(requirement)) {
+        const value = credential(scheme);
+
+        if (value !== "") {
+          headers[spec.components.securitySchemes[scheme].name] = value;
+        }
+      }
+    }
+
+    if (body) {
+      headers["Content-Type"] = "application/json";
+    }
+
+    if (query.size > 0) {
+      url += "?" + query;
+    }
+
+    send.disabled = true;
+    output.hidden = false;
+    output.textContent = "…";
+
+    try {
+      const response = await fetch(url, {
+        method: method.toUpperCase(),
+        headers,
+        body: body ? body.value : undefined,
+        redirect: "manual",
+      });
+
+      const text = await response.text();
+      let shown = text;
+
+      try {
+        shown = pretty(JSON.parse(text));
+      } catch {
+        // Not JSON, show it as is
+      }
+
+      output.textContent = `${response.status} ${response.statusText}\n\n${shown}`;
+    } catch (err) {
+      output.textContent = String(err);
+    } finally {
+      send.disabled = false;
+    }
+  });
+
+  return form;
+}
+
+function renderOperation(spec, method, path, op) {
+  const requestBody = op.requestBody
+    ? el("section", {},
+      el("h4", {}, "Request body"),
+      el("pre", {}, pretty(example(spec, op.requestBody.content["application/json"].schema))),
+    )
+    : null;
+
+  return el("details", { class: `operation${op.deprecated ? " deprecated" : ""}`, id: op.operationId },
+    el("summary", {},
+      el("span", { class: `method ${method}` }, method.toUpperCase()),
+      el("span", { class: "path" }, path),
+      el("span", { class: "summary" }, op.summary),
+      op.security ? el("span", { class: "locked", title: "Needs credentials" }, "🔒") : null,
+    ),
+    el("div", { class: "operation-body" },
+      op.description ? el("p", {}, op.description) : null,
+      renderParameters(op),
+      requestBody,
+      renderResponses(spec, op),
+      renderTry(spec, method, path, op),
+    ),
+  );
+}
+
+function render(spec) {
+  document.title = spec.info.title;
+  document.getElementById("title").textContent = `${spec.info.title} ${spec.info.version}`;
+  document.getElementById("description").textContent = spec.info.description || "";
+
+  const main = document.getElementById("operations");
+  main.replaceChildren();
+
+  for (const tag of spec.tags) {
+    const operations = [];
+
+    for (const [path, item] of Object.entries(spec.paths)) {
+      for (const [method, op] of Object.entries(item)) {
+        if (op.tags.includes(tag.name)) {
+          operations.push(renderOperation(spec, method, path, op));
+        }
+      }
+    }
+
+    main.append(el("section", {},
+      el("h2", {}, tag.name),
+      el("p", { class: "summary" }, tag.description || ""),
+      ...operations,
+    ));
+  }
+}
+
+fetch("openapi.json")
+  .then((response) => response.json())
+  .then(render)
+  .catch((err) => {
+    document.getElementById("operations").replaceChildren(el("p", {}, `Couldn't load the API document: ${err}`));
+  });
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/mail"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+// Emails a verification link for `email`. The account only gets the address
+// once the link is followed, so a typo or someone else's address never
+// replaces a working one.
+func (s *APIServer) sendEmailVerification(ctx context.Context, acc *domain.Account, email string) error {
+	// Only the latest link works
+	if err := s.store.DeleteAccountTokens(ctx, acc.ID, domain.PurposeVerifyEmail); err != nil {
+		return err
+	}
+
+	raw, err := generateOpaqueToken(32)
+
+	if err != nil {
+		return err
+	}
+
+	token := domain.NewAccountToken(acc.ID, domain.PurposeVerifyEmail, hashToken(raw), s.config.EmailVerificationTTL)
+	token.Email = email
+
+	if _, err := s.store.CreateAccountToken(ctx, token); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", s.config.PublicURL, url.QueryEscape(raw))
+
+	msg := mail.Message{
+		To:      email,
+		Subject: "Verify your Go Gym email address",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nConfirm this is your email address by following the link below. It expires in %s.\n\n%s\n\nIf you didn't ask for this you can ignore this email.\n",
+			acc.UserName, s.config.EmailVerificationTTL, link,
+		),
+	}
+
+	return s.mailer.Send(msg)
+}
+
+func (s *APIServer) handleVerifyEmail(w http.ResponseWriter, req *http.Request) error {
+	var verifyRequest domain.VerifyEmailRequest
+
+	if err := decodeJSON(w, req, &verifyRequest); err != nil {
+		return err
+	}
+
+	token, err := s.store.ConsumeEmailVerificationToken(req.Context(), hashToken(verifyRequest.Token))
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return badRequest("Invalid or expired verification token")
+	}
+
+	// Signups only check verified addresses, so two accounts can wait on the
+	// same one. The first to verify gets it.
+	if errors.Is(err, storage.ErrConflict) {
+		return conflict("Email address already in use")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "Email address verified", "account_id", token.AccountID)
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Starts an email change, the new address is only used after it's verified
+func (s *APIServer) handleChangeEmail(w http.ResponseWriter, req *http.Request) error {
+	accountID, ok := AccountIDFromContext(req.Context())
+
+	if !ok {
+		return unauthorized("Unable to retrieve ID from context")
+	}
+
+	var changeRequest domain.ChangeEmailRequest
+
+	if err := decodeJSON(w, req, &changeRequest); err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByID(req.Context(), int(accountID))
+
+	if err != nil {
+		return err
+	}
+
+	if !storage.VerifyHashedPassword(changeRequest.Password, acc.Password) {
+		return forbidden("Invalid Password")
+	}
+
+	_, err = s.store.GetAccountByEmail(req.Context(), changeRequest.Email)
+
+	if err == nil {
+		return conflict("Email address already in use")
+	}
+
+	if !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	if err := s.sendEmailVerification(req.Context(), acc, changeRequest.Email); err != nil {
+		return err
+	}
+
+	// Let the current address know, in case the change wasn't the owner
+	if acc.Email != "" {
+		notice := mail.Message{
+			To:      acc.Email,
+			Subject: "Your Go Gym email address is being changed",
+			Body: fmt.Sprintf(
+				"Hi %s,\n\nSomeone asked to change the email address of your account to %s. If that wasn't you, change your password right away.\n",
+				acc.UserName, changeRequest.Email,
+			),
+		}
+
+		if err := s.mailer.Send(notice); err != nil {
+			slog.ErrorContext(req.Context(), "Error notifying of email change", "error", err)
+		}
+	}
+
+	return WriteJSON(w, http.StatusAccepted, map[string]string{"message": "Verification link sent to the new address"})
+}
+
+func (s *APIServer) handleResendEmailVerification(w http.ResponseWriter, req *http.Request) error {
+	accountID, ok := AccountIDFromContext(req.Context())
+
+	if !ok {
+		return unauthorized("Unable to retrieve ID from context")
+	}
+
+	acc, err := s.store.GetAccountByID(req.Context(), int(accountID))
+
+	if err != nil {
+		return err
+	}
+
+	// The address waiting to be verified is in the last link we sent
+	pending, err := s.store.GetLatestAccountToken(req.Context(), acc.ID, domain.PurposeVerifyEmail)
+
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	if err != nil || pending.UsedAt != nil || pending.Email == "" {
+		if acc.IsEmailVerified() {
+			return conflict("Email address already verified")
+		}
+
+		return badRequest("Account has no email address, set one through PUT /accounts/me/email")
+	}
+
+	if err := s.sendEmailVerification(req.Context(), acc, pending.Email); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusAccepted, map[string]string{"message": "Verification link sent"})
+}
@@ -0,0 +1,239 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/mail"
+)
+
+// Keeps every email instead of sending it
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *recordingMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+
+	return nil
+}
+
+func (m *recordingMailer) sentTo(to string) []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sent []mail.Message
+
+	for _, msg := range m.messages {
+		if msg.To == to {
+			sent = append(sent, msg)
+		}
+	}
+
+	return sent
+}
+
+var tokenLink = regexp.MustCompile(`\?token=(\S+)`)
+
+// The token in the last link emailed to `to`
+func (m *recordingMailer) lastToken(t *testing.T, to string) string {
+	t.Helper()
+
+	sent := m.sentTo(to)
+
+	if len(sent) == 0 {
+		t.Fatalf("Nothing was emailed to %s", to)
+	}
+
+	match := tokenLink.FindStringSubmatch(sent[len(sent)-1].Body)
+
+	if match == nil {
+		t.Fatalf("No link in %q", sent[len(sent)-1].Body)
+	}
+
+	token, err := url.QueryUnescape(match[1])
+
+	if err != nil {
+		t.Fatalf("Error unescaping token: %v", err)
+	}
+
+	return token
+}
+
+func newMailTestServer(t *testing.T) (*APIServer, http.Handler, *memoryStore, *recordingMailer) {
+	t.Helper()
+
+	s, store := newAuthTestServer(t, &config.Config{
+		PublicURL:            "https://gym.example",
+		EmailVerificationTTL: time.Hour,
+		PasswordResetTTL:     time.Hour,
+	})
+
+	mailer := &recordingMailer{}
+	s.mailer = mailer
+
+	return s, s.router(), store, mailer
+}
+
+// Signs up and waits for the verification email
+func signUp(t *testing.T, s *APIServer, handler http.Handler, username string, email string) AccountV1 {
+	t.Helper()
+
+	var account AccountV1
+
+	rec := doJSON(t, handler, "POST", "/accounts", domain.CreateAccountRequest{UserName: username, Email: email, Password: "correct horse"}, nil, &account)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Signup: got %d %s, want 201", rec.Code, rec.Body.String())
+	}
+
+	s.background.Wait()
+
+	return account
+}
+
+func verifyEmail(t *testing.T, handler http.Handler, token string) int {
+	t.Helper()
+
+	return doJSON(t, handler, "POST", "/auth/email/verify", domain.VerifyEmailRequest{Token: token}, nil, nil).Code
+}
+
+func TestVerifyEmail(t *testing.T) {
+	s, handler, store, mailer := newMailTestServer(t)
+
+	account := signUp(t, s, handler, "alice", "alice@example.com")
+
+	// The address waits for the link
+	if acc, _ := store.GetAccountByID(context.Background(), account.ID); acc.Email != "" {
+		t.Fatalf("Got email %q before verifying", acc.Email)
+	}
+
+	token := mailer.lastToken(t, "alice@example.com")
+
+	if code := verifyEmail(t, handler, token); code != http.StatusNoContent {
+		t.Fatalf("Got %d, want 204", code)
+	}
+
+	if acc, _ := store.GetAccountByID(context.Background(), account.ID); !acc.IsEmailVerified() || acc.Email != "alice@example.com" {
+		t.Errorf("Got email %q verified at %v, want alice@example.com verified", acc.Email, acc.EmailVerifiedAt)
+	}
+
+	if code := verifyEmail(t, handler, token); code != http.StatusBadRequest {
+		t.Errorf("Reused link: got %d, want 400", code)
+	}
+
+	// Taken addresses are refused at signup
+	rec := doJSON(t, handler, "POST", "/accounts", domain.CreateAccountRequest{UserName: "bob", Email: "ALICE@example.com", Password: "correct horse"}, nil, nil)
+
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Signup with a verified address: got %d, want 409", rec.Code)
+	}
+}
+
+func TestVerifyEmailExpired(t *testing.T) {
+	s, handler, store, mailer := newMailTestServer(t)
+
+	signUp(t, s, handler, "alice", "alice@example.com")
+	token := mailer.lastToken(t, "alice@example.com")
+
+	store.mu.Lock()
+	store.accountTokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+	store.mu.Unlock()
+
+	if code := verifyEmail(t, handler, token); code != http.StatusBadRequest {
+		t.Errorf("Got %d, want 400", code)
+	}
+}
+
+// Two signups waiting on the same address: the first to verify gets it, the
+// second gets a conflict and its link isn't burned
+func TestVerifyEmailTakenMeanwhile(t *testing.T) {
+	s, handler, store, mailer := newMailTestServer(t)
+
+	signUp(t, s, handler, "alice", "shared@example.com")
+	first := mailer.lastToken(t, "shared@example.com")
+
+	bob := signUp(t, s, handler, "bob", "shared@example.com")
+	second := mailer.lastToken(t, "shared@example.com")
+
+	if code := verifyEmail(t, handler, first); code != http.StatusNoContent {
+		t.Fatalf("First: got %d, want 204", code)
+	}
+
+	if code := verifyEmail(t, handler, second); code != http.StatusConflict {
+		t.Fatalf("Second: got %d, want 409", code)
+	}
+
+	tokens, _ := store.GetAccountTokensByAccount(context.Background(), bob.ID)
+
+	if len(tokens) != 1 || tokens[0].UsedAt != nil {
+		t.Errorf("Got %+v, want bob's token left unused", tokens)
+	}
+
+	if acc, _ := store.GetAccountByID(context.Background(), bob.ID); acc.Email != "" {
+		t.Errorf("Bob got the address %q", acc.Email)
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	s, handler, store, mailer := newMailTestServer(t)
+
+	alice := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	store.VerifyAccountEmail(context.Background(), alice.ID, "alice@example.com")
+	token, _ := s.CreateJWT(alice)
+
+	change := domain.ChangeEmailRequest{Email: "new@example.com", Password: "wrong"}
+
+	if rec := doJSON(t, handler, "PUT", "/accounts/me/email", change, jwtHeader(token), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Wrong password: got %d, want 403", rec.Code)
+	}
+
+	change.Password = "correct horse"
+
+	if rec := doJSON(t, handler, "PUT", "/accounts/me/email", change, jwtHeader(token), nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("Got %d %s, want 202", rec.Code, rec.Body.String())
+	}
+
+	// The old address is told and kept until the new one is verified
+	if notices := mailer.sentTo("alice@example.com"); len(notices) != 1 {
+		t.Errorf("Got %d notices to the old address, want 1", len(notices))
+	}
+
+	if acc, _ := store.GetAccountByID(context.Background(), alice.ID); acc.Email != "alice@example.com" {
+		t.Errorf("Got %q before verifying, want the old address", acc.Email)
+	}
+
+	// Resending goes to the pending address, and only the newest link works
+	first := mailer.lastToken(t, "new@example.com")
+
+	if rec := doJSON(t, handler, "POST", "/accounts/me/email/verification", nil, jwtHeader(token), nil); rec.Code != http.StatusAccepted {
+		t.Fatalf("Resend: got %d %s, want 202", rec.Code, rec.Body.String())
+	}
+
+	if code := verifyEmail(t, handler, first); code != http.StatusBadRequest {
+		t.Errorf("Replaced link: got %d, want 400", code)
+	}
+
+	if code := verifyEmail(t, handler, mailer.lastToken(t, "new@example.com")); code != http.StatusNoContent {
+		t.Fatalf("Got %d, want 204", code)
+	}
+
+	if acc, _ := store.GetAccountByID(context.Background(), alice.ID); acc.Email != "new@example.com" {
+		t.Errorf("Got %q, want the new address", acc.Email)
+	}
+
+	if rec := doJSON(t, handler, "POST", "/accounts/me/email/verification", nil, jwtHeader(token), nil); rec.Code != http.StatusConflict {
+		t.Errorf("Resend once verified: got %d, want 409", rec.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/grez-lucas/go-gym/pkg/storage"
+	"github.com/grez-lucas/go-gym/pkg/validation"
+)
+
+// Errors returned by handlers are sent as RFC 7807 problem details. The
+// `error` member repeats the detail for clients written against the
+// `{"error": ...}` bodies we used to send.
+type ProblemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Error    string `json:"error"`
+	// Set when the request failed validation, one entry per broken field
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+// Something wrong with the request itself, like a malformed body, missing
+// credentials or a state conflict. Sent with its status, a 400 when unset,
+// and the message as is, so keep it meant for clients.
+type RequestError struct {
+	Status  int
+	Message string
+}
+
+func (e *RequestError) Error() string {
+	return e.Message
+}
+
+func (e *RequestError) status() int {
+	if e.Status == 0 {
+		return http.StatusBadRequest
+	}
+
+	return e.Status
+}
+
+func requestError(status int, format string, args ...any) error {
+	return &RequestError{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+func badRequest(format string, args ...any) error {
+	return requestError(http.StatusBadRequest, format, args...)
+}
+
+func unauthorized(format string, args ...any) error {
+	return requestError(http.StatusUnauthorized, format, args...)
+}
+
+func forbidden(format string, args ...any) error {
+	return requestError(http.StatusForbidden, format, args...)
+}
+
+func notFound(format string, args ...any) error {
+	return requestError(http.StatusNotFound, format, args...)
+}
+
+func conflict(format string, args ...any) error {
+	return requestError(http.StatusConflict, format, args...)
+}
+
+// Anything we don't know to be the client's fault is ours
+func errorStatus(err error) int {
+	var validationErrs validation.Errors
+	var maxBytesErr *http.MaxBytesError
+	var requestErr *RequestError
+
+	switch {
+	case errors.As(err, &validationErrs):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &requestErr):
+		return requestErr.status()
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrConstraint):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, storage.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+func WriteProblem(w http.ResponseWriter, req *http.Request, status int, detail string) error {
+	return writeProblemDetails(w, ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.URL.Path,
+		Error:    detail,
+	})
+}
+
+func writeProblemDetails(w http.ResponseWriter, problem ProblemDetails) error {
+	if problem.Status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	return json.NewEncoder(w).Encode(problem)
+}
+
+func writeError(w http.ResponseWriter, req *http.Request, err error) {
+	status := errorStatus(err)
+	detail := err.Error()
+
+	// Server errors can carry driver messages and the like, clients only get
+	// told it's on us
+	switch status {
+	case http.StatusServiceUnavailable:
+		slog.ErrorContext(req.Context(), "Storage unavailable", "error", err)
+		detail = "Service unavailable, try again later"
+	case http.StatusInternalServerError:
+		slog.ErrorContext(req.Context(), "Request failed", "error", err)
+		detail = "Something went wrong on our end"
+	}
+
+	problem := ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: req.URL.Path,
+		Error:    detail,
+	}
+
+	var validationErrs validation.Errors
+	if errors.As(err, &validationErrs) {
+		problem.Errors = validationErrs
+	}
+
+	writeProblemDetails(w, problem)
+}
@@ -0,0 +1,91 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+	"github.com/grez-lucas/go-gym/pkg/validation"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"validation", validation.Errors{{Field: "name", Message: "is required"}}, http.StatusUnprocessableEntity},
+		{"body too large", &http.MaxBytesError{Limit: 1}, http.StatusRequestEntityTooLarge},
+		{"bad request", badRequest("Invalid id given %s", "x"), http.StatusBadRequest},
+		{"request error without a status", &RequestError{Message: "Bad"}, http.StatusBadRequest},
+		{"unauthorized", unauthorized("Invalid refresh token"), http.StatusUnauthorized},
+		{"forbidden", forbidden("Admin access required"), http.StatusForbidden},
+		{"not found", notFound("Unknown identity provider"), http.StatusNotFound},
+		{"conflict", conflict("Email address already in use"), http.StatusConflict},
+		{"wrapped request error", fmt.Errorf("Logging in: %w", unauthorized("Sign in failed")), http.StatusUnauthorized},
+		{"storage not found", &storage.Error{Kind: storage.ErrNotFound, Message: "Gym not found"}, http.StatusNotFound},
+		{"storage conflict", &storage.Error{Kind: storage.ErrConflict, Message: "Already exists"}, http.StatusConflict},
+		{"storage constraint", &storage.Error{Kind: storage.ErrConstraint, Message: "Invalid reference"}, http.StatusUnprocessableEntity},
+		{"storage unavailable", &storage.Error{Kind: storage.ErrUnavailable, Message: "Database unavailable"}, http.StatusServiceUnavailable},
+		{"unclassified", errors.New("pq: something broke"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorStatus(tt.err); got != tt.want {
+				t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// Every error is problem details, and only client errors show their message
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantDetail string
+	}{
+		{"client error", forbidden("Invalid code"), http.StatusForbidden, "Invalid code"},
+		{"unavailable", &storage.Error{Kind: storage.ErrUnavailable, Message: "dial tcp: connection refused"}, http.StatusServiceUnavailable, "Service unavailable, try again later"},
+		{"server error", errors.New("pq: relation \"accounts\" does not exist"), http.StatusInternalServerError, "Something went wrong on our end"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeError(rec, httptest.NewRequest("GET", "/gyms", nil), tt.err)
+
+			if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+				t.Errorf("Content-Type is %q, want application/problem+json", got)
+			}
+
+			var problem ProblemDetails
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+				t.Fatalf("Error decoding %q: %v", rec.Body.String(), err)
+			}
+
+			if rec.Code != tt.wantStatus || problem.Status != tt.wantStatus || problem.Detail != tt.wantDetail || problem.Error != tt.wantDetail {
+				t.Errorf("Got %d %+v, want %d with detail %q", rec.Code, problem, tt.wantStatus, tt.wantDetail)
+			}
+		})
+	}
+}
+
+// Requests without valid credentials get problem details too
+func TestWriteUnauthorized(t *testing.T) {
+	s, _ := newAuthTestServer(t, &config.Config{})
+
+	rec := doJSON(t, s.router(), "GET", "/accounts/me", nil, jwtHeader("not a token"), nil)
+
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("Got %d %s %q, want a 401 problem", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+}
@@ -0,0 +1,30 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/grez-lucas/go-gym/pkg/health"
+)
+
+// The process is up and serving. Dependencies aren't checked: a database
+// outage shouldn't get every replica restarted.
+func (s *APIServer) handleGetLivez(w http.ResponseWriter, req *http.Request) error {
+	return WriteJSON(w, http.StatusOK, health.Report{Status: health.StatusOK, Checks: map[string]health.CheckResult{}})
+}
+
+// Whether this replica should get traffic: not shutting down and every
+// registered check passing
+func (s *APIServer) handleGetReadyz(w http.ResponseWriter, req *http.Request) error {
+	if s.draining.Load() {
+		return WriteProblem(w, req, http.StatusServiceUnavailable, "Shutting down")
+	}
+
+	report := s.health.Run(req.Context())
+
+	if !report.OK() {
+		w.Header().Set("Retry-After", "5")
+		return WriteJSON(w, http.StatusServiceUnavailable, report)
+	}
+
+	return WriteJSON(w, http.StatusOK, report)
+}
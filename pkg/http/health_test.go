@@ -0,0 +1,67 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/health"
+)
+
+func newHealthTestServer(t *testing.T, checkErr error) (*APIServer, http.Handler) {
+	t.Helper()
+
+	s, _ := newAuthTestServer(t, &config.Config{})
+
+	s.health = health.NewRegistry(time.Second, 0)
+	s.health.Register("db", func(context.Context) error { return checkErr })
+
+	return s, s.router()
+}
+
+func TestReadyz(t *testing.T) {
+	_, handler := newHealthTestServer(t, nil)
+
+	for _, path := range []string{"/readyz", "/healthcheck"} {
+		var report health.Report
+
+		if rec := doJSON(t, handler, "GET", path, nil, nil, &report); rec.Code != http.StatusOK || !report.OK() || report.Checks["db"].Status != health.StatusOK {
+			t.Errorf("%s: got %d %+v, want 200 ok", path, rec.Code, report)
+		}
+	}
+}
+
+func TestReadyzFailingCheck(t *testing.T) {
+	_, handler := newHealthTestServer(t, errors.New("database down"))
+
+	for _, path := range []string{"/readyz", "/healthcheck"} {
+		rec := doJSON(t, handler, "GET", path, nil, nil, nil)
+
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: got %d with Retry-After %q, want 503 with one", path, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+// Dependencies don't affect liveness, a database outage shouldn't get every
+// replica restarted
+func TestLivezIgnoresChecks(t *testing.T) {
+	s, handler := newHealthTestServer(t, errors.New("database down"))
+	s.draining.Store(true)
+
+	if rec := doJSON(t, handler, "GET", "/livez", nil, nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("Got %d, want 200", rec.Code)
+	}
+}
+
+func TestReadyzWhileDraining(t *testing.T) {
+	s, handler := newHealthTestServer(t, nil)
+	s.draining.Store(true)
+
+	if rec := doJSON(t, handler, "GET", "/readyz", nil, nil, nil); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Got %d, want 503", rec.Code)
+	}
+}
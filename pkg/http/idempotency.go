@@ -0,0 +1,227 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// Set on responses replayed from an earlier request
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// Clients usually send UUIDs, anything printable and reasonably short goes
+var validIdempotencyKey = regexp.MustCompile(`^[\x21-\x7E]{1,255}$`)
+
+// How often expired keys are deleted
+const idempotencyPruneInterval = time.Hour
+
+// Who a key belongs to. Anonymous requests, like signups, are told apart by
+// their IP only: clients behind one address share keys, but a key reused
+// with another body is caught as a mismatch instead of silently running
+// again.
+func (s *APIServer) idempotencyScope(req *http.Request) string {
+	if apiKey, ok := APIKeyFromContext(req.Context()); ok {
+		return fmt.Sprintf("key:%d", apiKey.ID)
+	}
+
+	if accountID, ok := AccountIDFromContext(req.Context()); ok {
+		return accountIdempotencyScope(int(accountID))
+	}
+
+	hash := sha256.Sum256([]byte(s.clientIP(req)))
+
+	return "anonymous:" + hex.EncodeToString(hash[:])
+}
+
+func accountIdempotencyScope(accountID int) string {
+	return fmt.Sprintf("account:%d", accountID)
+}
+
+// Hashes what makes two requests the same. Salted with the key, since bodies
+// may hold passwords.
+func requestFingerprint(req *http.Request, key string, body []byte) string {
+	hash := sha256.New()
+
+	fmt.Fprintf(hash, "%s\n%s\n%s\n", key, req.Method, req.URL.Path)
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Makes retries of a request with the same Idempotency-Key header safe: the
+// first one runs, later ones get its response replayed. Reusing a key for a
+// different request is a 422. Server errors aren't stored, so those can be
+// retried for real.
+//
+// Has to sit inside the auth middlewares, keys are scoped per client.
+func (s *APIServer) WithIdempotency(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		key := req.Header.Get(idempotencyKeyHeader)
+
+		if key == "" {
+			handlerFunc(w, req)
+			return
+		}
+
+		if !validIdempotencyKey.MatchString(key) {
+			WriteProblem(w, req, http.StatusBadRequest, "Idempotency-Key must be 1 to 255 printable ASCII characters")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestBodyBytes))
+
+		if err != nil {
+			writeError(w, req, decodeError(err))
+			return
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := s.idempotencyScope(req)
+		fingerprint := requestFingerprint(req, key, body)
+
+		claimed, err := s.store.ClaimIdempotencyKey(req.Context(), domain.NewIdempotencyKey(scope, key, fingerprint, s.config.IdempotencyKeyTTL))
+
+		if errors.Is(err, storage.ErrConflict) {
+			s.replayIdempotent(w, req, scope, key, fingerprint)
+			return
+		}
+
+		if err != nil {
+			writeError(w, req, err)
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: w}
+		stored := false
+
+		// Frees the key after server errors and panics
+		defer func() {
+			if stored {
+				return
+			}
+
+			if err := s.store.DeleteIdempotencyKey(context.WithoutCancel(req.Context()), claimed.ID); err != nil {
+				slog.ErrorContext(req.Context(), "Error releasing idempotency key", "error", err)
+			}
+		}()
+
+		handlerFunc(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		if recorder.status >= 500 {
+			return
+		}
+
+		contentType := recorder.Header().Get("Content-Type")
+
+		if err := s.store.CompleteIdempotencyKey(context.WithoutCancel(req.Context()), claimed.ID, recorder.status, contentType, recorder.body.Bytes()); err != nil {
+			slog.ErrorContext(req.Context(), "Error storing idempotent response", "error", err)
+			return
+		}
+
+		stored = true
+	}
+}
+
+func (s *APIServer) replayIdempotent(w http.ResponseWriter, req *http.Request, scope string, key string, fingerprint string) {
+	existing, err := s.store.GetIdempotencyKey(req.Context(), scope, key)
+
+	// Expired or released in the meantime, the client can simply retry
+	if errors.Is(err, storage.ErrNotFound) {
+		w.Header().Set("Retry-After", "1")
+		WriteProblem(w, req, http.StatusConflict, "Idempotency-Key was just released, retry the request")
+		return
+	}
+
+	if err != nil {
+		writeError(w, req, err)
+		return
+	}
+
+	if existing.RequestHash != fingerprint {
+		WriteProblem(w, req, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return
+	}
+
+	if !existing.Completed() {
+		w.Header().Set("Retry-After", "1")
+		WriteProblem(w, req, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	if existing.ResponseContentType != "" {
+		w.Header().Set("Content-Type", existing.ResponseContentType)
+	}
+
+	w.Header().Set(idempotentReplayedHeader, "true")
+	w.WriteHeader(existing.ResponseStatus)
+	w.Write(existing.ResponseBody)
+}
+
+// Deletes expired keys until `ctx` is cancelled
+func (s *APIServer) pruneIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(idempotencyPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := s.store.DeleteExpiredIdempotencyKeys(ctx)
+
+			if err != nil {
+				slog.ErrorContext(ctx, "Error deleting expired idempotency keys", "error", err)
+				continue
+			}
+
+			slog.DebugContext(ctx, "Deleted expired idempotency keys", "count", deleted)
+		}
+	}
+}
+
+// Keeps a copy of the response body while passing it on
+type bodyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bodyRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *bodyRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	r.body.Write(b)
+
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *bodyRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// A handler behind WithIdempotency that answers with how often it ran
+type countingHandler struct {
+	calls  int
+	status int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.calls++
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(h.status)
+	fmt.Fprintf(w, `{"call":%d}`, h.calls)
+}
+
+func newIdempotencyTestServer(t *testing.T) (*APIServer, *memoryStore, *countingHandler, http.HandlerFunc) {
+	t.Helper()
+
+	s, store := newAuthTestServer(t, &config.Config{IdempotencyKeyTTL: time.Hour})
+	handler := &countingHandler{status: http.StatusCreated}
+
+	return s, store, handler, s.WithIdempotency(handler.ServeHTTP)
+}
+
+// Sends a POST as the account, anonymously when it's 0
+func postIdempotent(handler http.Handler, accountID int64, remoteAddr string, key string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/accounts", strings.NewReader(body))
+	req.RemoteAddr = remoteAddr
+
+	if key != "" {
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+
+	if accountID != 0 {
+		req = req.WithContext(context.WithValue(req.Context(), ContextAccountKey, accountID))
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	_, _, counter, handler := newIdempotencyTestServer(t)
+
+	first := postIdempotent(handler, 1, "10.0.0.1:1234", "key-1", `{"name":"a"}`)
+	retry := postIdempotent(handler, 1, "10.0.0.2:1234", "key-1", `{"name":"a"}`)
+
+	if counter.calls != 1 {
+		t.Fatalf("Handler ran %d times, want once", counter.calls)
+	}
+
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Retry got %d %q, want the first response %d %q", retry.Code, retry.Body, first.Code, first.Body)
+	}
+
+	if first.Header().Get(idempotentReplayedHeader) != "" || retry.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Error("Only the retry should be marked as replayed")
+	}
+
+	// Without a key nothing is deduplicated
+	postIdempotent(handler, 1, "10.0.0.1:1234", "", `{"name":"a"}`)
+	postIdempotent(handler, 1, "10.0.0.1:1234", "", `{"name":"a"}`)
+
+	if counter.calls != 3 {
+		t.Errorf("Handler ran %d times, want 3", counter.calls)
+	}
+}
+
+func TestIdempotencyMismatch(t *testing.T) {
+	_, _, counter, handler := newIdempotencyTestServer(t)
+
+	postIdempotent(handler, 1, "10.0.0.1:1234", "key-1", `{"name":"a"}`)
+
+	if rec := postIdempotent(handler, 1, "10.0.0.1:1234", "key-1", `{"name":"b"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Reused key with another body: got %d, want 422", rec.Code)
+	}
+
+	// Keys of other accounts are their own
+	if rec := postIdempotent(handler, 2, "10.0.0.1:1234", "key-1", `{"name":"b"}`); rec.Code != http.StatusCreated {
+		t.Errorf("Same key from another account: got %d, want 201", rec.Code)
+	}
+
+	if counter.calls != 2 {
+		t.Errorf("Handler ran %d times, want 2", counter.calls)
+	}
+}
+
+func TestIdempotencyInProgress(t *testing.T) {
+	s, store, counter, handler := newIdempotencyTestServer(t)
+
+	body := `{"name":"a"}`
+	req := httptest.NewRequest("POST", "/accounts", strings.NewReader(body))
+	fingerprint := requestFingerprint(req, "key-1", []byte(body))
+
+	// Claimed by a request that hasn't answered yet
+	store.ClaimIdempotencyKey(context.Background(), domain.NewIdempotencyKey("account:1", "key-1", fingerprint, s.config.IdempotencyKeyTTL))
+
+	rec := postIdempotent(handler, 1, "10.0.0.1:1234", "key-1", body)
+
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Got %d with Retry-After %q, want 409 with one", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	if counter.calls != 0 {
+		t.Errorf("Handler ran %d times while the key was held", counter.calls)
+	}
+}
+
+// Server errors free the key so the retry runs for real
+func TestIdempotencyServerError(t *testing.T) {
+	_, _, counter, handler := newIdempotencyTestServer(t)
+	counter.status = http.StatusServiceUnavailable
+
+	postIdempotent(handler, 1, "10.0.0.1:1234", "key-1", `{"name":"a"}`)
+
+	counter.status = http.StatusCreated
+
+	if rec := postIdempotent(handler, 1, "10.0.0.1:1234", "key-1", `{"name":"a"}`); rec.Code != http.StatusCreated || rec.Header().Get(idempotentReplayedHeader) != "" {
+		t.Errorf("Retry after a server error: got %d %q, want a fresh 201", rec.Code, rec.Body)
+	}
+
+	if counter.calls != 2 {
+		t.Errorf("Handler ran %d times, want 2", counter.calls)
+	}
+}
+
+// Unrelated anonymous clients picking the same key don't see each other's
+// responses
+func TestIdempotencyAnonymousScope(t *testing.T) {
+	_, _, counter, handler := newIdempotencyTestServer(t)
+
+	postIdempotent(handler, 0, "10.0.0.1:1234", "1", `{"userName":"alice"}`)
+
+	if rec := postIdempotent(handler, 0, "10.0.0.2:1234", "1", `{"userName":"alice"}`); rec.Header().Get(idempotentReplayedHeader) != "" {
+		t.Error("Another IP got the response replayed")
+	}
+
+	// Same as for accounts, the key can't be reused for another signup
+	if rec := postIdempotent(handler, 0, "10.0.0.1:1234", "1", `{"userName":"bob"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Another body from the same IP: got %d, want 422", rec.Code)
+	}
+
+	if counter.calls != 2 {
+		t.Errorf("Handler ran %d times, want 2", counter.calls)
+	}
+
+	if rec := postIdempotent(handler, 0, "10.0.0.1:5678", "1", `{"userName":"alice"}`); rec.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("Retry from the same IP: got %d %q, want it replayed", rec.Code, rec.Body)
+	}
+}
+
+func TestIdempotencyInvalidKey(t *testing.T) {
+	_, _, counter, handler := newIdempotencyTestServer(t)
+
+	for _, key := range []string{"has space", strings.Repeat("k", 256), "ünicode"} {
+		if rec := postIdempotent(handler, 1, "10.0.0.1:1234", key, `{}`); rec.Code != http.StatusBadRequest {
+			t.Errorf("Key %q: got %d, want 400", key, rec.Code)
+		}
+	}
+
+	if counter.calls != 0 {
+		t.Errorf("Handler ran %d times for invalid keys", counter.calls)
+	}
+}
+
+// A signup retried with the same key but another body is refused, not run
+// as a second signup
+func TestIdempotencyAnonymousSignupMismatch(t *testing.T) {
+	s, handler, store, _ := newMailTestServer(t)
+	s.config.IdempotencyKeyTTL = time.Hour
+
+	header := http.Header{idempotencyKeyHeader: {"signup-1"}}
+
+	signup := func(username string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		rec := doJSON(t, handler, "POST", "/accounts", domain.CreateAccountRequest{UserName: username, Email: username + "@example.com", Password: "correct horse"}, header, nil)
+		s.background.Wait()
+
+		return rec
+	}
+
+	if rec := signup("alice"); rec.Code != http.StatusCreated {
+		t.Fatalf("Got %d %s, want 201", rec.Code, rec.Body.String())
+	}
+
+	if rec := signup("bob"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Reused key for another signup: got %d, want 422", rec.Code)
+	}
+
+	if rec := signup("alice"); rec.Code != http.StatusCreated || rec.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Errorf("Retry: got %d, want the 201 replayed", rec.Code)
+	}
+
+	if accounts, _ := store.GetAccounts(context.Background()); len(accounts) != 1 {
+		t.Errorf("Got %d accounts, want 1", len(accounts))
+	}
+}
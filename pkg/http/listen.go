@@ -0,0 +1,147 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const unixAddrPrefix = "unix:"
+
+// Listens on TCP, or on a Unix socket for `unix:/path/to.sock` addresses
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixAddrPrefix)
+
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// A socket left behind by a crashed process would make Listen fail. Only
+	// sockets are removed, never regular files.
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("Error removing stale socket: %w", err)
+		}
+	}
+
+	return net.Listen("unix", path)
+}
+
+// How often certificate files are checked for changes
+const certCheckInterval = 30 * time.Second
+
+// Serves the certificate in certFile / keyFile and reloads it when the files
+// change, so renewed certificates are used without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+
+	if err := reloader.load(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (r *certReloader) load() error {
+	modTime, err := r.lastModified()
+
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+
+	if err != nil {
+		return fmt.Errorf("Error loading TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+// The latest modification time of the two files
+func (r *certReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Error reading TLS certificate: %w", err)
+		}
+
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// Checks for new files until `ctx` is cancelled. A broken certificate is
+// logged and the previous one kept, the next check tries again.
+func (r *certReloader) Run(ctx context.Context) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.reloadIfChanged()
+		}
+	}
+}
+
+func (r *certReloader) reloadIfChanged() {
+	modTime, err := r.lastModified()
+
+	if err != nil {
+		slog.Error("Error checking TLS certificate", "error", err)
+		return
+	}
+
+	r.mu.RLock()
+	changed := !modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		slog.Error("Error reloading TLS certificate, keeping the current one", "error", err)
+		return
+	}
+
+	slog.Info("Reloaded TLS certificate", "cert_file", r.certFile)
+}
@@ -0,0 +1,205 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenUnixRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gogym.sock")
+
+	// Closing a unix listener removes its file, a crash wouldn't
+	stale, err := net.Listen("unix", path)
+
+	if err != nil {
+		t.Fatalf("Error creating socket: %v", err)
+	}
+
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	listener, err := listen(unixAddrPrefix + path)
+
+	if err != nil {
+		t.Fatalf("Got %v, want the stale socket replaced", err)
+	}
+
+	defer listener.Close()
+
+	conn, err := net.Dial("unix", path)
+
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+
+	conn.Close()
+}
+
+func TestListenUnixKeepsRegularFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gogym.sock")
+
+	if err := os.WriteFile(path, []byte("important"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if listener, err := listen(unixAddrPrefix + path); err == nil {
+		listener.Close()
+		t.Fatal("Got a listener, want an error")
+	}
+
+	if body, err := os.ReadFile(path); err != nil || string(body) != "important" {
+		t.Errorf("Got %q, %v, want the file untouched", body, err)
+	}
+}
+
+// Writes a fresh self-signed certificate for `name` to the two files
+func writeCert(t *testing.T, certFile string, keyFile string, name string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Moves the files' modification time forward, file systems with coarse
+// timestamps could otherwise miss a quick rewrite
+func touch(t *testing.T, files ...string) {
+	t.Helper()
+
+	later := time.Now().Add(time.Minute)
+
+	for _, file := range files {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func servedName(t *testing.T, reloader *certReloader) string {
+	t.Helper()
+
+	cert, err := reloader.GetCertificate(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeCert(t, certFile, keyFile, "old.example")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+
+	if name := servedName(t, reloader); name != "old.example" {
+		t.Fatalf("Got %s, want old.example", name)
+	}
+
+	writeCert(t, certFile, keyFile, "new.example")
+	touch(t, certFile, keyFile)
+	reloader.reloadIfChanged()
+
+	if name := servedName(t, reloader); name != "new.example" {
+		t.Errorf("Got %s after renewal, want new.example", name)
+	}
+}
+
+func TestCertReloaderKeepsCertOnError(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	writeCert(t, certFile, keyFile, "old.example")
+
+	reloader, err := newCertReloader(certFile, keyFile)
+
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+
+	// Half written by a renewal: a new certificate with the old key
+	otherDir := t.TempDir()
+	writeCert(t, filepath.Join(otherDir, "cert.pem"), filepath.Join(otherDir, "key.pem"), "new.example")
+
+	newCert, err := os.ReadFile(filepath.Join(otherDir, "cert.pem"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, newCert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	touch(t, certFile)
+	reloader.reloadIfChanged()
+
+	if name := servedName(t, reloader); name != "old.example" {
+		t.Errorf("Got %s, want old.example kept", name)
+	}
+
+	// Missing files are no better
+	os.Remove(keyFile)
+	reloader.reloadIfChanged()
+
+	if name := servedName(t, reloader); name != "old.example" {
+		t.Errorf("Got %s, want old.example kept", name)
+	}
+
+	if _, err := newCertReloader(certFile, keyFile); err == nil {
+		t.Error("Got no error starting without a key")
+	}
+}
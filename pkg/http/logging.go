@@ -0,0 +1,100 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/logging"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// Incoming IDs are only reused when they can't mess up our logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// Remembers the status and size of the response for the access log
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+
+	return n, err
+}
+
+// Lets http.ResponseController reach the real writer
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// Tags every request with an ID, reusing the caller's X-Request-ID when it
+// sent a sane one, and writes an access log line once it's done. Records
+// logged with the request context carry the ID too.
+func withRequestLogging(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		requestID := req.Header.Get(requestIDHeader)
+
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, requestID)
+
+		ctx := logging.NewContext(req.Context(), slog.String("request_id", requestID))
+		req = req.WithContext(ctx)
+
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		slog.LogAttrs(ctx, level, "Request handled",
+			slog.String("method", req.Method),
+			slog.String("path", req.URL.Path),
+			slog.String("route", req.Pattern),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", req.RemoteAddr),
+			slog.String("user_agent", req.UserAgent()),
+		)
+	})
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/logging"
+)
+
+// Sends the default logger's JSON records to a buffer for the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var buf bytes.Buffer
+	logging.Setup(&config.Config{LogLevel: "info", LogFormat: "json"}, &buf)
+
+	return &buf
+}
+
+// The access log records in `logs`
+func accessLogs(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var records []map[string]any
+
+	scanner := bufio.NewScanner(logs)
+
+	for scanner.Scan() {
+		var record map[string]any
+
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Error decoding %q: %v", scanner.Text(), err)
+		}
+
+		if record["msg"] == "Request handled" {
+			records = append(records, record)
+		}
+	}
+
+	return records
+}
+
+func TestRequestID(t *testing.T) {
+	captureLogs(t)
+
+	s, _ := newAuthTestServer(t, &config.Config{})
+	handler := s.middleware(s.router())
+
+	tests := []struct {
+		name      string
+		requestID string
+		// Empty when a new one should be made up
+		want string
+	}{
+		{"valid", "abc-123_x.y", "abc-123_x.y"},
+		{"missing", "", ""},
+		{"with spaces", "abc 123", ""},
+		{"log injection", "abc\n{\"msg\":\"forged\"}", ""},
+		{"too long", strings.Repeat("a", 129), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(requestIDHeader, tt.requestID)
+
+			rec := get(handler, "/gyms", header)
+			got := rec.Header().Get(requestIDHeader)
+
+			if tt.want != "" {
+				if got != tt.want {
+					t.Errorf("Got %q, want %q echoed", got, tt.want)
+				}
+
+				return
+			}
+
+			if got == tt.requestID || !validRequestID.MatchString(got) {
+				t.Errorf("Got %q, want a new ID", got)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLogs(t)
+
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.middleware(s.router())
+
+	acc := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	token, _ := s.CreateJWT(acc)
+
+	header := jwtHeader(token)
+	header.Set(requestIDHeader, "access-log-test")
+
+	if rec := get(handler, "/accounts/me", header); rec.Code != http.StatusOK {
+		t.Fatalf("Got %d, want 200", rec.Code)
+	}
+
+	records := accessLogs(t, logs)
+
+	if len(records) != 1 {
+		t.Fatalf("Got %d access log records, want 1", len(records))
+	}
+
+	record := records[0]
+
+	want := map[string]any{
+		"method":     "GET",
+		"path":       "/accounts/me",
+		"route":      "GET /accounts/me",
+		"status":     200.0,
+		"request_id": "access-log-test",
+		// Added by the auth middleware, deep inside the chain
+		"account_id": float64(acc.ID),
+	}
+
+	for key, value := range want {
+		if record[key] != value {
+			t.Errorf("Got %s %v, want %v", key, record[key], value)
+		}
+	}
+
+	// The token itself never shows up
+	if strings.Contains(logs.String(), token) {
+		t.Error("The JWT made it to the logs")
+	}
+}
@@ -0,0 +1,210 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+// Progressive lockout: once a key reaches its failure threshold it's locked
+// for loginLockoutBase, doubling with every further failure up to
+// loginLockoutMax. Failures older than loginFailureWindow are forgotten.
+const (
+	loginAccountMaxFailures = 5
+	loginIPMaxFailures      = 20
+	loginLockoutBase        = time.Minute
+	loginLockoutMax         = time.Hour
+	loginFailureWindow      = 24 * time.Hour
+)
+
+// Every credential problem gets this same message so the response doesn't
+// tell apart unknown usernames from wrong passwords.
+const invalidCredentialsMessage = "Invalid username or password"
+
+func accountLoginKey(username string) string {
+	return "account:" + strings.ToLower(username)
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// The address of the client. Proxy headers are only trusted when we run
+// behind a proxy that sets them, otherwise anyone could pick their own IP.
+func (s *APIServer) clientIP(req *http.Request) string {
+	if s.config.TrustProxyHeaders {
+		if realIP := req.Header.Get("X-Real-IP"); realIP != "" {
+			return realIP
+		}
+
+		// Our proxy appends the address it saw to the end of the list
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		return req.RemoteAddr
+	}
+
+	return host
+}
+
+func (s *APIServer) handleLogin(w http.ResponseWriter, req *http.Request) error {
+	var loginRequest LoginRequest
+
+	if err := decodeJSON(w, req, &loginRequest); err != nil {
+		return err
+	}
+
+	accountKey := accountLoginKey(loginRequest.Username)
+	ipKey := ipLoginKey(s.clientIP(req))
+
+	// Locked keys are rejected before touching the password at all
+	for _, key := range []string{accountKey, ipKey} {
+		attempts, err := s.store.GetLoginAttempts(req.Context(), key)
+
+		if err != nil {
+			return err
+		}
+
+		if attempts.IsLocked() {
+			return writeLoginLocked(w, req, *attempts.LockedUntil)
+		}
+	}
+
+	acc, err := s.store.GetAccountByUsername(req.Context(), loginRequest.Username)
+
+	// An outage isn't a failed attempt
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	// Unknown users still pay for a bcrypt comparison so response times
+	// don't give away which usernames exist
+	hash := s.dummyPasswordHash
+	if err == nil {
+		hash = acc.Password
+	}
+
+	if !storage.VerifyHashedPassword(loginRequest.Password, hash) || err != nil {
+		if err := s.recordLoginFailure(req.Context(), accountKey, loginAccountMaxFailures); err != nil {
+			return err
+		}
+
+		if err := s.recordLoginFailure(req.Context(), ipKey, loginIPMaxFailures); err != nil {
+			return err
+		}
+
+		metrics.AuthFailures.WithLabelValues("password").Inc()
+		return unauthorized(invalidCredentialsMessage)
+	}
+
+	// The IP counter is left alone, otherwise an attacker could reset it by
+	// logging into their own account between guesses
+	if err := s.store.ResetLoginAttempts(req.Context(), accountKey); err != nil {
+		return err
+	}
+
+	return s.completeLogin(w, req, acc)
+}
+
+func (s *APIServer) recordLoginFailure(ctx context.Context, key string, maxFailures int) error {
+	attempts, err := s.store.RecordLoginFailure(ctx, key, loginFailureWindow)
+
+	if err != nil {
+		return err
+	}
+
+	if attempts.Failures < maxFailures {
+		return nil
+	}
+
+	lockout := loginLockoutDuration(attempts.Failures - maxFailures)
+	slog.Warn("Locking logins", "key", key, "lockout", lockout.String(), "failures", attempts.Failures)
+
+	return s.store.LockLogin(ctx, key, time.Now().UTC().Add(lockout))
+}
+
+func loginLockoutDuration(excessFailures int) time.Duration {
+	lockout := float64(loginLockoutBase) * math.Pow(2, float64(excessFailures))
+
+	return time.Duration(min(lockout, float64(loginLockoutMax)))
+}
+
+func writeLoginLocked(w http.ResponseWriter, req *http.Request, until time.Time) error {
+	retryAfter := int(math.Ceil(time.Until(until).Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	return WriteProblem(w, req, http.StatusTooManyRequests, fmt.Sprintf("Too many failed login attempts, try again in %d seconds", retryAfter))
+}
+
+// Only lets admins through, must be wrapped by WithJWTAuth
+func (s *APIServer) WithAdmin(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		accountID, ok := AccountIDFromContext(req.Context())
+
+		if !ok {
+			WriteUnauthorized(w, req)
+			return
+		}
+
+		acc, err := s.store.GetAccountByID(req.Context(), int(accountID))
+
+		// An outage shouldn't read as a missing permission
+		if errors.Is(err, storage.ErrUnavailable) {
+			writeError(w, req, err)
+			return
+		}
+
+		if err != nil || !acc.IsAdmin() {
+			writeError(w, req, forbidden("Admin access required"))
+			return
+		}
+
+		// Logins of accounts with 2FA always went through it, so checking
+		// the account is enough to know this session did
+		if s.config.RequireAdmin2FA && !acc.IsTOTPEnabled() {
+			writeError(w, req, forbidden("Admins must enable two-factor authentication first"))
+			return
+		}
+
+		handlerFunc(w, req)
+	}
+}
+
+// Lifts the login lockout of an account before it expires on its own
+func (s *APIServer) handleUnlockAccount(w http.ResponseWriter, req *http.Request) error {
+	id, err := GetID(req)
+	if err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByID(req.Context(), id)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.ResetLoginAttempts(req.Context(), accountLoginKey(acc.UserName)); err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "Login lockout lifted", "unlocked_account_id", acc.ID)
+
+	return WriteJSON(w, http.StatusOK, map[string]int{"Account successfully unlocked": acc.ID})
+}
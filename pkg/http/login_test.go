@@ -0,0 +1,219 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginLockoutDuration(t *testing.T) {
+	tests := []struct {
+		excessFailures int
+		want           time.Duration
+	}{
+		{0, time.Minute},
+		{1, 2 * time.Minute},
+		{2, 4 * time.Minute},
+		{5, 32 * time.Minute},
+		{6, time.Hour},
+		{100, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := loginLockoutDuration(tt.excessFailures); got != tt.want {
+			t.Errorf("Got %v after %d failures over the threshold, want %v", got, tt.excessFailures, tt.want)
+		}
+	}
+}
+
+// Trusts X-Real-IP so tests can log in from different addresses
+func newLoginTestServer(t *testing.T) (*APIServer, http.Handler, *memoryStore, *domain.Account) {
+	t.Helper()
+
+	s, store := newAuthTestServer(t, &config.Config{TrustProxyHeaders: true})
+	alice := store.addAccount(domain.NewAccount("alice", "correct horse"))
+
+	return s, s.router(), store, alice
+}
+
+func tryLogin(t *testing.T, handler http.Handler, username string, password string, ip string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return doJSON(t, handler, "POST", "/auth/login", LoginRequest{Username: username, Password: password}, http.Header{"X-Real-Ip": {ip}}, nil)
+}
+
+func loginAttempts(store *memoryStore, key string) domain.LoginAttempts {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if attempts, ok := store.loginAttempts[key]; ok {
+		return *attempts
+	}
+
+	return domain.LoginAttempts{Key: key}
+}
+
+func TestAccountLockout(t *testing.T) {
+	_, handler, store, _ := newLoginTestServer(t)
+
+	for i := 0; i < loginAccountMaxFailures; i++ {
+		if rec := tryLogin(t, handler, "alice", "wrong", "192.0.2.1"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: got %d, want 401", i+1, rec.Code)
+		}
+	}
+
+	// Locked, even with the right password and from another address
+	rec := tryLogin(t, handler, "alice", "correct horse", "192.0.2.2")
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Got %d, want 429", rec.Code)
+	}
+
+	if retryAfter, _ := strconv.Atoi(rec.Header().Get("Retry-After")); retryAfter < 59 || retryAfter > 60 {
+		t.Errorf("Got Retry-After %q, want 60", rec.Header().Get("Retry-After"))
+	}
+
+	// Every failure past the threshold doubles the lockout
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute} {
+		expireLock(store, accountLoginKey("alice"))
+		tryLogin(t, handler, "alice", "wrong", "192.0.2.1")
+
+		if got := time.Until(*loginAttempts(store, accountLoginKey("alice")).LockedUntil); got < want-time.Second || got > want {
+			t.Errorf("Got a %v lockout, want %v", got, want)
+		}
+	}
+}
+
+// Pretends the lockout ran out
+func expireLock(store *memoryStore, key string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	past := time.Now().UTC().Add(-time.Second)
+	store.loginAttempts[key].LockedUntil = &past
+}
+
+// An address guessing across many usernames is locked out on its own
+func TestIPLockout(t *testing.T) {
+	_, handler, _, _ := newLoginTestServer(t)
+
+	for i := 0; i < loginIPMaxFailures; i++ {
+		if rec := tryLogin(t, handler, fmt.Sprintf("user%d", i), "wrong", "192.0.2.1"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: got %d, want 401", i+1, rec.Code)
+		}
+	}
+
+	if rec := tryLogin(t, handler, "alice", "correct horse", "192.0.2.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Locked address: got %d, want 429", rec.Code)
+	}
+
+	if rec := tryLogin(t, handler, "alice", "correct horse", "192.0.2.2"); rec.Code != http.StatusOK {
+		t.Errorf("Another address: got %d, want 200", rec.Code)
+	}
+}
+
+func TestLoginSuccessResetsAccountFailures(t *testing.T) {
+	_, handler, store, _ := newLoginTestServer(t)
+
+	for i := 0; i < loginAccountMaxFailures-1; i++ {
+		tryLogin(t, handler, "alice", "wrong", "192.0.2.1")
+	}
+
+	if rec := tryLogin(t, handler, "alice", "correct horse", "192.0.2.1"); rec.Code != http.StatusOK {
+		t.Fatalf("Got %d, want 200", rec.Code)
+	}
+
+	if failures := loginAttempts(store, accountLoginKey("alice")).Failures; failures != 0 {
+		t.Errorf("Got %d account failures after logging in, want 0", failures)
+	}
+
+	// Otherwise logging into their own account would let an attacker keep guessing
+	if failures := loginAttempts(store, ipLoginKey("192.0.2.1")).Failures; failures != loginAccountMaxFailures-1 {
+		t.Errorf("Got %d address failures, want them kept", failures)
+	}
+}
+
+// Unknown usernames get the same answer as wrong passwords and count towards
+// lockouts the same way
+func TestLoginUnknownUser(t *testing.T) {
+	s, handler, store, _ := newLoginTestServer(t)
+
+	wrongPassword := tryLogin(t, handler, "alice", "wrong", "192.0.2.1")
+	unknownUser := tryLogin(t, handler, "bob", "wrong", "192.0.2.2")
+
+	if wrongPassword.Code != http.StatusUnauthorized || unknownUser.Code != http.StatusUnauthorized {
+		t.Fatalf("Got %d and %d, want 401", wrongPassword.Code, unknownUser.Code)
+	}
+
+	if wrongPassword.Body.String() != unknownUser.Body.String() {
+		t.Errorf("Responses differ: %s and %s", wrongPassword.Body.String(), unknownUser.Body.String())
+	}
+
+	if failures := loginAttempts(store, accountLoginKey("bob")).Failures; failures != 1 {
+		t.Errorf("Got %d failures for the unknown user, want 1", failures)
+	}
+
+	// The dummy comparison costs as much as a real one
+	if cost, err := bcrypt.Cost([]byte(s.dummyPasswordHash)); err != nil || cost != storage.PasswordHashCost {
+		t.Errorf("Got dummy hash cost %d (%v), want %d", cost, err, storage.PasswordHashCost)
+	}
+}
+
+// Without a dummy hash unknown usernames would answer faster, so the server
+// doesn't start without one
+func TestDummyPasswordHashRequired(t *testing.T) {
+	defer func(cost int) { storage.PasswordHashCost = cost }(storage.PasswordHashCost)
+
+	storage.PasswordHashCost = bcrypt.MaxCost + 1
+
+	if _, err := NewAPIServer(":0", nil, &config.Config{}, nil, nil, nil, nil); err == nil {
+		t.Error("Got a server, want an error")
+	}
+}
+
+// Usernames are capped like at signup, no account has a longer one
+func TestLoginLongUsername(t *testing.T) {
+	_, handler, _, _ := newLoginTestServer(t)
+
+	if rec := tryLogin(t, handler, strings.Repeat("a", 101), "wrong", "192.0.2.1"); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Got %d, want 422", rec.Code)
+	}
+}
+
+func TestUnlockAccount(t *testing.T) {
+	s, handler, store, alice := newLoginTestServer(t)
+
+	admin := domain.NewAccount("root", "correct horse")
+	admin.Role = domain.RoleAdmin
+	admin = store.addAccount(admin)
+
+	adminToken, _ := s.CreateJWT(admin)
+	aliceToken, _ := s.CreateJWT(alice)
+
+	for i := 0; i < loginAccountMaxFailures; i++ {
+		tryLogin(t, handler, "alice", "wrong", "192.0.2.1")
+	}
+
+	path := fmt.Sprintf("/admin/accounts/%d/unlock", alice.ID)
+
+	if rec := doJSON(t, handler, "POST", path, nil, jwtHeader(aliceToken), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Unlock by a non-admin: got %d, want 403", rec.Code)
+	}
+
+	if rec := doJSON(t, handler, "POST", path, nil, jwtHeader(adminToken), nil); rec.Code != http.StatusOK {
+		t.Fatalf("Unlock: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+
+	if rec := tryLogin(t, handler, "alice", "correct horse", "192.0.2.2"); rec.Code != http.StatusOK {
+		t.Errorf("Login after unlock: got %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+type UpdateAccountRequest struct {
+	UserName *string `json:"userName" validate:"min=3,max=100,username"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+	// Required when two-factor authentication is enabled
+	Code string `json:"code"`
+}
+
+// Everything we store about an account, as handed out by
+// `GET /accounts/me/export`
+type AccountExport struct {
+	ExportedAt time.Time    `json:"exportedAt"`
+	Account    AccountV1    `json:"account"`
+	Ratings    []RatingV1   `json:"ratings"`
+	Identities []IdentityV1 `json:"identities"`
+	Sessions   []SessionV1  `json:"sessions"`
+	APIKeys    []APIKeyV1   `json:"apiKeys"`
+	// Password reset and email verification links, including the address
+	// waiting to be verified
+	AccountTokens []AccountTokenV1 `json:"accountTokens"`
+	TwoFactor     TwoFactorV1      `json:"twoFactor"`
+	// Failed logins with this account's username, nil when there are none
+	LoginAttempts *LoginAttemptsV1 `json:"loginAttempts"`
+}
+
+func (s *APIServer) handleGetMe(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, NewAccountV1(acc))
+}
+
+// Only the username can be changed here, the email and password have their
+// own endpoints since they need verification
+func (s *APIServer) handleUpdateMe(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	var updateRequest UpdateAccountRequest
+
+	if err := decodeJSON(w, req, &updateRequest); err != nil {
+		return err
+	}
+
+	if updateRequest.UserName != nil && *updateRequest.UserName != acc.UserName {
+		if err := s.store.UpdateAccountUsername(req.Context(), acc.ID, *updateRequest.UserName); err != nil {
+			return err
+		}
+	}
+
+	return s.handleGetMe(w, req)
+}
+
+// Deletes the account for good. Ratings stay for the gym averages but are
+// anonymized, everything else the export holds goes with the account. The
+// responses kept for its idempotency keys, and its failed logins, aren't
+// tied to it in the database so they're dropped here.
+func (s *APIServer) handleDeleteMe(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	var deleteRequest DeleteAccountRequest
+
+	if err := decodeJSON(w, req, &deleteRequest); err != nil {
+		return err
+	}
+
+	if !storage.VerifyHashedPassword(deleteRequest.Password, acc.Password) {
+		return forbidden("Invalid Password")
+	}
+
+	if acc.IsTOTPEnabled() {
+		ok, err := s.verifyTOTPCode(req.Context(), acc, deleteRequest.Code)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return forbidden("Invalid code")
+		}
+	}
+
+	if err := s.store.DeleteAccount(req.Context(), acc.ID); err != nil {
+		return err
+	}
+
+	if err := s.store.ResetLoginAttempts(req.Context(), accountLoginKey(acc.UserName)); err != nil {
+		slog.WarnContext(req.Context(), "Error clearing login attempts of deleted account", "error", err)
+	}
+
+	// They'd expire after IDEMPOTENCY_KEY_TTL anyway
+	if err := s.store.DeleteIdempotencyKeysByScope(req.Context(), accountIdempotencyScope(acc.ID)); err != nil {
+		slog.WarnContext(req.Context(), "Error deleting idempotency keys of deleted account", "error", err)
+	}
+
+	slog.InfoContext(req.Context(), "Account deleted by its owner")
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) handleExportMe(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	ratings, err := s.store.GetRatingsByAccount(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	identities, err := s.store.GetIdentitiesByAccount(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.store.GetRefreshTokensByAccount(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	apiKeys, err := s.store.GetAPIKeysByCreator(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	accountTokens, err := s.store.GetAccountTokensByAccount(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	recoveryCodesLeft, err := s.store.CountRecoveryCodes(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	attempts, err := s.store.GetLoginAttempts(req.Context(), accountLoginKey(acc.UserName))
+
+	if err != nil {
+		return err
+	}
+
+	export := AccountExport{
+		ExportedAt: time.Now().UTC(),
+		Account:    NewAccountV1(acc),
+		Ratings:    mapSlice(ratings, NewRatingV1),
+		Identities: mapSlice(identities, NewIdentityV1),
+		Sessions:   mapSlice(sessions, NewSessionV1),
+		APIKeys:    mapSlice(apiKeys, NewAPIKeyV1),
+
+		AccountTokens: mapSlice(accountTokens, NewAccountTokenV1),
+		TwoFactor:     NewTwoFactorV1(acc, recoveryCodesLeft),
+	}
+
+	if attempts.Failures > 0 {
+		loginAttempts := NewLoginAttemptsV1(attempts)
+		export.LoginAttempts = &loginAttempts
+	}
+
+	filename := fmt.Sprintf("go-gym-export-%d-%s.json", acc.ID, export.ExportedAt.Format("20060102"))
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+
+	return WriteJSON(w, http.StatusOK, export)
+}
@@ -0,0 +1,203 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/totp"
+)
+
+func TestUpdateMeUserName(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	alice := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	token, _ := s.CreateJWT(alice)
+
+	gym, _ := store.CreateGym(context.Background(), domain.NewGym("Iron Temple", ""))
+	store.CreateRating(context.Background(), domain.NewRating(gym.ID, alice.ID, 5, alice.UserName, ""))
+
+	// A blank name is still a name the rules have to accept
+	for _, name := range []string{"", "   ", "al"} {
+		if rec := doJSON(t, handler, "PATCH", "/accounts/me", map[string]string{"userName": name}, jwtHeader(token), nil); rec.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Renaming to %q: got %d, want 422", name, rec.Code)
+		}
+	}
+
+	if ratings, _ := store.GetRatingsByAccount(context.Background(), alice.ID); ratings[0].UserName != "alice" {
+		t.Fatalf("Rejected rename changed the rating author to %q", ratings[0].UserName)
+	}
+
+	var account AccountV1
+
+	if rec := doJSON(t, handler, "PATCH", "/accounts/me", map[string]string{"userName": "alice2"}, jwtHeader(token), &account); rec.Code != http.StatusOK || account.UserName != "alice2" {
+		t.Fatalf("Rename: got %d %+v, want 200 with alice2", rec.Code, account)
+	}
+
+	if ratings, _ := store.GetRatingsByAccount(context.Background(), alice.ID); ratings[0].UserName != "alice2" {
+		t.Errorf("Got rating author %q after the rename, want alice2", ratings[0].UserName)
+	}
+
+	// Missing fields leave the name alone
+	if rec := doJSON(t, handler, "PATCH", "/accounts/me", map[string]string{}, jwtHeader(token), &account); rec.Code != http.StatusOK || account.UserName != "alice2" {
+		t.Errorf("Empty update: got %d %+v, want 200 with alice2", rec.Code, account)
+	}
+}
+
+// An account with a row in every table that holds something about it
+type seededAccount struct {
+	account  *domain.Account
+	token    string
+	secret   string
+	apiKeyID int
+}
+
+func seedAccountData(t *testing.T, s *APIServer, store *memoryStore) seededAccount {
+	t.Helper()
+
+	ctx := context.Background()
+	alice := store.addAccount(domain.NewAccount("alice", "correct horse"))
+
+	gym, _ := store.CreateGym(ctx, domain.NewGym("Iron Temple", ""))
+	store.CreateRating(ctx, domain.NewRating(gym.ID, alice.ID, 4, alice.UserName, "Good squat racks"))
+
+	store.CreateIdentity(ctx, domain.NewIdentity(alice.ID, "google", "alice-subject", "alice@gmail.example"))
+	store.CreateRefreshToken(ctx, domain.NewRefreshToken(alice.ID, "family", hashToken("refresh"), time.Hour))
+
+	pending := domain.NewAccountToken(alice.ID, domain.PurposeVerifyEmail, hashToken("verify"), time.Hour)
+	pending.Email = "alice@example.com"
+	store.CreateAccountToken(ctx, pending)
+
+	apiKey := store.addAPIKey(domain.NewAPIKey("kiosk", "prefix", hashToken("key"), nil, alice.ID, nil))
+
+	secret, _ := totp.GenerateSecret()
+	store.SetTOTPSecret(ctx, alice.ID, secret)
+	store.EnableTOTP(ctx, alice.ID)
+	store.ReplaceRecoveryCodes(ctx, alice.ID, []string{"a", "b", "c"})
+	store.UseRecoveryCode(ctx, alice.ID, "a")
+
+	store.RecordLoginFailure(ctx, accountLoginKey("alice"), loginFailureWindow)
+
+	key := domain.NewIdempotencyKey(accountIdempotencyScope(alice.ID), "retry-1", "hash", time.Hour)
+	claimed, _ := store.ClaimIdempotencyKey(ctx, key)
+	store.CompleteIdempotencyKey(ctx, claimed.ID, http.StatusCreated, "application/json", []byte(`{"userName":"alice"}`))
+
+	token, _ := s.CreateJWT(alice)
+
+	return seededAccount{account: alice, token: token, secret: secret, apiKeyID: apiKey.ID}
+}
+
+func TestExportMe(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	seeded := seedAccountData(t, s, store)
+
+	var export AccountExport
+
+	rec := doJSON(t, handler, "GET", "/accounts/me/export", nil, jwtHeader(seeded.token), &export)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+
+	if export.Account.ID != seeded.account.ID || len(export.Ratings) != 1 || export.Ratings[0].Review != "Good squat racks" {
+		t.Errorf("Got account %+v and ratings %+v", export.Account, export.Ratings)
+	}
+
+	if len(export.Identities) != 1 || len(export.Sessions) != 1 || len(export.APIKeys) != 1 {
+		t.Errorf("Got %d identities, %d sessions and %d API keys, want 1 each", len(export.Identities), len(export.Sessions), len(export.APIKeys))
+	}
+
+	if len(export.AccountTokens) != 1 || export.AccountTokens[0].Email != "alice@example.com" || export.AccountTokens[0].Purpose != domain.PurposeVerifyEmail {
+		t.Errorf("Got account tokens %+v, want the pending address", export.AccountTokens)
+	}
+
+	if !export.TwoFactor.Enabled || export.TwoFactor.EnabledAt == nil || export.TwoFactor.RecoveryCodesLeft != 2 {
+		t.Errorf("Got 2FA %+v, want enabled with 2 codes left", export.TwoFactor)
+	}
+
+	if export.LoginAttempts == nil || export.LoginAttempts.Failures != 1 {
+		t.Errorf("Got login attempts %+v, want 1 failure", export.LoginAttempts)
+	}
+
+	// Hashes and secrets stay out of it
+	for _, secret := range []string{seeded.secret, hashToken("refresh"), hashToken("verify"), hashToken("key"), "$2a$"} {
+		if strings.Contains(rec.Body.String(), secret) {
+			t.Errorf("Export holds %q", secret)
+		}
+	}
+}
+
+func TestDeleteMe(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	seeded := seedAccountData(t, s, store)
+	id := seeded.account.ID
+
+	code, _ := totp.Code(seeded.secret, totp.Counter(time.Now()))
+
+	if rec := doJSON(t, handler, "DELETE", "/accounts/me", DeleteAccountRequest{Password: "correct horse", Code: code}, jwtHeader(seeded.token), nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("Got %d %s, want 204", rec.Code, rec.Body.String())
+	}
+
+	ctx := context.Background()
+
+	if _, err := store.GetAccountByID(ctx, id); err == nil {
+		t.Error("Account wasn't deleted")
+	}
+
+	if identities, _ := store.GetIdentitiesByAccount(ctx, id); len(identities) != 0 {
+		t.Errorf("%d identities left", len(identities))
+	}
+
+	if sessions, _ := store.GetRefreshTokensByAccount(ctx, id); len(sessions) != 0 {
+		t.Errorf("%d sessions left", len(sessions))
+	}
+
+	if tokens, _ := store.GetAccountTokensByAccount(ctx, id); len(tokens) != 0 {
+		t.Errorf("%d account tokens left", len(tokens))
+	}
+
+	if codes, _ := store.CountRecoveryCodes(ctx, id); codes != 0 {
+		t.Errorf("%d recovery codes left", codes)
+	}
+
+	if attempts := loginAttempts(store, accountLoginKey("alice")); attempts.Failures != 0 {
+		t.Errorf("%d login failures left", attempts.Failures)
+	}
+
+	if _, err := store.GetIdempotencyKey(ctx, accountIdempotencyScope(id), "retry-1"); err == nil {
+		t.Error("Stored response of an idempotency key left")
+	}
+
+	// Kept, but without an author
+	if keys, _ := store.GetAPIKeysByCreator(ctx, 0); len(keys) != 1 || keys[0].ID != seeded.apiKeyID {
+		t.Errorf("Got API keys without a creator %+v, want the one alice made", keys)
+	}
+
+	if len(store.ratings) != 1 || store.ratings[0].AccountID != 0 || store.ratings[0].UserName != domain.DeletedUserName {
+		t.Errorf("Got rating %+v, want it anonymized", store.ratings[0])
+	}
+}
+
+func TestDeleteMeWrongPassword(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	seeded := seedAccountData(t, s, store)
+
+	if rec := doJSON(t, handler, "DELETE", "/accounts/me", DeleteAccountRequest{Password: "wrong"}, jwtHeader(seeded.token), nil); rec.Code != http.StatusForbidden {
+		t.Errorf("Got %d, want 403", rec.Code)
+	}
+
+	if _, err := store.GetAccountByID(context.Background(), seeded.account.ID); err != nil {
+		t.Error("Account was deleted")
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+)
+
+// The path part of the pattern the mux matched, like `/gyms/{id}`. Requests
+// that matched nothing share one label so random paths can't blow up the
+// number of series.
+func routeLabel(req *http.Request) string {
+	if req.Pattern == "" {
+		return "unmatched"
+	}
+
+	_, path, found := strings.Cut(req.Pattern, " ")
+
+	if !found {
+		return req.Pattern
+	}
+
+	return path
+}
+
+// Fills in req.Pattern before the middlewares run, instead of only on the
+// request the mux itself gets, so they can all label requests by route
+func withRoutePattern(router *http.ServeMux) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, req.Pattern = router.Handler(req)
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// Counts requests and their latency per route
+func withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+
+		metrics.HTTPRequestsInFlight.Inc()
+		defer metrics.HTTPRequestsInFlight.Dec()
+
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		route := routeLabel(req)
+
+		metrics.HTTPRequests.WithLabelValues(req.Method, route, strconv.Itoa(recorder.status)).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(req.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+// Only GET /metrics, for the metrics listener
+func metricsRouter() *http.ServeMux {
+	router := http.NewServeMux()
+	router.Handle("GET /metrics", metrics.Handler())
+
+	return router
+}
+
+// Serves metrics on METRICS_LISTEN_ADDR, which unlike the API should only be
+// reachable by Prometheus. Close the returned server to stop.
+func (s *APIServer) serveMetrics() (*http.Server, error) {
+	addr := s.config.MetricsListenAddr
+
+	listener, err := listen(addr)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error listening on %s: %w", addr, err)
+	}
+
+	server := &http.Server{
+		Handler:           metricsRouter(),
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+
+		if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", "error", err)
+		}
+	}()
+
+	return server, nil
+}
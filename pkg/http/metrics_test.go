@@ -0,0 +1,89 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// Scrapes the metrics listener's router and returns the value of the sample
+// written exactly as `series`, 0 when it isn't there yet
+func scrapeMetric(t *testing.T, series string) float64 {
+	t.Helper()
+
+	rec := get(metricsRouter(), "/metrics", nil)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Scraping: got %d, want 200", rec.Code)
+	}
+
+	scanner := bufio.NewScanner(rec.Body)
+
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), series+" ")
+
+		if !found {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(value, 64)
+
+		if err != nil {
+			t.Fatalf("Error parsing %q: %v", scanner.Text(), err)
+		}
+
+		return parsed
+	}
+
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	s, _, store, _ := newMailTestServer(t)
+	handler := s.middleware(s.router())
+
+	store.CreateGym(context.Background(), domain.NewGym("Iron Temple", ""))
+
+	series := map[string]string{
+		"gym":        `gogym_http_requests_total{method="GET",route="/gyms/{id}",status="200"}`,
+		"gymLatency": `gogym_http_request_duration_seconds_count{method="GET",route="/gyms/{id}"}`,
+		"unmatched":  `gogym_http_requests_total{method="GET",route="unmatched",status="404"}`,
+		"ratings":    `gogym_ratings_created_total`,
+		"signups":    `gogym_accounts_created_total{source="signup"}`,
+	}
+
+	before := map[string]float64{}
+
+	for name, line := range series {
+		before[name] = scrapeMetric(t, line)
+	}
+
+	get(handler, "/gyms/1", nil)
+	get(handler, "/no/such/route", nil)
+
+	signUp(t, s, handler, "alice", "alice@example.com")
+
+	alice, _ := store.GetAccountByUsername(context.Background(), "alice")
+	store.VerifyAccountEmail(context.Background(), alice.ID, "alice@example.com")
+	token, _ := s.CreateJWT(alice)
+
+	if rec := doJSON(t, handler, "POST", "/gyms/1/ratings", domain.CreateRatingRequest{Rating: 5}, jwtHeader(token), nil); rec.Code != http.StatusCreated {
+		t.Fatalf("Rating: got %d %s, want 201", rec.Code, rec.Body.String())
+	}
+
+	for name, line := range series {
+		if got := scrapeMetric(t, line) - before[name]; got != 1 {
+			t.Errorf("%s went up by %v, want 1", line, got)
+		}
+	}
+
+	// Not on the public router
+	if rec := get(handler, "/metrics", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("GET /metrics on the API: got %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,90 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Wraps a handler with behaviour shared by every route
+type Middleware func(http.Handler) http.Handler
+
+// Applies the middlewares so the first one runs first, ending in `handler`
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+// Turns a panicking handler into a 500 instead of a dropped connection, and
+// logs the stack trace
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		defer func() {
+			recovered := recover()
+
+			if recovered == nil {
+				return
+			}
+
+			// Used on purpose to abort a response, let net/http deal with it
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			slog.ErrorContext(req.Context(), "Handler panicked",
+				"error", fmt.Sprint(recovered),
+				"stack", string(debug.Stack()),
+			)
+
+			// Too late for an error response once the status went out
+			if recorder.status != 0 {
+				return
+			}
+
+			WriteProblem(recorder, req, http.StatusInternalServerError, "Internal server error")
+		}()
+
+		next.ServeHTTP(recorder, req)
+	})
+}
+
+// Browsers only get JSON from us, nothing should render or frame it. The docs
+// page is the one exception and relaxes the policy itself.
+func withSecurityHeaders(trustProxyHeaders bool) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			header := w.Header()
+
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			header.Set("Referrer-Policy", "no-referrer")
+			header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+			header.Set("Cross-Origin-Opener-Policy", "same-origin")
+			header.Set("Cross-Origin-Resource-Policy", "same-site")
+			// Most responses are private, routes serving public data say so
+			header.Set("Cache-Control", "no-store")
+
+			if isHTTPS(req, trustProxyHeaders) {
+				header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+			}
+
+			next.ServeHTTP(w, req)
+		})
+	}
+}
+
+// Whether the client connected over HTTPS, to us or to the proxy in front
+func isHTTPS(req *http.Request, trustProxyHeaders bool) bool {
+	if req.TLS != nil {
+		return true
+	}
+
+	return trustProxyHeaders && strings.EqualFold(req.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,95 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+// The full middleware chain in front of a router with handlers that panic
+func newPanicTestHandler(t *testing.T) http.Handler {
+	t.Helper()
+
+	s, _ := newAuthTestServer(t, &config.Config{})
+
+	router := http.NewServeMux()
+	router.HandleFunc("GET /panic", func(w http.ResponseWriter, req *http.Request) {
+		panic("boom")
+	})
+	router.HandleFunc("GET /panic-after-write", func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("boom")
+	})
+
+	return s.middleware(router)
+}
+
+func TestRecovery(t *testing.T) {
+	handler := newPanicTestHandler(t)
+
+	rec := get(handler, "/panic", http.Header{"Accept-Encoding": {"gzip"}})
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Got %d, want 500", rec.Code)
+	}
+
+	if contentType := rec.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		t.Errorf("Got Content-Type %q, want application/problem+json", contentType)
+	}
+
+	var problem ProblemDetails
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Error decoding %q: %v", rec.Body.String(), err)
+	}
+
+	// The panic value stays in the logs
+	if problem.Status != http.StatusInternalServerError || problem.Detail != "Internal server error" {
+		t.Errorf("Got %+v", problem)
+	}
+
+	// Once the status is out there's nothing left to change
+	if rec := get(handler, "/panic-after-write", nil); rec.Code != http.StatusAccepted {
+		t.Errorf("Panic after writing: got %d, want the 202 already sent", rec.Code)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	tests := []struct {
+		name              string
+		trustProxyHeaders bool
+		forwardedProto    string
+		wantHSTS          bool
+	}{
+		{"plain HTTP", false, "", false},
+		{"HTTPS at the proxy", true, "https", true},
+		{"untrusted X-Forwarded-Proto", false, "https", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newAuthTestServer(t, &config.Config{TrustProxyHeaders: tt.trustProxyHeaders})
+
+			rec := get(s.middleware(s.router()), "/gyms", http.Header{"X-Forwarded-Proto": {tt.forwardedProto}})
+
+			want := map[string]string{
+				"X-Content-Type-Options":  "nosniff",
+				"X-Frame-Options":         "DENY",
+				"Referrer-Policy":         "no-referrer",
+				"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'",
+			}
+
+			for name, value := range want {
+				if got := rec.Header().Get(name); got != value {
+					t.Errorf("Got %s %q, want %q", name, got, value)
+				}
+			}
+
+			if hsts := rec.Header().Get("Strict-Transport-Security"); (hsts != "") != tt.wantHSTS {
+				t.Errorf("Got Strict-Transport-Security %q, want it set: %v", hsts, tt.wantHSTS)
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/oidc"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+const (
+	oidcStateCookie   = "gogym_oidc"
+	oidcStateAudience = "go-gym-oidc-state"
+	oidcStateTTL      = 10 * time.Minute
+
+	// Settings of the mock provider served with OIDC_MOCK_ENABLED
+	oidcMockPath         = "/mock-oidc"
+	oidcMockClientID     = "go-gym"
+	oidcMockClientSecret = "mock-secret"
+)
+
+// What we need to remember between sending the user to the provider and them
+// coming back. It lives in a cookie signed with our JWT keys, so any replica
+// can finish the login.
+type oidcStateClaims struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+func newOIDCProviders(cfg *config.Config) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+
+	providerConfigs := cfg.OIDCProviders
+
+	if cfg.OIDCMockEnabled {
+		providerConfigs = append(providerConfigs, config.OIDCProviderConfig{
+			Name:         "mock",
+			Issuer:       cfg.PublicURL + oidcMockPath,
+			ClientID:     oidcMockClientID,
+			ClientSecret: oidcMockClientSecret,
+		})
+	}
+
+	for _, providerConfig := range providerConfigs {
+		providers[providerConfig.Name] = oidc.NewProvider(oidc.ProviderConfig{
+			Name:         providerConfig.Name,
+			Issuer:       providerConfig.Issuer,
+			ClientID:     providerConfig.ClientID,
+			ClientSecret: providerConfig.ClientSecret,
+			RedirectURL:  fmt.Sprintf("%s/auth/oidc/%s/callback", cfg.PublicURL, providerConfig.Name),
+			Scopes:       providerConfig.Scopes,
+		})
+	}
+
+	return providers
+}
+
+func (s *APIServer) handleGetOIDCProviders(w http.ResponseWriter, req *http.Request) error {
+	names := []string{}
+
+	for name := range s.oidcProviders {
+		names = append(names, name)
+	}
+
+	return WriteJSON(w, http.StatusOK, map[string][]string{"providers": names})
+}
+
+// Redirects the user to the provider's login page
+func (s *APIServer) handleOIDCLogin(w http.ResponseWriter, req *http.Request) error {
+	provider, ok := s.oidcProviders[req.PathValue("provider")]
+
+	if !ok {
+		return notFound("Unknown identity provider")
+	}
+
+	state, err := oidc.GenerateVerifier()
+	if err != nil {
+		return err
+	}
+
+	nonce, err := oidc.GenerateVerifier()
+	if err != nil {
+		return err
+	}
+
+	verifier, err := oidc.GenerateVerifier()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+
+	cookieValue, err := s.keys.Sign(&oidcStateClaims{
+		Provider: provider.Name(),
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.config.JWTIssuer,
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidcStateTTL)),
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	authURL, err := provider.AuthCodeURL(req.Context(), state, nonce, verifier, req.URL.Query().Get("login_hint"))
+
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, s.oidcStateCookie(cookieValue, int(oidcStateTTL.Seconds())))
+	http.Redirect(w, req, authURL, http.StatusFound)
+
+	return nil
+}
+
+// The provider sends the user back here with an authorization code
+func (s *APIServer) handleOIDCCallback(w http.ResponseWriter, req *http.Request) error {
+	provider, ok := s.oidcProviders[req.PathValue("provider")]
+
+	if !ok {
+		return notFound("Unknown identity provider")
+	}
+
+	// The state cookie is single use
+	http.SetCookie(w, s.oidcStateCookie("", -1))
+
+	query := req.URL.Query()
+
+	if providerError := query.Get("error"); providerError != "" {
+		return unauthorized("Sign in failed: %s", providerError)
+	}
+
+	cookie, err := req.Cookie(oidcStateCookie)
+
+	if err != nil {
+		return badRequest("Missing sign in state, start over")
+	}
+
+	stateClaims := &oidcStateClaims{}
+
+	_, err = jwt.ParseWithClaims(cookie.Value, stateClaims, s.keys.Keyfunc,
+		jwt.WithValidMethods(s.keys.ValidMethods()),
+		jwt.WithIssuer(s.config.JWTIssuer),
+		jwt.WithAudience(oidcStateAudience),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil ||
+		stateClaims.Provider != provider.Name() ||
+		subtle.ConstantTimeCompare([]byte(stateClaims.State), []byte(query.Get("state"))) != 1 {
+		return badRequest("Invalid sign in state, start over")
+	}
+
+	claims, err := provider.Exchange(req.Context(), query.Get("code"), stateClaims.Verifier)
+
+	if err != nil {
+		slog.WarnContext(req.Context(), "OIDC code exchange failed", "provider", provider.Name(), "error", err)
+		return unauthorized("Sign in failed")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(stateClaims.Nonce)) != 1 {
+		return unauthorized("Sign in failed")
+	}
+
+	acc, err := s.accountForIdentity(req.Context(), provider.Name(), claims)
+
+	if err != nil {
+		return err
+	}
+
+	return s.completeLogin(w, req, acc)
+}
+
+func (s *APIServer) oidcStateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/auth/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.config.PublicURL, "https://"),
+		// Lax so the cookie survives the redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// Finds the account linked to the external identity. Unknown identities are
+// linked to the account with the same verified email, or get a new account.
+func (s *APIServer) accountForIdentity(ctx context.Context, provider string, claims *oidc.IDTokenClaims) (*domain.Account, error) {
+	identity, err := s.store.GetIdentity(ctx, provider, claims.Subject)
+
+	if err == nil {
+		return s.store.GetAccountByID(ctx, identity.AccountID)
+	}
+
+	if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	// Linking by email needs both sides to vouch for the address, otherwise
+	// anyone could claim an account by signing up somewhere with its email
+	if claims.Email != "" && claims.EmailVerified {
+		acc, err := s.store.GetAccountByEmail(ctx, claims.Email)
+
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+
+		if err == nil && acc.IsEmailVerified() {
+			return s.linkIdentity(ctx, acc, provider, claims)
+		}
+	}
+
+	acc, err := s.provisionAccount(ctx, claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "Provisioned account for identity", "account_id", acc.ID, "provider", provider, "subject", claims.Subject)
+	metrics.AccountsCreated.WithLabelValues(provider).Inc()
+
+	return s.linkIdentity(ctx, acc, provider, claims)
+}
+
+func (s *APIServer) linkIdentity(ctx context.Context, acc *domain.Account, provider string, claims *oidc.IDTokenClaims) (*domain.Account, error) {
+	identity := domain.NewIdentity(acc.ID, provider, claims.Subject, claims.Email)
+
+	if _, err := s.store.CreateIdentity(ctx, identity); err != nil {
+		return nil, err
+	}
+
+	return acc, nil
+}
+
+func (s *APIServer) provisionAccount(ctx context.Context, claims *oidc.IDTokenClaims) (*domain.Account, error) {
+	username, err := s.availableUsername(ctx, claims)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Nobody knows this password, the account signs in through the provider
+	// until a password reset sets one
+	password, err := generateOpaqueToken(32)
+
+	if err != nil {
+		return nil, err
+	}
+
+	email := claims.Email
+
+	if _, err := s.store.GetAccountByEmail(ctx, email); email == "" || err == nil {
+		email = ""
+	}
+
+	acc, err := s.store.CreateAccount(ctx, domain.NewAccount(username, password))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if email == "" {
+		return acc, nil
+	}
+
+	// The provider vouches for the address, we don't have to
+	if claims.EmailVerified {
+		if err := s.store.VerifyAccountEmail(ctx, acc.ID, email); err != nil {
+			return nil, err
+		}
+		return s.store.GetAccountByID(ctx, acc.ID)
+	}
+
+	if err := s.sendEmailVerification(ctx, acc, email); err != nil {
+		slog.ErrorContext(ctx, "Error sending verification email", "account_id", acc.ID, "error", err)
+	}
+
+	return acc, nil
+}
+
+// Derives a username from the identity, adding a suffix while it's taken
+func (s *APIServer) availableUsername(ctx context.Context, claims *oidc.IDTokenClaims) (string, error) {
+	base := claims.PreferredUsername
+
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	base = usernameUnsafeChars.ReplaceAllString(base, "")
+
+	if base == "" {
+		base = "user"
+	}
+
+	if len(base) > 80 {
+		base = base[:80]
+	}
+
+	username := base
+
+	for range 5 {
+		_, err := s.store.GetAccountByUsername(ctx, username)
+
+		if errors.Is(err, storage.ErrNotFound) {
+			return username, nil
+		}
+
+		if err != nil {
+			return "", err
+		}
+
+		suffix, err := generateOpaqueToken(3)
+
+		if err != nil {
+			return "", err
+		}
+
+		username = base + "-" + usernameUnsafeChars.ReplaceAllString(suffix, "")
+	}
+
+	return "", fmt.Errorf("Can't find a free username for `%s`", base)
+}
+
+// Mounts the mock provider, only with OIDC_MOCK_ENABLED
+func (s *APIServer) registerMockOIDCProvider(router *http.ServeMux) {
+	mock, err := oidc.NewMockProvider(s.config.PublicURL+oidcMockPath, oidcMockClientID, oidcMockClientSecret)
+
+	if err != nil {
+		slog.Error("Error creating mock OIDC provider", "error", err)
+		return
+	}
+
+	slog.Warn("Serving mock OIDC provider, don't use this in production", "url", s.config.PublicURL+oidcMockPath)
+
+	router.Handle(oidcMockPath+"/", http.StripPrefix(oidcMockPath, mock))
+}
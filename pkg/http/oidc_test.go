@@ -0,0 +1,351 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/oidc"
+)
+
+// The API with the mock provider mounted, served over a real listener since
+// the provider is discovered over HTTP
+type oidcTestServer struct {
+	*APIServer
+	store  *memoryStore
+	url    string
+	client *http.Client
+}
+
+func newOIDCTestServer(t *testing.T) *oidcTestServer {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(nil)
+	publicURL := "http://" + server.Listener.Addr().String()
+
+	s, store := newAuthTestServer(t, &config.Config{PublicURL: publicURL, OIDCMockEnabled: true})
+
+	server.Config.Handler = s.router()
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return &oidcTestServer{
+		APIServer: s,
+		store:     store,
+		url:       publicURL,
+		// Redirects are followed by hand so each step can be tampered with
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// Starts a sign in as `user`, returns the state cookie and the provider's
+// authorization URL
+func (ts *oidcTestServer) start(t *testing.T, user string) (*http.Cookie, *url.URL) {
+	t.Helper()
+
+	resp := ts.get(t, ts.url+"/auth/oidc/mock/login?login_hint="+user, nil)
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Login: got %d, want a redirect", resp.StatusCode)
+	}
+
+	var state *http.Cookie
+
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidcStateCookie {
+			state = cookie
+		}
+	}
+
+	if state == nil || !state.HttpOnly {
+		t.Fatalf("Login: got no HttpOnly state cookie, only %v", resp.Cookies())
+	}
+
+	authURL, err := url.Parse(resp.Header.Get("Location"))
+
+	if err != nil {
+		t.Fatalf("Login: invalid redirect: %v", err)
+	}
+
+	return state, authURL
+}
+
+// Signs in at the provider and returns where it sends the user back to
+func (ts *oidcTestServer) authorize(t *testing.T, authURL *url.URL) *url.URL {
+	t.Helper()
+
+	resp := ts.get(t, authURL.String(), nil)
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Authorize: got %d, want a redirect", resp.StatusCode)
+	}
+
+	callbackURL, err := url.Parse(resp.Header.Get("Location"))
+
+	if err != nil {
+		t.Fatalf("Authorize: invalid redirect: %v", err)
+	}
+
+	return callbackURL
+}
+
+func (ts *oidcTestServer) callback(t *testing.T, callbackURL *url.URL, state *http.Cookie) *http.Response {
+	t.Helper()
+
+	return ts.get(t, callbackURL.String(), state)
+}
+
+// The whole flow, returning the tokens we issued
+func (ts *oidcTestServer) signIn(t *testing.T, user string) LoginResponse {
+	t.Helper()
+
+	state, authURL := ts.start(t, user)
+	resp := ts.callback(t, ts.authorize(t, authURL), state)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Callback: got %d, want 200", resp.StatusCode)
+	}
+
+	var login LoginResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&login); err != nil {
+		t.Fatalf("Callback: invalid response: %v", err)
+	}
+
+	return login
+}
+
+func (ts *oidcTestServer) get(t *testing.T, rawURL string, cookie *http.Cookie) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest("GET", rawURL, nil)
+
+	if err != nil {
+		t.Fatalf("Invalid request: %v", err)
+	}
+
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := ts.client.Do(req)
+
+	if err != nil {
+		t.Fatalf("GET %s: %v", rawURL, err)
+	}
+
+	t.Cleanup(func() { resp.Body.Close() })
+
+	return resp
+}
+
+func withQuery(u *url.URL, name string, value string) *url.URL {
+	changed := *u
+	query := changed.Query()
+	query.Set(name, value)
+	changed.RawQuery = query.Encode()
+
+	return &changed
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	ts := newOIDCTestServer(t)
+
+	login := ts.signIn(t, "alice")
+
+	acc, err := ts.store.GetAccountByID(context.Background(), login.AccountID)
+
+	if err != nil {
+		t.Fatalf("No account was provisioned: %v", err)
+	}
+
+	// The mock provider vouches for the address
+	if acc.UserName != "alice" || acc.Email != "alice@example.com" || !acc.IsEmailVerified() {
+		t.Errorf("Provisioned %+v", acc)
+	}
+
+	claims := &AccessClaims{}
+
+	if _, err := jwt.ParseWithClaims(login.Token, claims, ts.keys.Keyfunc); err != nil || claims.AccountID != acc.ID {
+		t.Errorf("Got access token for account %d (%v), want %d", claims.AccountID, err, acc.ID)
+	}
+
+	// The identity is linked now, so signing in again finds the same account
+	if again := ts.signIn(t, "alice"); again.AccountID != acc.ID {
+		t.Errorf("Second sign in got account %d, want %d", again.AccountID, acc.ID)
+	}
+}
+
+func TestOIDCCallbackRejectsTampering(t *testing.T) {
+	ts := newOIDCTestServer(t)
+
+	otherVerifier, _ := oidc.GenerateVerifier()
+
+	tests := []struct {
+		name string
+		// Changes the flow before the user comes back to us
+		tamper func(state *http.Cookie, authURL *url.URL) (*http.Cookie, *url.URL)
+		// Changes the callback request itself
+		tamperCallback func(callbackURL *url.URL) *url.URL
+		want           int
+	}{
+		{
+			name: "state mismatch",
+			tamperCallback: func(callbackURL *url.URL) *url.URL {
+				return withQuery(callbackURL, "state", "forged")
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "missing state cookie",
+			tamper: func(state *http.Cookie, authURL *url.URL) (*http.Cookie, *url.URL) {
+				return nil, authURL
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "forged state cookie",
+			tamper: func(state *http.Cookie, authURL *url.URL) (*http.Cookie, *url.URL) {
+				forged := *state
+				forged.Value += "x"
+				return &forged, authURL
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			// The code was issued for another verifier than the one in our
+			// cookie, so the provider refuses to exchange it
+			name: "PKCE mismatch",
+			tamper: func(state *http.Cookie, authURL *url.URL) (*http.Cookie, *url.URL) {
+				return state, withQuery(authURL, "code_challenge", oidc.S256Challenge(otherVerifier))
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "nonce mismatch",
+			tamper: func(state *http.Cookie, authURL *url.URL) (*http.Cookie, *url.URL) {
+				return state, withQuery(authURL, "nonce", "forged")
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "unknown code",
+			tamperCallback: func(callbackURL *url.URL) *url.URL {
+				return withQuery(callbackURL, "code", "forged")
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, authURL := ts.start(t, "mallory")
+
+			if tt.tamper != nil {
+				state, authURL = tt.tamper(state, authURL)
+			}
+
+			callbackURL := ts.authorize(t, authURL)
+
+			if tt.tamperCallback != nil {
+				callbackURL = tt.tamperCallback(callbackURL)
+			}
+
+			if resp := ts.callback(t, callbackURL, state); resp.StatusCode != tt.want {
+				t.Errorf("Got %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	if _, err := ts.store.GetAccountByUsername(context.Background(), "mallory"); err == nil {
+		t.Error("An account was provisioned by a rejected sign in")
+	}
+}
+
+// Only an address both sides verified links an identity to an existing
+// account, anything else gets an account of its own
+func TestOIDCLinksVerifiedEmailsOnly(t *testing.T) {
+	ts := newOIDCTestServer(t)
+	ctx := context.Background()
+
+	verified := ts.store.addAccount(domain.NewAccount("bob", "correct horse"))
+
+	if err := ts.store.VerifyAccountEmail(ctx, verified.ID, "bob@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unverified addresses only live in their verification token, so the
+	// account itself has none
+	ts.store.addAccount(domain.NewAccount("carol", "correct horse"))
+
+	if login := ts.signIn(t, "bob"); login.AccountID != verified.ID {
+		t.Errorf("Verified on both sides: got account %d, want the existing %d", login.AccountID, verified.ID)
+	}
+
+	carol := ts.signIn(t, "carol")
+
+	if acc, _ := ts.store.GetAccountByUsername(ctx, "carol"); carol.AccountID == acc.ID {
+		t.Error("Identity was linked to an account that never verified the address")
+	}
+
+	// Providers that don't vouch for the address can't claim the account
+	// either, and the new account doesn't get the taken address
+	acc, err := ts.accountForIdentity(ctx, "mock", &oidc.IDTokenClaims{
+		Email:             "bob@example.com",
+		EmailVerified:     false,
+		PreferredUsername: "bob",
+		RegisteredClaims:  jwt.RegisteredClaims{Subject: "other|bob"},
+	})
+
+	if err != nil {
+		t.Fatalf("Error resolving identity: %v", err)
+	}
+
+	if acc.ID == verified.ID {
+		t.Error("Identity with an unverified email was linked to the account")
+	}
+
+	if acc.Email != "" {
+		t.Errorf("New account got the taken address %s", acc.Email)
+	}
+}
+
+// The state cookie is signed and expires with the flow
+func TestOIDCStateCookieExpires(t *testing.T) {
+	ts := newOIDCTestServer(t)
+
+	state, authURL := ts.start(t, "alice")
+
+	if state.MaxAge != int(oidcStateTTL.Seconds()) {
+		t.Errorf("State cookie lives for %ds, want %s", state.MaxAge, oidcStateTTL)
+	}
+
+	expired, err := ts.keys.Sign(&oidcStateClaims{
+		Provider: "mock",
+		State:    authURL.Query().Get("state"),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ts.config.JWTIssuer,
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state.Value = expired
+
+	if resp := ts.callback(t, ts.authorize(t, authURL), state); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expired state: got %d, want 400", resp.StatusCode)
+	}
+}
@@ -0,0 +1,547 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The OpenAPI 3.1 document is built from the route table when first asked
+// for. Schemas come from the request and response types themselves, so they
+// can't fall behind them.
+
+const openAPIVersion = "3.1.0"
+
+type openAPIDocument struct {
+	OpenAPI    string                                  `json:"openapi"`
+	Info       openAPIInfo                             `json:"info"`
+	Tags       []openAPITag                            `json:"tags"`
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components openAPIComponents                       `json:"components"`
+}
+
+type openAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPITag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type openAPIOperation struct {
+	OperationID string                      `json:"operationId"`
+	Tags        []string                    `json:"tags"`
+	Summary     string                      `json:"summary"`
+	Description string                      `json:"description,omitempty"`
+	Deprecated  bool                        `json:"deprecated,omitempty"`
+	Security    []map[string][]string       `json:"security,omitempty"`
+	Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+	RequestBody *openAPIRequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPIParameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIRequestBody struct {
+	Required bool                        `json:"required"`
+	Content  map[string]openAPIMediaType `json:"content"`
+}
+
+type openAPIMediaType struct {
+	Schema *schema `json:"schema"`
+}
+
+// Either a response or, with Ref set, a reference to one in components
+type openAPIResponse struct {
+	Ref         string                      `json:"$ref,omitempty"`
+	Description string                      `json:"description"`
+	Headers     map[string]openAPIHeader    `json:"headers,omitempty"`
+	Content     map[string]openAPIMediaType `json:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *schema `json:"schema"`
+}
+
+type openAPIComponents struct {
+	Schemas         map[string]*schema               `json:"schemas"`
+	Responses       map[string]*openAPIResponse      `json:"responses"`
+	SecuritySchemes map[string]openAPISecurityScheme `json:"securitySchemes"`
+}
+
+type openAPISecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// A JSON Schema, as far as we need one
+type schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 any                `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *schema            `json:"additionalProperties,omitempty"`
+	OneOf                []*schema          `json:"oneOf,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+}
+
+const (
+	securityJWT    = "jwt"
+	securityAPIKey = "apiKey"
+)
+
+var pathParamPattern = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+func newOpenAPIDocument(routes []route) *openAPIDocument {
+	schemas := newSchemaRegistry()
+
+	doc := &openAPIDocument{
+		OpenAPI: openAPIVersion,
+		Info: openAPIInfo{
+			Title:       "go-gym API",
+			Version:     "1.0.0",
+			Description: "Rate gyms and manage the accounts doing the rating. Errors are RFC 7807 problem details.",
+		},
+		Tags: []openAPITag{
+			{Name: "gyms", Description: "Gyms and their ratings"},
+			{Name: "accounts", Description: "Signing up and managing your own account"},
+			{Name: "auth", Description: "Logging in and out, tokens and password resets"},
+			{Name: "admin", Description: "Only for admins"},
+			{Name: "health", Description: "Probes"},
+			{Name: "docs", Description: "This document and the docs page"},
+		},
+		Paths: map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{
+			Schemas:   schemas.schemas,
+			Responses: newErrorResponses(schemas),
+			SecuritySchemes: map[string]openAPISecurityScheme{
+				securityJWT: {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        "x-jwt-token",
+					Description: "Access token from `POST /auth/login`",
+				},
+				securityAPIKey: {
+					Type:        "apiKey",
+					In:          "header",
+					Name:        apiKeyHeader,
+					Description: "API key created by an admin, routes list the scopes they need",
+				},
+			},
+		},
+	}
+
+	for _, r := range routes {
+		if doc.Paths[r.path] == nil {
+			doc.Paths[r.path] = map[string]*openAPIOperation{}
+		}
+
+		doc.Paths[r.path][strings.ToLower(r.method)] = newOpenAPIOperation(r, schemas)
+	}
+
+	return doc
+}
+
+func newOpenAPIOperation(r route, schemas *schemaRegistry) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: r.operationID,
+		Tags:        []string{r.tag},
+		Summary:     r.summary,
+		Description: r.description,
+		Deprecated:  r.deprecated,
+		Responses:   map[string]*openAPIResponse{},
+	}
+
+	switch r.auth {
+	case authJWT:
+		op.Security = []map[string][]string{{securityJWT: {}}}
+	case authAdmin:
+		op.Security = []map[string][]string{{securityJWT: {}}}
+		op.Description = strings.TrimSpace("Admins only. " + op.Description)
+	case authAdminOrAPIKey:
+		op.Security = []map[string][]string{{securityJWT: {}}, {securityAPIKey: {r.scope}}}
+		op.Description = strings.TrimSpace("Admins, or API keys with the `" + r.scope + "` scope. " + op.Description)
+	case authJWTOrAPIKey:
+		op.Security = []map[string][]string{{securityJWT: {}}, {securityAPIKey: {r.scope}}}
+		op.Description = strings.TrimSpace("Accounts, or API keys with the `" + r.scope + "` scope. " + op.Description)
+	case authOptionalAPIKey:
+		// The empty requirement makes auth optional
+		op.Security = []map[string][]string{{}, {securityAPIKey: {r.scope}}}
+		op.Description = strings.TrimSpace("API keys sent need the `" + r.scope + "` scope. " + op.Description)
+	}
+
+	for _, match := range pathParamPattern.FindAllStringSubmatch(r.path, -1) {
+		param := openAPIParameter{Name: match[1], In: "path", Required: true, Schema: &schema{Type: "string"}}
+
+		if param.Name == "id" {
+			param.Schema = &schema{Type: "integer"}
+		}
+
+		op.Parameters = append(op.Parameters, param)
+	}
+
+	for _, name := range sortedKeys(r.query) {
+		op.Parameters = append(op.Parameters, openAPIParameter{Name: name, In: "query", Description: r.query[name], Schema: &schema{Type: "string"}})
+	}
+
+	if r.idempotent {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:        "Idempotency-Key",
+			In:          "header",
+			Description: "Retries with the same key get the first response back instead of repeating the request",
+			Schema:      &schema{Type: "string", MinLength: intPtr(1), MaxLength: intPtr(255)},
+		})
+	}
+
+	if r.conditional {
+		op.Parameters = append(op.Parameters,
+			openAPIParameter{Name: "If-None-Match", In: "header", Description: "ETag of your copy", Schema: &schema{Type: "string"}},
+			openAPIParameter{Name: "If-Modified-Since", In: "header", Description: "Last-Modified of your copy, where one was sent", Schema: &schema{Type: "string"}},
+			openAPIParameter{Name: "If-Match", In: "header", Description: "Only answer if this is still the current ETag", Schema: &schema{Type: "string"}},
+			openAPIParameter{Name: "If-Unmodified-Since", In: "header", Description: "Only answer if nothing changed since", Schema: &schema{Type: "string"}},
+		)
+	}
+
+	if r.request != nil {
+		op.RequestBody = &openAPIRequestBody{
+			Required: true,
+			Content:  map[string]openAPIMediaType{"application/json": {Schema: schemas.bodySchema(r.request)}},
+		}
+	}
+
+	for _, resp := range r.responses {
+		op.Responses[strconv.Itoa(resp.status)] = newOpenAPIResponse(resp, schemas)
+	}
+
+	if r.conditional {
+		ok := op.Responses[strconv.Itoa(http.StatusOK)]
+
+		if ok != nil {
+			ok.Headers = map[string]openAPIHeader{
+				"ETag":          {Schema: &schema{Type: "string"}},
+				"Last-Modified": {Schema: &schema{Type: "string"}},
+			}
+		}
+
+		op.Responses[strconv.Itoa(http.StatusNotModified)] = &openAPIResponse{Description: "Your copy is current"}
+	}
+
+	for _, status := range routeErrors(r) {
+		op.Responses[strconv.Itoa(status)] = errorResponseRef(status)
+	}
+
+	op.Responses["default"] = &openAPIResponse{Ref: errorResponse, Description: "Anything else that went wrong"}
+
+	return op
+}
+
+func newOpenAPIResponse(resp response, schemas *schemaRegistry) *openAPIResponse {
+	openAPIResp := &openAPIResponse{Description: resp.description}
+
+	switch {
+	case resp.body != nil:
+		openAPIResp.Content = map[string]openAPIMediaType{"application/json": {Schema: schemas.bodySchema(resp.body)}}
+	case resp.contentType != "":
+		openAPIResp.Content = map[string]openAPIMediaType{resp.contentType: {Schema: &schema{}}}
+	}
+
+	return openAPIResp
+}
+
+const (
+	errorResponse           = "#/components/responses/Error"
+	tooManyRequestsResponse = "#/components/responses/TooManyRequests"
+)
+
+// Errors are always problem details
+func newErrorResponses(schemas *schemaRegistry) map[string]*openAPIResponse {
+	content := map[string]openAPIMediaType{
+		"application/problem+json": {Schema: schemas.bodySchema(ProblemDetails{})},
+	}
+
+	return map[string]*openAPIResponse{
+		path.Base(errorResponse): {Description: "Something went wrong", Content: content},
+		path.Base(tooManyRequestsResponse): {
+			Description: http.StatusText(http.StatusTooManyRequests),
+			Headers: map[string]openAPIHeader{
+				"Retry-After": {Description: "Seconds until the request may be retried", Schema: &schema{Type: "integer"}},
+			},
+			Content: content,
+		},
+	}
+}
+
+// OpenAPI 3.1 lets references override the description, so every status can
+// share the same response
+func errorResponseRef(status int) *openAPIResponse {
+	if status == http.StatusTooManyRequests {
+		return &openAPIResponse{Ref: tooManyRequestsResponse, Description: http.StatusText(status)}
+	}
+
+	return &openAPIResponse{Ref: errorResponse, Description: http.StatusText(status)}
+}
+
+// What can go wrong on a route, on top of route.errors
+func routeErrors(r route) []int {
+	statuses := slices.Clone(r.errors)
+
+	if r.request != nil {
+		statuses = append(statuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity)
+	}
+
+	if strings.Contains(r.path, "{") {
+		statuses = append(statuses, http.StatusNotFound)
+	}
+
+	if r.conditional {
+		statuses = append(statuses, http.StatusPreconditionFailed)
+	}
+
+	if strings.Contains(r.path, "{id}") {
+		statuses = append(statuses, http.StatusBadRequest)
+	}
+
+	switch r.auth {
+	case authJWT:
+		statuses = append(statuses, http.StatusUnauthorized)
+	case authAdmin, authAdminOrAPIKey, authJWTOrAPIKey, authOptionalAPIKey:
+		statuses = append(statuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+
+	if r.rateLimit != nil {
+		statuses = append(statuses, http.StatusTooManyRequests)
+	}
+
+	if r.idempotent {
+		statuses = append(statuses, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+
+	slices.Sort(statuses)
+
+	return slices.Compact(statuses)
+}
+
+// Component schemas by name, filled in as types are referenced
+type schemaRegistry struct {
+	schemas map[string]*schema
+	types   map[string]reflect.Type
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{
+		schemas: map[string]*schema{},
+		types:   map[string]reflect.Type{},
+	}
+}
+
+func (r *schemaRegistry) bodySchema(body any) *schema {
+	if shapes, ok := body.(oneOf); ok {
+		s := &schema{}
+
+		for _, shape := range shapes {
+			s.OneOf = append(s.OneOf, r.schemaFor(reflect.TypeOf(shape)))
+		}
+
+		return s
+	}
+
+	return r.schemaFor(reflect.TypeOf(body))
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+func (r *schemaRegistry) schemaFor(t reflect.Type) *schema {
+	switch {
+	case t == timeType:
+		return &schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		return nullable(r.schemaFor(t.Elem()))
+	case t.Kind() == reflect.Struct:
+		return r.ref(t)
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return &schema{Type: "string", Format: "byte"}
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		return &schema{Type: "array", Items: r.schemaFor(t.Elem())}
+	case t.Kind() == reflect.Map:
+		return &schema{Type: "object", AdditionalProperties: r.schemaFor(t.Elem())}
+	case t.Kind() == reflect.String:
+		return &schema{Type: "string"}
+	case t.Kind() == reflect.Bool:
+		return &schema{Type: "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return &schema{Type: "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return &schema{Type: "number"}
+	}
+
+	// Anything goes
+	return &schema{}
+}
+
+// Registers the struct under components once, named after the Go type
+func (r *schemaRegistry) ref(t reflect.Type) *schema {
+	name := t.Name()
+
+	if existing, ok := r.types[name]; ok && existing != t {
+		// Same name in another package
+		name = path.Base(t.PkgPath()) + name
+	}
+
+	if _, ok := r.types[name]; !ok {
+		// Taken before building the schema, so types referring to themselves
+		// don't recurse forever
+		r.types[name] = t
+		r.schemas[name] = r.structSchema(t)
+	}
+
+	return &schema{Ref: "#/components/schemas/" + name}
+}
+
+// Request types say what they require with `validate` tags, responses always
+// send every field that isn't omitempty
+func (r *schemaRegistry) structSchema(t reflect.Type) *schema {
+	s := &schema{Type: "object", Properties: map[string]*schema{}}
+
+	validated := false
+
+	for i := range t.NumField() {
+		if _, ok := t.Field(i).Tag.Lookup("validate"); ok {
+			validated = true
+		}
+	}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if name == "-" {
+			continue
+		}
+
+		// Embedded structs without a name are flattened by encoding/json
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := r.structSchema(field.Type)
+
+			for propName, prop := range embedded.Properties {
+				s.Properties[propName] = prop
+			}
+
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		prop := r.schemaFor(field.Type)
+		rules := strings.Split(field.Tag.Get("validate"), ",")
+		applyValidation(prop, field.Type, rules)
+
+		s.Properties[name] = prop
+
+		omitted := strings.Contains(options, "omitempty") || strings.Contains(options, "omitzero")
+
+		if (validated && slices.Contains(rules, "required")) || (!validated && !omitted) {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
+
+// Carries over the `validate` rules JSON Schema has a word for
+func applyValidation(s *schema, t reflect.Type, rules []string) {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	for _, rule := range rules {
+		key, value, _ := strings.Cut(rule, "=")
+		n, err := strconv.Atoi(value)
+
+		switch {
+		case key == "email":
+			s.Format = "email"
+		case key == "min" && err == nil && t.Kind() == reflect.String:
+			s.MinLength = intPtr(n)
+		case key == "max" && err == nil && t.Kind() == reflect.String:
+			s.MaxLength = intPtr(n)
+		case key == "min" && err == nil:
+			s.Minimum = intPtr(n)
+		case key == "max" && err == nil:
+			s.Maximum = intPtr(n)
+		}
+	}
+}
+
+// Allows null on top of what `s` allows
+func nullable(s *schema) *schema {
+	if s.Ref != "" || s.Type == nil {
+		return &schema{OneOf: []*schema{s, {Type: "null"}}}
+	}
+
+	clone := *s
+	clone.Type = []any{s.Type, "null"}
+
+	return &clone
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	return keys
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func (s *APIServer) handleGetOpenAPI(w http.ResponseWriter, req *http.Request) error {
+	spec, err := s.openAPISpec()
+
+	if err != nil {
+		return fmt.Errorf("Error building the OpenAPI document: %w", err)
+	}
+
+	return s.writeStatic(w, req, "application/json", spec)
+}
+
+func (s *APIServer) buildOpenAPISpec() ([]byte, error) {
+	return json.MarshalIndent(newOpenAPIDocument(s.routes()), "", "  ")
+}
@@ -0,0 +1,151 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+// A server without storage or keys, for what doesn't need them
+func newTestServer(t *testing.T, cfg *config.Config) *APIServer {
+	t.Helper()
+
+	s, err := NewAPIServer(":0", nil, cfg, nil, nil, nil, nil)
+
+	if err != nil {
+		t.Fatalf("Error creating server: %v", err)
+	}
+
+	return s
+}
+
+// Fetches the document the way clients do
+func getOpenAPIDocument(t *testing.T, router http.Handler) map[string]any {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: got %d, want 200", rec.Code)
+	}
+
+	var doc map[string]any
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Invalid OpenAPI document: %v", err)
+	}
+
+	return doc
+}
+
+// Every documented operation has to reach the route it documents, and every
+// route has to be documented
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	router := s.router()
+	doc := getOpenAPIDocument(t, router)
+
+	if doc["openapi"] != openAPIVersion {
+		t.Errorf("Got OpenAPI version %v, want %s", doc["openapi"], openAPIVersion)
+	}
+
+	documented := map[string]bool{}
+	operationIDs := map[string]string{}
+
+	for path, item := range doc["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			pattern := strings.ToUpper(method) + " " + path
+			documented[pattern] = true
+
+			// Any value routes to the same pattern
+			req := httptest.NewRequest(strings.ToUpper(method), pathParamPattern.ReplaceAllString(path, "1"), nil)
+
+			if _, matched := router.Handler(req); matched != pattern {
+				t.Errorf("%s is documented but routes to %q", pattern, matched)
+			}
+
+			operation := op.(map[string]any)
+			id, _ := operation["operationId"].(string)
+
+			if other, ok := operationIDs[id]; ok {
+				t.Errorf("%s and %s share the operationId %q", pattern, other, id)
+			}
+
+			operationIDs[id] = pattern
+
+			if id == "" || operation["summary"] == "" {
+				t.Errorf("%s needs an operationId and a summary", pattern)
+			}
+		}
+	}
+
+	for _, r := range s.routes() {
+		if !documented[r.pattern()] {
+			t.Errorf("%s is routed but missing from the OpenAPI document", r.pattern())
+		}
+
+		if len(r.responses) == 0 {
+			t.Errorf("%s documents no successful response", r.pattern())
+		}
+	}
+
+	if len(documented) != len(s.routes()) {
+		t.Errorf("Got %d documented operations for %d routes", len(documented), len(s.routes()))
+	}
+}
+
+// Every $ref has to point to something in components
+func TestOpenAPIReferencesResolve(t *testing.T) {
+	doc := getOpenAPIDocument(t, newTestServer(t, &config.Config{}).router())
+	components := doc["components"].(map[string]any)
+
+	var walk func(node any)
+
+	walk = func(node any) {
+		switch node := node.(type) {
+		case map[string]any:
+			if ref, ok := node["$ref"].(string); ok {
+				kind, name, _ := strings.Cut(strings.TrimPrefix(ref, "#/components/"), "/")
+				section, _ := components[kind].(map[string]any)
+
+				if _, exists := section[name]; !exists {
+					t.Errorf("Unresolved $ref %q", ref)
+				}
+			}
+
+			for _, child := range node {
+				walk(child)
+			}
+		case []any:
+			for _, child := range node {
+				walk(child)
+			}
+		}
+	}
+
+	walk(doc)
+}
+
+// The docs page needs to load its own assets past the security headers
+func TestDocsPageServed(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	handler := s.middleware(s.router())
+
+	for _, path := range []string{"/docs", "/docs/docs.js", "/docs/docs.css"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET %s: got %d, want 200", path, rec.Code)
+		}
+
+		if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "script-src 'self'") {
+			t.Errorf("GET %s: got Content-Security-Policy %q", path, csp)
+		}
+	}
+}
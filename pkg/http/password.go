@@ -0,0 +1,171 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/mail"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+// Same answer whether or not the account exists
+const forgotPasswordMessage = "If the account exists, a password reset link has been sent"
+
+func (s *APIServer) handleForgotPassword(w http.ResponseWriter, req *http.Request) error {
+	var forgotRequest domain.ForgotPasswordRequest
+
+	if err := decodeJSON(w, req, &forgotRequest); err != nil {
+		return err
+	}
+
+	// Work happens in the background so the response takes the same time
+	// for known and unknown usernames
+	ctx := context.WithoutCancel(req.Context())
+	s.background.Go(func() { s.sendPasswordReset(ctx, forgotRequest) })
+
+	return WriteJSON(w, http.StatusAccepted, map[string]string{"message": forgotPasswordMessage})
+}
+
+func (s *APIServer) sendPasswordReset(ctx context.Context, forgotRequest domain.ForgotPasswordRequest) {
+	var acc *domain.Account
+	var err error
+
+	if forgotRequest.Email != "" {
+		acc, err = s.store.GetAccountByEmail(ctx, forgotRequest.Email)
+	} else {
+		acc, err = s.store.GetAccountByUsername(ctx, forgotRequest.Username)
+	}
+
+	if err != nil {
+		return
+	}
+
+	// An unverified address may not belong to the account owner, reset links
+	// sent there would hand the account to whoever typed it in
+	if !acc.IsEmailVerified() {
+		slog.InfoContext(ctx, "Account has no verified email address, can't send a password reset", "account_id", acc.ID)
+		return
+	}
+
+	// Only the latest link works
+	if err := s.store.DeleteAccountTokens(ctx, acc.ID, domain.PurposePasswordReset); err != nil {
+		slog.ErrorContext(ctx, "Error deleting old reset links", "account_id", acc.ID, "error", err)
+		return
+	}
+
+	raw, err := generateOpaqueToken(32)
+
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating reset link", "error", err)
+		return
+	}
+
+	token := domain.NewAccountToken(acc.ID, domain.PurposePasswordReset, hashToken(raw), s.config.PasswordResetTTL)
+
+	if _, err := s.store.CreateAccountToken(ctx, token); err != nil {
+		slog.ErrorContext(ctx, "Error storing reset link", "account_id", acc.ID, "error", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", s.config.PublicURL, url.QueryEscape(raw))
+
+	msg := mail.Message{
+		To:      acc.Email,
+		Subject: "Reset your Go Gym password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nUse the link below to choose a new password. It expires in %s and can only be used once.\n\n%s\n\nIf you didn't ask for this you can ignore this email.\n",
+			acc.UserName, s.config.PasswordResetTTL, link,
+		),
+	}
+
+	if err := s.mailer.Send(msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending password reset", "account_id", acc.ID, "error", err)
+	}
+}
+
+func (s *APIServer) handleResetPassword(w http.ResponseWriter, req *http.Request) error {
+	var resetRequest domain.ResetPasswordRequest
+
+	if err := decodeJSON(w, req, &resetRequest); err != nil {
+		return err
+	}
+
+	token, err := s.store.ConsumeAccountToken(req.Context(), hashToken(resetRequest.Token), domain.PurposePasswordReset)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		return badRequest("Invalid or expired reset token")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByID(req.Context(), token.AccountID)
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.setPassword(req.Context(), acc, resetRequest.NewPassword); err != nil {
+		return err
+	}
+
+	// Whoever was guessing the old password has nothing left to guess
+	if err := s.store.ResetLoginAttempts(req.Context(), accountLoginKey(acc.UserName)); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *APIServer) handleChangePassword(w http.ResponseWriter, req *http.Request) error {
+	accountID, ok := AccountIDFromContext(req.Context())
+
+	if !ok {
+		return unauthorized("Unable to retrieve ID from context")
+	}
+
+	var changeRequest domain.ChangePasswordRequest
+
+	if err := decodeJSON(w, req, &changeRequest); err != nil {
+		return err
+	}
+
+	acc, err := s.store.GetAccountByID(req.Context(), int(accountID))
+
+	if err != nil {
+		return err
+	}
+
+	if !storage.VerifyHashedPassword(changeRequest.CurrentPassword, acc.Password) {
+		return forbidden("Invalid Password")
+	}
+
+	if err := s.setPassword(req.Context(), acc, changeRequest.NewPassword); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Stores the new password and signs the account out everywhere
+func (s *APIServer) setPassword(ctx context.Context, acc *domain.Account, password string) error {
+	if err := s.store.UpdateAccountPassword(ctx, acc.ID, password); err != nil {
+		return err
+	}
+
+	if err := s.store.DeleteAccountTokens(ctx, acc.ID, domain.PurposePasswordReset); err != nil {
+		return err
+	}
+
+	slog.Info("Password changed, revoking sessions", "account_id", acc.ID)
+
+	return s.store.RevokeAccountRefreshTokens(ctx, acc.ID)
+}
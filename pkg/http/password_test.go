@@ -0,0 +1,125 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// Signs up alice with a verified address, the only kind reset links go to
+func newResetTestServer(t *testing.T) (*APIServer, http.Handler, *memoryStore, *recordingMailer) {
+	t.Helper()
+
+	s, handler, store, mailer := newMailTestServer(t)
+
+	signUp(t, s, handler, "alice", "alice@example.com")
+
+	if code := verifyEmail(t, handler, mailer.lastToken(t, "alice@example.com")); code != http.StatusNoContent {
+		t.Fatalf("Verify email: got %d, want 204", code)
+	}
+
+	return s, handler, store, mailer
+}
+
+// Asks for a reset link and waits for it to be sent
+func forgotPassword(t *testing.T, s *APIServer, handler http.Handler, forgotRequest domain.ForgotPasswordRequest) string {
+	t.Helper()
+
+	rec := doJSON(t, handler, "POST", "/auth/password/forgot", forgotRequest, nil, nil)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Forgot password: got %d %s, want 202", rec.Code, rec.Body.String())
+	}
+
+	s.background.Wait()
+
+	return rec.Body.String()
+}
+
+func resetPassword(t *testing.T, handler http.Handler, token string, password string) int {
+	t.Helper()
+
+	return doJSON(t, handler, "POST", "/auth/password/reset", domain.ResetPasswordRequest{Token: token, NewPassword: password}, nil, nil).Code
+}
+
+// A reset logs out every session, since whoever knew the old password may
+// hold one of them
+func TestResetPassword(t *testing.T) {
+	s, handler, _, mailer := newResetTestServer(t)
+
+	session := login(t, handler)
+
+	forgotPassword(t, s, handler, domain.ForgotPasswordRequest{Email: "alice@example.com"})
+	token := mailer.lastToken(t, "alice@example.com")
+
+	if code := resetPassword(t, handler, token, "battery staple 2"); code != http.StatusNoContent {
+		t.Fatalf("Reset: got %d, want 204", code)
+	}
+
+	if code, _ := refresh(t, handler, session.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Refresh after reset: got %d, want 401", code)
+	}
+
+	if rec := tryLogin(t, handler, "alice", "correct horse", "203.0.113.1"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Old password: got %d, want 401", rec.Code)
+	}
+
+	if rec := tryLogin(t, handler, "alice", "battery staple 2", "203.0.113.1"); rec.Code != http.StatusOK {
+		t.Errorf("New password: got %d, want 200", rec.Code)
+	}
+
+	// Links are single use
+	if code := resetPassword(t, handler, token, "another one 3"); code != http.StatusBadRequest {
+		t.Errorf("Reused link: got %d, want 400", code)
+	}
+}
+
+func TestResetPasswordExpired(t *testing.T) {
+	s, handler, store, mailer := newResetTestServer(t)
+
+	forgotPassword(t, s, handler, domain.ForgotPasswordRequest{Username: "alice"})
+	token := mailer.lastToken(t, "alice@example.com")
+
+	store.mu.Lock()
+	for _, stored := range store.accountTokens {
+		if stored.Purpose == domain.PurposePasswordReset {
+			stored.ExpiresAt = time.Now().Add(-time.Minute)
+		}
+	}
+	store.mu.Unlock()
+
+	if code := resetPassword(t, handler, token, "battery staple 2"); code != http.StatusBadRequest {
+		t.Errorf("Got %d, want 400", code)
+	}
+
+	if rec := tryLogin(t, handler, "alice", "correct horse", "203.0.113.1"); rec.Code != http.StatusOK {
+		t.Errorf("Old password after a failed reset: got %d, want 200", rec.Code)
+	}
+}
+
+// Unknown addresses and usernames get the same answer as known ones, and
+// nothing is sent
+func TestForgotPasswordNoEnumeration(t *testing.T) {
+	s, handler, _, mailer := newResetTestServer(t)
+
+	known := forgotPassword(t, s, handler, domain.ForgotPasswordRequest{Email: "alice@example.com"})
+
+	mailer.mu.Lock()
+	sent := len(mailer.messages)
+	mailer.mu.Unlock()
+
+	for _, forgotRequest := range []domain.ForgotPasswordRequest{{Email: "nobody@example.com"}, {Username: "nobody"}} {
+		if body := forgotPassword(t, s, handler, forgotRequest); body != known {
+			t.Errorf("%+v: got %s, want %s", forgotRequest, body, known)
+		}
+	}
+
+	mailer.mu.Lock()
+	defer mailer.mu.Unlock()
+
+	if len(mailer.messages) != sent {
+		t.Errorf("Unknown accounts were sent %d emails", len(mailer.messages)-sent)
+	}
+}
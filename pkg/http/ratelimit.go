@@ -0,0 +1,87 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/ratelimit"
+)
+
+// Policies routes pick from
+type rateLimitPolicies struct {
+	// Login, signup and anything else worth brute forcing
+	strict ratelimit.Policy
+	// Everything else
+	standard ratelimit.Policy
+	// Public reads, cheap and hit a lot
+	relaxed ratelimit.Policy
+}
+
+func newRateLimitPolicies(cfg *config.Config) rateLimitPolicies {
+	return rateLimitPolicies{
+		strict:   ratelimit.Policy{Name: "strict", Limit: cfg.RateLimitStrict, Period: time.Minute},
+		standard: ratelimit.Policy{Name: "default", Limit: cfg.RateLimitDefault, Period: time.Minute},
+		relaxed:  ratelimit.Policy{Name: "relaxed", Limit: cfg.RateLimitRelaxed, Period: time.Minute},
+	}
+}
+
+// Who the request counts against: the API key or account it authenticated as,
+// falling back to the client IP. Only identities checked by the auth
+// middlewares are used, so this has to sit inside WithAPIKeyAuth / WithJWTAuth.
+func (s *APIServer) rateLimitKey(req *http.Request) string {
+	if apiKey, ok := APIKeyFromContext(req.Context()); ok {
+		return fmt.Sprintf("key:%d", apiKey.ID)
+	}
+
+	if accountID, ok := AccountIDFromContext(req.Context()); ok {
+		return fmt.Sprintf("account:%d", accountID)
+	}
+
+	return "ip:" + s.clientIP(req)
+}
+
+// Rejects requests over the policy's limit with a 429. Every response carries
+// the RateLimit-* headers so clients can slow down before that.
+func (s *APIServer) WithRateLimit(policy ratelimit.Policy, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	if !s.config.RateLimitEnabled {
+		return handlerFunc
+	}
+
+	policyHeader := fmt.Sprintf("%d;w=%d", policy.Limit, int(policy.Period.Seconds()))
+
+	return func(w http.ResponseWriter, req *http.Request) {
+		result, err := s.limiter.Take(req.Context(), s.rateLimitKey(req), policy)
+
+		// Better to let requests through than to fail them all
+		if err != nil {
+			slog.ErrorContext(req.Context(), "Error checking rate limit", "policy", policy.Name, "error", err)
+			handlerFunc(w, req)
+			return
+		}
+
+		header := w.Header()
+		header.Set("RateLimit-Policy", policyHeader)
+		header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			metrics.RateLimited.WithLabelValues(policy.Name).Inc()
+			header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			WriteProblem(w, req, http.StatusTooManyRequests, "Too many requests, slow down")
+			return
+		}
+
+		handlerFunc(w, req)
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
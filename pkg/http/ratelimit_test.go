@@ -0,0 +1,176 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/ratelimit"
+)
+
+// Answers every Take with the same result
+type stubLimiter struct {
+	result ratelimit.Result
+	err    error
+	keys   []string
+}
+
+func (l *stubLimiter) Take(_ context.Context, key string, _ ratelimit.Policy) (ratelimit.Result, error) {
+	l.keys = append(l.keys, key)
+	return l.result, l.err
+}
+
+var testRateLimitPolicy = ratelimit.Policy{Name: "test", Limit: 10, Period: time.Minute}
+
+func newRateLimitTestServer(t *testing.T, limiter ratelimit.Backend) (*APIServer, http.HandlerFunc) {
+	t.Helper()
+
+	s, _ := newAuthTestServer(t, &config.Config{RateLimitEnabled: true})
+	s.limiter = limiter
+
+	handler := s.WithRateLimit(testRateLimitPolicy, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return s, handler
+}
+
+func serveRateLimited(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	return rec
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	limiter := &stubLimiter{result: ratelimit.Result{Allowed: true, Limit: 10, Remaining: 7, Reset: 17500 * time.Millisecond}}
+	_, handler := newRateLimitTestServer(t, limiter)
+
+	rec := serveRateLimited(handler, httptest.NewRequest("GET", "/gyms", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Got %d, want 204", rec.Code)
+	}
+
+	want := map[string]string{
+		"RateLimit-Policy":    "10;w=60",
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "7",
+		"RateLimit-Reset":     "18",
+		"Retry-After":         "",
+	}
+
+	for name, value := range want {
+		if got := rec.Header().Get(name); got != value {
+			t.Errorf("Got %s %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestRateLimitRejects(t *testing.T) {
+	limiter := &stubLimiter{result: ratelimit.Result{Limit: 10, Reset: time.Minute, RetryAfter: 5200 * time.Millisecond}}
+	_, handler := newRateLimitTestServer(t, limiter)
+
+	rec := serveRateLimited(handler, httptest.NewRequest("GET", "/gyms", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Got %d, want 429", rec.Code)
+	}
+
+	if got := rec.Header().Get("Retry-After"); got != "6" {
+		t.Errorf("Got Retry-After %q, want 6", got)
+	}
+
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("Got RateLimit-Remaining %q, want 0", got)
+	}
+
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("Got Content-Type %q, want application/problem+json", got)
+	}
+}
+
+// A broken backend lets requests through instead of failing them all
+func TestRateLimitFailsOpen(t *testing.T) {
+	limiter := &stubLimiter{err: errors.New("backend down")}
+	_, handler := newRateLimitTestServer(t, limiter)
+
+	rec := serveRateLimited(handler, httptest.NewRequest("GET", "/gyms", nil))
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Got %d, want 204", rec.Code)
+	}
+
+	if got := rec.Header().Get("RateLimit-Limit"); got != "" {
+		t.Errorf("Got RateLimit-Limit %q without a result", got)
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	s, _ := newAuthTestServer(t, &config.Config{})
+	limiter := &stubLimiter{err: errors.New("shouldn't be called")}
+	s.limiter = limiter
+
+	handler := s.WithRateLimit(testRateLimitPolicy, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	serveRateLimited(handler, httptest.NewRequest("GET", "/gyms", nil))
+
+	if len(limiter.keys) != 0 {
+		t.Errorf("Limiter called %d times with rate limiting disabled", len(limiter.keys))
+	}
+}
+
+// Clients over the limit don't use up anyone else's bucket
+func TestRateLimitPerClient(t *testing.T) {
+	_, handler := newRateLimitTestServer(t, ratelimit.NewMemoryBackend())
+
+	alice := httptest.NewRequest("GET", "/gyms", nil)
+	alice.RemoteAddr = "192.0.2.1:1234"
+
+	for i := 0; i < testRateLimitPolicy.Limit; i++ {
+		if rec := serveRateLimited(handler, alice); rec.Code != http.StatusNoContent {
+			t.Fatalf("Request %d: got %d, want 204", i+1, rec.Code)
+		}
+	}
+
+	if rec := serveRateLimited(handler, alice); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request over the limit: got %d, want 429", rec.Code)
+	}
+
+	bob := httptest.NewRequest("GET", "/gyms", nil)
+	bob.RemoteAddr = "192.0.2.2:1234"
+
+	if rec := serveRateLimited(handler, bob); rec.Code != http.StatusNoContent {
+		t.Errorf("Another client: got %d, want 204", rec.Code)
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	s, _ := newAuthTestServer(t, &config.Config{})
+
+	req := httptest.NewRequest("GET", "/gyms", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	if got := s.rateLimitKey(req); got != "ip:192.0.2.1" {
+		t.Errorf("Anonymous: got %q, want ip:192.0.2.1", got)
+	}
+
+	signedIn := req.WithContext(context.WithValue(req.Context(), ContextAccountKey, int64(42)))
+
+	if got := s.rateLimitKey(signedIn); got != "account:42" {
+		t.Errorf("Signed in: got %q, want account:42", got)
+	}
+
+	withKey := req.WithContext(context.WithValue(signedIn.Context(), ContextAPIKeyKey, &domain.APIKey{ID: 7}))
+
+	if got := s.rateLimitKey(withKey); got != "key:7" {
+		t.Errorf("API key: got %q, want key:7", got)
+	}
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// The types below are what the API sends to clients. Handlers never write
+// domain types directly: those mirror our tables, so any column added to them
+// (a hash, a secret, a foreign key) would end up in responses too.
+//
+// Each representation is versioned. Once released a V1 type only gets new
+// optional fields, anything that would break clients goes into a V2 type.
+
+type AccountV1 struct {
+	ID               int        `json:"id"`
+	UserName         string     `json:"userName"`
+	Role             string     `json:"role"`
+	Email            string     `json:"email,omitempty"`
+	EmailVerifiedAt  *time.Time `json:"emailVerifiedAt"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
+}
+
+func NewAccountV1(acc *domain.Account) AccountV1 {
+	return AccountV1{
+		ID:               acc.ID,
+		UserName:         acc.UserName,
+		Role:             acc.Role,
+		Email:            acc.Email,
+		EmailVerifiedAt:  acc.EmailVerifiedAt,
+		TwoFactorEnabled: acc.IsTOTPEnabled(),
+		CreatedAt:        acc.CreatedAt,
+		UpdatedAt:        acc.UpdatedAt,
+	}
+}
+
+type GymV1 struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Rating      float32   `json:"rating"`
+	RatingCount int       `json:"ratingCount"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func NewGymV1(gym *domain.Gym) GymV1 {
+	return GymV1{
+		ID:          gym.ID,
+		Name:        gym.Name,
+		Description: gym.Description,
+		Rating:      gym.Rating,
+		RatingCount: gym.RatingCount,
+		CreatedAt:   gym.CreatedAt,
+		UpdatedAt:   gym.UpdatedAt,
+	}
+}
+
+// Ratings are public, so they only name their author and never point to the
+// account behind it
+type RatingV1 struct {
+	ID        int       `json:"id"`
+	GymID     int       `json:"gymId"`
+	Rating    int       `json:"rating"`
+	UserName  string    `json:"userName"`
+	Review    string    `json:"review"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func NewRatingV1(rating *domain.Rating) RatingV1 {
+	return RatingV1{
+		ID:        rating.ID,
+		GymID:     rating.GymID,
+		Rating:    rating.Rating,
+		UserName:  rating.UserName,
+		Review:    rating.Review,
+		CreatedAt: rating.CreatedAt,
+		UpdatedAt: rating.UpdatedAt,
+	}
+}
+
+type APIKeyV1 struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"createdBy"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+func NewAPIKeyV1(key *domain.APIKey) APIKeyV1 {
+	scopes := key.Scopes
+
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return APIKeyV1{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		Scopes:     scopes,
+		CreatedBy:  key.CreatedBy,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+		CreatedAt:  key.CreatedAt,
+	}
+}
+
+type IdentityV1 struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func NewIdentityV1(identity *domain.Identity) IdentityV1 {
+	return IdentityV1{
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
+
+// A refresh token as shown to its owner. Token hashes and family IDs stay on
+// the server.
+type SessionV1 struct {
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	RevokedAt *time.Time `json:"revokedAt"`
+}
+
+func NewSessionV1(token *domain.RefreshToken) SessionV1 {
+	return SessionV1{
+		CreatedAt: token.CreatedAt,
+		ExpiresAt: token.ExpiresAt,
+		UsedAt:    token.UsedAt,
+		RevokedAt: token.RevokedAt,
+	}
+}
+
+// A link we emailed, for a password reset or to verify an address
+type AccountTokenV1 struct {
+	Purpose   string     `json:"purpose"`
+	Email     string     `json:"email,omitempty"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func NewAccountTokenV1(token *domain.AccountToken) AccountTokenV1 {
+	return AccountTokenV1{
+		Purpose:   token.Purpose,
+		Email:     token.Email,
+		ExpiresAt: token.ExpiresAt,
+		UsedAt:    token.UsedAt,
+		CreatedAt: token.CreatedAt,
+	}
+}
+
+// Never holds the secret or the codes themselves
+type TwoFactorV1 struct {
+	Enabled   bool       `json:"enabled"`
+	EnabledAt *time.Time `json:"enabledAt"`
+	// Enrollment started but wasn't confirmed yet
+	EnrollmentPending bool `json:"enrollmentPending"`
+	RecoveryCodesLeft int  `json:"recoveryCodesLeft"`
+}
+
+func NewTwoFactorV1(acc *domain.Account, recoveryCodesLeft int) TwoFactorV1 {
+	return TwoFactorV1{
+		Enabled:           acc.IsTOTPEnabled(),
+		EnabledAt:         acc.TOTPEnabledAt,
+		EnrollmentPending: acc.TOTPSecret != "" && acc.TOTPEnabledAt == nil,
+		RecoveryCodesLeft: recoveryCodesLeft,
+	}
+}
+
+type LoginAttemptsV1 struct {
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"lastFailureAt"`
+	LockedUntil   *time.Time `json:"lockedUntil"`
+}
+
+func NewLoginAttemptsV1(attempts *domain.LoginAttempts) LoginAttemptsV1 {
+	return LoginAttemptsV1{
+		Failures:      attempts.Failures,
+		LastFailureAt: attempts.LastFailureAt,
+		LockedUntil:   attempts.LockedUntil,
+	}
+}
+
+// Maps a slice of domain types with one of the constructors above. Always
+// returns a non nil slice so empty lists are sent as `[]` instead of `null`.
+func mapSlice[T any, R any](items []*T, fn func(*T) R) []R {
+	mapped := make([]R, 0, len(items))
+
+	for _, item := range items {
+		mapped = append(mapped, fn(item))
+	}
+
+	return mapped
+}
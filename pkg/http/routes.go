@@ -0,0 +1,404 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/health"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+	"github.com/grez-lucas/go-gym/pkg/ratelimit"
+)
+
+// How a route authenticates its callers
+type routeAuth int
+
+const (
+	authNone routeAuth = iota
+	// A JWT in the `x-jwt-token` header
+	authJWT
+	// A JWT of an admin
+	authAdmin
+	// A JWT of an admin, or an API key granted the route's scope in
+	// `x-api-key`
+	authAdminOrAPIKey
+	// A JWT, or an API key granted the route's scope
+	authJWTOrAPIKey
+	// Anyone, but an API key that is sent must be valid and granted the
+	// route's scope. Lets services be told apart, rate limited on their own
+	// and seen in the key's last use.
+	authOptionalAPIKey
+)
+
+// Whether routes with this auth let API keys in, and so need a scope
+func (a routeAuth) takesAPIKeys() bool {
+	return a == authAdminOrAPIKey || a == authJWTOrAPIKey || a == authOptionalAPIKey
+}
+
+// A route and everything the API docs say about it. Handlers are wrapped
+// from these fields too, so a route can't do something other than what its
+// docs claim.
+type route struct {
+	method  string
+	path    string
+	handler http.HandlerFunc
+
+	auth routeAuth
+	// Required with the auths taking API keys and one of
+	// domain.APIKeyScopes, keys are only ever let into routes that name one
+	scope string
+	// Unlimited when nil
+	rateLimit  *ratelimit.Policy
+	idempotent bool
+	// Supports If-None-Match and If-Modified-Since
+	conditional bool
+
+	// For the OpenAPI document
+	operationID string
+	tag         string
+	summary     string
+	description string
+	deprecated  bool
+	// Query parameters, by name
+	query map[string]string
+	// What the body decodes into, nil when there's none
+	request   any
+	responses []response
+	// Errors the handler itself returns, the ones coming from auth, rate
+	// limiting and decoding are added based on the fields above
+	errors []int
+}
+
+type response struct {
+	status      int
+	description string
+	// Sent as JSON, nil for no body. A oneOf lists the shapes it can take.
+	body any
+	// Only for responses that aren't JSON
+	contentType string
+}
+
+// Either of the listed bodies
+type oneOf []any
+
+// What the mux matches on
+func (r route) pattern() string {
+	return r.method + " " + r.path
+}
+
+func (s *APIServer) routes() []route {
+	limits := &s.rateLimits
+
+	return []route{
+		{
+			method: "GET", path: "/livez", handler: makeHTTPHandleFunc(s.handleGetLivez),
+			operationID: "getLivez", tag: "health", summary: "Whether the process is up",
+			responses: []response{{status: http.StatusOK, description: "Alive", body: health.Report{}}},
+		},
+		{
+			method: "GET", path: "/readyz", handler: makeHTTPHandleFunc(s.handleGetReadyz),
+			operationID: "getReadyz", tag: "health", summary: "Whether the server can take traffic",
+			description: "Runs the dependency checks, cached for a few seconds. Fails while shutting down.",
+			responses: []response{
+				{status: http.StatusOK, description: "Ready", body: health.Report{}},
+				{status: http.StatusServiceUnavailable, description: "A check failed", body: health.Report{}},
+			},
+		},
+		{
+			method: "GET", path: "/healthcheck", handler: makeHTTPHandleFunc(s.handleGetReadyz),
+			operationID: "getHealthcheck", tag: "health", summary: "Same as /readyz", deprecated: true,
+			responses: []response{
+				{status: http.StatusOK, description: "Ready", body: health.Report{}},
+				{status: http.StatusServiceUnavailable, description: "A check failed", body: health.Report{}},
+			},
+		},
+		{
+			method: "GET", path: "/openapi.json", handler: makeHTTPHandleFunc(s.handleGetOpenAPI), rateLimit: &limits.relaxed, conditional: true,
+			operationID: "getOpenAPI", tag: "docs", summary: "This document",
+			responses: []response{{status: http.StatusOK, description: "The OpenAPI document", contentType: "application/json"}},
+		},
+		{
+			method: "GET", path: "/docs", handler: makeHTTPHandleFunc(s.handleGetDocs), rateLimit: &limits.relaxed, conditional: true,
+			operationID: "getDocs", tag: "docs", summary: "Interactive API docs",
+			responses: []response{{status: http.StatusOK, description: "The docs page", contentType: "text/html"}},
+		},
+		{
+			method: "GET", path: "/docs/{asset}", handler: makeHTTPHandleFunc(s.handleGetDocsAsset), rateLimit: &limits.relaxed, conditional: true,
+			operationID: "getDocsAsset", tag: "docs", summary: "Scripts and styles of the docs page",
+			responses: []response{{status: http.StatusOK, description: "The asset", contentType: "application/octet-stream"}},
+		},
+		{
+			method: "GET", path: "/.well-known/jwks.json", handler: makeHTTPHandleFunc(s.handleGetJWKS),
+			operationID: "getJWKS", tag: "auth", summary: "Public keys our JWTs are signed with",
+			responses: []response{{status: http.StatusOK, description: "The key set", body: keys.JWKSet{}}},
+		},
+		{
+			method: "POST", path: "/auth/login", handler: makeHTTPHandleFunc(s.handleLogin), rateLimit: &limits.strict,
+			operationID: "login", tag: "auth", summary: "Log in with a username and password",
+			description: "Accounts with two-factor authentication get a challenge token to finish at `POST /auth/login/2fa`.",
+			request:     LoginRequest{},
+			responses:   []response{{status: http.StatusOK, description: "Logged in, or a second factor is needed", body: oneOf{LoginResponse{}, MFAChallengeResponse{}}}},
+			errors:      []int{http.StatusUnauthorized},
+		},
+		{
+			method: "POST", path: "/auth/login/2fa", handler: makeHTTPHandleFunc(s.handleMFALogin), rateLimit: &limits.strict,
+			operationID: "loginMFA", tag: "auth", summary: "Finish a login with a TOTP or recovery code",
+			request:   MFALoginRequest{},
+			responses: []response{{status: http.StatusOK, description: "Logged in", body: LoginResponse{}}},
+			errors:    []int{http.StatusUnauthorized},
+		},
+		{
+			method: "POST", path: "/auth/refresh", handler: makeHTTPHandleFunc(s.handleRefresh), rateLimit: &limits.standard,
+			operationID: "refreshToken", tag: "auth", summary: "Trade a refresh token for new tokens",
+			description: "Refresh tokens are single use. Reusing one revokes the whole session.",
+			request:     domain.RefreshTokenRequest{},
+			responses:   []response{{status: http.StatusOK, description: "New tokens", body: LoginResponse{}}},
+			errors:      []int{http.StatusUnauthorized},
+		},
+		{
+			method: "POST", path: "/auth/logout", handler: makeHTTPHandleFunc(s.handleLogout), rateLimit: &limits.standard,
+			operationID: "logout", tag: "auth", summary: "Revoke the session of a refresh token",
+			request:   domain.RefreshTokenRequest{},
+			responses: []response{{status: http.StatusNoContent, description: "Logged out"}},
+		},
+		{
+			method: "POST", path: "/auth/password/forgot", handler: makeHTTPHandleFunc(s.handleForgotPassword), rateLimit: &limits.strict,
+			operationID: "forgotPassword", tag: "auth", summary: "Email a password reset link",
+			description: "Always answers the same, whether the account exists or not.",
+			request:     domain.ForgotPasswordRequest{},
+			responses:   []response{{status: http.StatusAccepted, description: "Sent if the account exists", body: map[string]string{}}},
+		},
+		{
+			method: "POST", path: "/auth/password/reset", handler: makeHTTPHandleFunc(s.handleResetPassword), rateLimit: &limits.strict,
+			operationID: "resetPassword", tag: "auth", summary: "Set a new password with a reset token",
+			request:   domain.ResetPasswordRequest{},
+			responses: []response{{status: http.StatusNoContent, description: "Password changed"}},
+		},
+		{
+			method: "POST", path: "/auth/email/verify", handler: makeHTTPHandleFunc(s.handleVerifyEmail), rateLimit: &limits.strict,
+			operationID: "verifyEmail", tag: "auth", summary: "Verify an email address with the emailed token",
+			request:   domain.VerifyEmailRequest{},
+			responses: []response{{status: http.StatusNoContent, description: "Email verified"}},
+		},
+		{
+			method: "GET", path: "/auth/oidc/providers", handler: makeHTTPHandleFunc(s.handleGetOIDCProviders), rateLimit: &limits.relaxed,
+			operationID: "getOIDCProviders", tag: "auth", summary: "Identity providers users can sign in with",
+			responses: []response{{status: http.StatusOK, description: "Provider names", body: map[string][]string{}}},
+		},
+		{
+			method: "GET", path: "/auth/oidc/{provider}/login", handler: makeHTTPHandleFunc(s.handleOIDCLogin), rateLimit: &limits.standard,
+			operationID: "oidcLogin", tag: "auth", summary: "Start signing in with an identity provider",
+			query:     map[string]string{"login_hint": "Passed on to the provider"},
+			responses: []response{{status: http.StatusFound, description: "Redirect to the provider"}},
+		},
+		{
+			method: "GET", path: "/auth/oidc/{provider}/callback", handler: makeHTTPHandleFunc(s.handleOIDCCallback), rateLimit: &limits.strict,
+			operationID: "oidcCallback", tag: "auth", summary: "Where the identity provider sends users back to",
+			query: map[string]string{
+				"code":  "Authorization code from the provider",
+				"state": "State we sent the provider",
+				"error": "Set by the provider when sign in failed",
+			},
+			responses: []response{{status: http.StatusOK, description: "Logged in, or a second factor is needed", body: oneOf{LoginResponse{}, MFAChallengeResponse{}}}},
+			errors:    []int{http.StatusBadRequest, http.StatusUnauthorized},
+		},
+		{
+			method: "GET", path: "/gyms", handler: makeHTTPHandleFunc(s.handleGetGyms),
+			auth: authOptionalAPIKey, scope: domain.ScopeGymsRead, rateLimit: &limits.relaxed, conditional: true,
+			operationID: "getGyms", tag: "gyms", summary: "List gyms",
+			responses: []response{{status: http.StatusOK, description: "All gyms", body: []GymV1{}}},
+		},
+		{
+			method: "GET", path: "/gyms/{id}", handler: makeHTTPHandleFunc(s.handleGetGym),
+			auth: authOptionalAPIKey, scope: domain.ScopeGymsRead, rateLimit: &limits.relaxed, conditional: true,
+			operationID: "getGym", tag: "gyms", summary: "Get a gym",
+			responses: []response{{status: http.StatusOK, description: "The gym", body: GymV1{}}},
+		},
+		{
+			method: "POST", path: "/gyms", handler: makeHTTPHandleFunc(s.handleCreateGym), rateLimit: &limits.standard,
+			operationID: "createGym", tag: "gyms", summary: "Create a gym",
+			request:   domain.CreateGymRequest{},
+			responses: []response{{status: http.StatusCreated, description: "The new gym", body: GymV1{}}},
+		},
+		{
+			method: "PUT", path: "/gyms/{id}", handler: makeHTTPHandleFunc(s.handleUpdateGym),
+			auth: authAdmin, rateLimit: &limits.standard,
+			operationID: "updateGym", tag: "gyms", summary: "Rename or redescribe a gym",
+			request:   domain.UpdateGymRequest{},
+			responses: []response{{status: http.StatusOK, description: "The updated gym", body: GymV1{}}},
+		},
+		{
+			method: "DELETE", path: "/gyms/{id}", handler: makeHTTPHandleFunc(s.handleDeleteGym), rateLimit: &limits.standard,
+			operationID: "deleteGym", tag: "gyms", summary: "Delete a gym",
+			responses: []response{{status: http.StatusOK, description: "Deleted", body: map[string]int{}}},
+		},
+		{
+			method: "POST", path: "/gyms/{id}/ratings", handler: makeHTTPHandleFunc(s.handleRateGym),
+			auth: authJWTOrAPIKey, scope: domain.ScopeRatingsWrite, rateLimit: &limits.standard, idempotent: true,
+			operationID: "rateGym", tag: "gyms", summary: "Rate a gym",
+			description: "Only accounts with a verified email address can rate gyms. Ratings posted with an API key carry the key's name and no account.",
+			request:     domain.CreateRatingRequest{},
+			responses:   []response{{status: http.StatusCreated, description: "The new rating", body: RatingV1{}}},
+			errors:      []int{http.StatusForbidden},
+		},
+		{
+			method: "GET", path: "/accounts", handler: makeHTTPHandleFunc(s.handleGetAccounts),
+			auth: authAdminOrAPIKey, scope: domain.ScopeAccountsRead, rateLimit: &limits.standard,
+			operationID: "getAccounts", tag: "accounts", summary: "List accounts",
+			responses: []response{{status: http.StatusOK, description: "All accounts", body: []AccountV1{}}},
+		},
+		{
+			method: "POST", path: "/accounts", handler: makeHTTPHandleFunc(s.handleCreateAccount), rateLimit: &limits.strict, idempotent: true,
+			operationID: "createAccount", tag: "accounts", summary: "Sign up",
+			description: "Emails a link to verify the address.",
+			request:     domain.CreateAccountRequest{},
+			responses:   []response{{status: http.StatusCreated, description: "The new account", body: AccountV1{}}},
+			errors:      []int{http.StatusConflict},
+		},
+		{
+			method: "GET", path: "/accounts/me", handler: makeHTTPHandleFunc(s.handleGetMe), auth: authJWT, rateLimit: &limits.standard,
+			operationID: "getMe", tag: "accounts", summary: "Get your account",
+			responses: []response{{status: http.StatusOK, description: "Your account", body: AccountV1{}}},
+		},
+		{
+			method: "PATCH", path: "/accounts/me", handler: makeHTTPHandleFunc(s.handleUpdateMe), auth: authJWT, rateLimit: &limits.standard,
+			operationID: "updateMe", tag: "accounts", summary: "Change your username",
+			request:   UpdateAccountRequest{},
+			responses: []response{{status: http.StatusOK, description: "Your account", body: AccountV1{}}},
+			errors:    []int{http.StatusConflict},
+		},
+		{
+			method: "DELETE", path: "/accounts/me", handler: makeHTTPHandleFunc(s.handleDeleteMe), auth: authJWT, rateLimit: &limits.standard,
+			operationID: "deleteMe", tag: "accounts", summary: "Delete your account",
+			description: "Your ratings stay, credited to a deleted user.",
+			request:     DeleteAccountRequest{},
+			responses:   []response{{status: http.StatusNoContent, description: "Deleted"}},
+			errors:      []int{http.StatusForbidden},
+		},
+		{
+			method: "GET", path: "/accounts/me/export", handler: makeHTTPHandleFunc(s.handleExportMe), auth: authJWT, rateLimit: &limits.standard,
+			operationID: "exportMe", tag: "accounts", summary: "Export everything stored about you",
+			responses: []response{{status: http.StatusOK, description: "Your data", body: AccountExport{}}},
+		},
+		{
+			method: "POST", path: "/accounts/me/password", handler: makeHTTPHandleFunc(s.handleChangePassword), auth: authJWT, rateLimit: &limits.strict,
+			operationID: "changePassword", tag: "accounts", summary: "Change your password",
+			request:   domain.ChangePasswordRequest{},
+			responses: []response{{status: http.StatusNoContent, description: "Password changed"}},
+			errors:    []int{http.StatusForbidden},
+		},
+		{
+			method: "POST", path: "/accounts/me/2fa/totp", handler: makeHTTPHandleFunc(s.handleEnrollTOTP), auth: authJWT, rateLimit: &limits.standard,
+			operationID: "enrollTOTP", tag: "accounts", summary: "Start enabling two-factor authentication",
+			responses: []response{{status: http.StatusOK, description: "The secret to add to an authenticator app", body: TOTPEnrollmentResponse{}}},
+			errors:    []int{http.StatusConflict},
+		},
+		{
+			method: "POST", path: "/accounts/me/2fa/totp/confirm", handler: makeHTTPHandleFunc(s.handleConfirmTOTP), auth: authJWT, rateLimit: &limits.strict,
+			operationID: "confirmTOTP", tag: "accounts", summary: "Finish enabling two-factor authentication",
+			request:   TOTPCodeRequest{},
+			responses: []response{{status: http.StatusOK, description: "Enabled", body: RecoveryCodesResponse{}}},
+			errors:    []int{http.StatusConflict},
+		},
+		{
+			method: "DELETE", path: "/accounts/me/2fa/totp", handler: makeHTTPHandleFunc(s.handleDisableTOTP), auth: authJWT, rateLimit: &limits.strict,
+			operationID: "disableTOTP", tag: "accounts", summary: "Disable two-factor authentication",
+			request:   DisableTOTPRequest{},
+			responses: []response{{status: http.StatusNoContent, description: "Disabled"}},
+			errors:    []int{http.StatusForbidden},
+		},
+		{
+			method: "POST", path: "/accounts/me/2fa/recovery-codes", handler: makeHTTPHandleFunc(s.handleRegenerateRecoveryCodes), auth: authJWT, rateLimit: &limits.strict,
+			operationID: "regenerateRecoveryCodes", tag: "accounts", summary: "Replace your recovery codes",
+			request:   TOTPCodeRequest{},
+			responses: []response{{status: http.StatusOK, description: "The new codes", body: RecoveryCodesResponse{}}},
+			errors:    []int{http.StatusForbidden},
+		},
+		{
+			method: "PUT", path: "/accounts/me/email", handler: makeHTTPHandleFunc(s.handleChangeEmail), auth: authJWT, rateLimit: &limits.strict,
+			operationID: "changeEmail", tag: "accounts", summary: "Change your email address",
+			description: "The new address is used once verified.",
+			request:     domain.ChangeEmailRequest{},
+			responses:   []response{{status: http.StatusAccepted, description: "Verification link sent", body: map[string]string{}}},
+			errors:      []int{http.StatusForbidden, http.StatusConflict},
+		},
+		{
+			method: "POST", path: "/accounts/me/email/verification", handler: makeHTTPHandleFunc(s.handleResendEmailVerification), auth: authJWT, rateLimit: &limits.strict,
+			operationID: "resendEmailVerification", tag: "accounts", summary: "Resend the verification link",
+			responses: []response{{status: http.StatusAccepted, description: "Verification link sent", body: map[string]string{}}},
+			errors:    []int{http.StatusConflict},
+		},
+		{
+			method: "POST", path: "/admin/accounts/{id}/unlock", handler: makeHTTPHandleFunc(s.handleUnlockAccount), auth: authAdmin, rateLimit: &limits.standard,
+			operationID: "unlockAccount", tag: "admin", summary: "Lift a login lockout",
+			responses: []response{{status: http.StatusOK, description: "Unlocked", body: map[string]int{}}},
+		},
+		{
+			method: "GET", path: "/admin/api-keys", handler: makeHTTPHandleFunc(s.handleGetAPIKeys), auth: authAdmin, rateLimit: &limits.standard,
+			operationID: "getAPIKeys", tag: "admin", summary: "List API keys",
+			responses: []response{{status: http.StatusOK, description: "All API keys", body: []APIKeyV1{}}},
+		},
+		{
+			method: "POST", path: "/admin/api-keys", handler: makeHTTPHandleFunc(s.handleCreateAPIKey), auth: authAdmin, rateLimit: &limits.standard,
+			operationID: "createAPIKey", tag: "admin", summary: "Create an API key",
+			description: "The full key is only ever shown in this response.",
+			request:     domain.CreateAPIKeyRequest{},
+			responses:   []response{{status: http.StatusCreated, description: "The new key", body: CreateAPIKeyResponse{}}},
+		},
+		{
+			method: "DELETE", path: "/admin/api-keys/{id}", handler: makeHTTPHandleFunc(s.handleRevokeAPIKey), auth: authAdmin, rateLimit: &limits.standard,
+			operationID: "revokeAPIKey", tag: "admin", summary: "Revoke an API key",
+			responses: []response{{status: http.StatusOK, description: "Revoked", body: map[string]int{}}},
+		},
+	}
+}
+
+// The mux with every route in the table
+func (s *APIServer) router() *http.ServeMux {
+	router := http.NewServeMux()
+
+	for _, r := range s.routes() {
+		router.Handle(r.pattern(), s.routeHandler(r))
+	}
+
+	// Not part of the API, so not in the table or the docs either
+	if s.config.OIDCMockEnabled {
+		s.registerMockOIDCProvider(router)
+	}
+
+	return router
+}
+
+// Wraps the route's handler, innermost first. The rate limit sits inside auth
+// so it can count requests per account or API key.
+func (s *APIServer) routeHandler(r route) http.HandlerFunc {
+	if r.auth.takesAPIKeys() != (r.scope != "") || (r.scope != "" && !slices.Contains(domain.APIKeyScopes, r.scope)) {
+		panic(fmt.Sprintf("http: route %s needs a known scope exactly when it takes API keys, got `%s`", r.pattern(), r.scope))
+	}
+
+	handler := r.handler
+
+	if r.idempotent {
+		handler = s.WithIdempotency(handler)
+	}
+
+	if r.rateLimit != nil {
+		handler = s.WithRateLimit(*r.rateLimit, handler)
+	}
+
+	switch r.auth {
+	case authJWT:
+		handler = s.WithJWTAuth(handler)
+	case authAdmin:
+		handler = s.WithJWTAuth(s.WithAdmin(handler))
+	case authAdminOrAPIKey:
+		handler = s.WithAPIKeyAuth(r.scope, s.WithJWTAuth(s.WithAdmin(handler)), handler)
+	case authJWTOrAPIKey:
+		handler = s.WithAPIKeyAuth(r.scope, s.WithJWTAuth(handler), handler)
+	case authOptionalAPIKey:
+		handler = s.WithAPIKeyAuth(r.scope, handler, handler)
+	}
+
+	return handler
+}
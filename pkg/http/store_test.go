@@ -0,0 +1,892 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Real password hashes take most of a second, every server makes one
+func TestMain(m *testing.M) {
+	storage.PasswordHashCost = bcrypt.MinCost
+
+	os.Exit(m.Run())
+}
+
+// An in memory Storage with just what the handler tests use. Anything else
+// panics on the nil Storage it embeds, so a test touching more shows up
+// right away.
+type memoryStore struct {
+	storage.Storage
+
+	mu            sync.Mutex
+	nextID        int
+	accounts      map[int]*domain.Account
+	identities    map[string]*domain.Identity
+	loginAttempts map[string]*domain.LoginAttempts
+	totpCounters  map[int]int64
+	// Recovery code hashes by account, true once used
+	recoveryCodes map[int]map[string]bool
+	refreshTokens []*domain.RefreshToken
+	accountTokens []*domain.AccountToken
+	ratings       []*domain.Rating
+	apiKeys       []*domain.APIKey
+	gyms          map[int]*domain.Gym
+	// When a gym was last deleted
+	gymDeletedAt    time.Time
+	idempotencyKeys map[string]*domain.IdempotencyKey
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		accounts:      map[int]*domain.Account{},
+		identities:    map[string]*domain.Identity{},
+		loginAttempts: map[string]*domain.LoginAttempts{},
+		totpCounters:  map[int]int64{},
+		recoveryCodes: map[int]map[string]bool{},
+		gyms:          map[int]*domain.Gym{},
+
+		idempotencyKeys: map[string]*domain.IdempotencyKey{},
+	}
+}
+
+func notFoundError(what string) error {
+	return &storage.Error{Kind: storage.ErrNotFound, Message: what + " not found"}
+}
+
+// Adds an account the way CreateAccount stores it
+func (s *memoryStore) addAccount(acc *domain.Account) *domain.Account {
+	hash, err := bcrypt.GenerateFromPassword([]byte(acc.Password), storage.PasswordHashCost)
+
+	if err != nil {
+		panic(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	stored := *acc
+	stored.ID = s.nextID
+	stored.Password = string(hash)
+	s.accounts[stored.ID] = &stored
+
+	copied := stored
+
+	return &copied
+}
+
+func (s *memoryStore) CreateAccount(ctx context.Context, acc *domain.Account) (*domain.Account, error) {
+	return s.addAccount(acc), nil
+}
+
+func (s *memoryStore) GetAccountByID(ctx context.Context, id int) (*domain.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[id]
+
+	if !ok {
+		return nil, notFoundError(fmt.Sprintf("Account with ID %d", id))
+	}
+
+	copied := *acc
+
+	return &copied, nil
+}
+
+func (s *memoryStore) GetAccountByUsername(ctx context.Context, username string) (*domain.Account, error) {
+	// Exact, like `username=$1`
+	return s.findAccount(func(acc *domain.Account) bool { return acc.UserName == username })
+}
+
+func (s *memoryStore) GetAccountByEmail(ctx context.Context, email string) (*domain.Account, error) {
+	return s.findAccount(func(acc *domain.Account) bool { return acc.Email != "" && strings.EqualFold(acc.Email, email) })
+}
+
+func (s *memoryStore) findAccount(match func(*domain.Account) bool) (*domain.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, acc := range s.accounts {
+		if match(acc) {
+			copied := *acc
+			return &copied, nil
+		}
+	}
+
+	return nil, notFoundError("Account")
+}
+
+// Renames the ratings too, like the PostgreSQL transaction
+func (s *memoryStore) UpdateAccountUsername(ctx context.Context, id int, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[id]
+
+	if !ok {
+		return notFoundError(fmt.Sprintf("Account with ID %d", id))
+	}
+
+	acc.UserName = username
+
+	for _, rating := range s.ratings {
+		if rating.AccountID == id {
+			rating.UserName = username
+		}
+	}
+
+	return nil
+}
+
+// Takes the plain password and stores its hash
+func (s *memoryStore) UpdateAccountPassword(ctx context.Context, id int, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), storage.PasswordHashCost)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[id]
+
+	if !ok {
+		return notFoundError(fmt.Sprintf("Account with ID %d", id))
+	}
+
+	acc.Password = string(hash)
+
+	return nil
+}
+
+// What ON DELETE CASCADE and SET NULL do in PostgreSQL, ratings are
+// anonymized like in the transaction
+func (s *memoryStore) DeleteAccount(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[id]; !ok {
+		return notFoundError("Account")
+	}
+
+	delete(s.accounts, id)
+	delete(s.totpCounters, id)
+	delete(s.recoveryCodes, id)
+
+	for name, identity := range s.identities {
+		if identity.AccountID == id {
+			delete(s.identities, name)
+		}
+	}
+
+	s.refreshTokens = slices.DeleteFunc(s.refreshTokens, func(token *domain.RefreshToken) bool { return token.AccountID == id })
+	s.accountTokens = slices.DeleteFunc(s.accountTokens, func(token *domain.AccountToken) bool { return token.AccountID == id })
+
+	for _, key := range s.apiKeys {
+		if key.CreatedBy == id {
+			key.CreatedBy = 0
+		}
+	}
+
+	for _, rating := range s.ratings {
+		if rating.AccountID == id {
+			rating.AccountID = 0
+			rating.UserName = domain.DeletedUserName
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) VerifyAccountEmail(ctx context.Context, id int, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.verifyAccountEmail(id, email)
+}
+
+// Enforces the unique LOWER(email) index
+func (s *memoryStore) verifyAccountEmail(id int, email string) error {
+	for _, other := range s.accounts {
+		if other.ID != id && strings.EqualFold(other.Email, email) {
+			return &storage.Error{Kind: storage.ErrConflict, Message: "Already exists"}
+		}
+	}
+
+	acc, ok := s.accounts[id]
+
+	if !ok {
+		return notFoundError(fmt.Sprintf("Account with ID %d", id))
+	}
+
+	now := time.Now().UTC()
+	acc.Email = email
+	acc.EmailVerifiedAt = &now
+
+	return nil
+}
+
+func (s *memoryStore) GetLoginAttempts(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.loginAttempts[key]; ok {
+		copied := *attempts
+		return &copied, nil
+	}
+
+	return &domain.LoginAttempts{Key: key}, nil
+}
+
+func (s *memoryStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*domain.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.loginAttempts[key]
+
+	if !ok {
+		attempts = &domain.LoginAttempts{Key: key}
+		s.loginAttempts[key] = attempts
+	}
+
+	attempts.Failures++
+	attempts.LastFailureAt = time.Now().UTC()
+
+	copied := *attempts
+
+	return &copied, nil
+}
+
+func (s *memoryStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if attempts, ok := s.loginAttempts[key]; ok {
+		attempts.LockedUntil = &until
+	}
+
+	return nil
+}
+
+func (s *memoryStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+
+	return nil
+}
+
+func (s *memoryStore) CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) (*domain.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addRefreshToken(token), nil
+}
+
+func (s *memoryStore) addRefreshToken(token *domain.RefreshToken) *domain.RefreshToken {
+	s.nextID++
+	stored := *token
+	stored.ID = s.nextID
+	s.refreshTokens = append(s.refreshTokens, &stored)
+
+	copied := stored
+
+	return &copied
+}
+
+func (s *memoryStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.TokenHash == hash {
+			copied := *token
+			return &copied, nil
+		}
+	}
+
+	return nil, notFoundError("Refresh token")
+}
+
+// Same rule as the PostgreSQL update: only an unused, unrevoked token rotates
+func (s *memoryStore) RotateRefreshToken(ctx context.Context, used *domain.RefreshToken, next *domain.RefreshToken) (*domain.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.refreshTokens {
+		if token.ID != used.ID {
+			continue
+		}
+
+		if token.UsedAt != nil || token.RevokedAt != nil {
+			return nil, storage.ErrRefreshTokenReused
+		}
+
+		now := time.Now().UTC()
+		token.UsedAt = &now
+
+		return s.addRefreshToken(next), nil
+	}
+
+	return nil, storage.ErrRefreshTokenReused
+}
+
+func (s *memoryStore) GetRefreshTokensByAccount(ctx context.Context, accountID int) ([]*domain.RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []*domain.RefreshToken{}
+
+	for _, token := range s.refreshTokens {
+		if token.AccountID == accountID {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (s *memoryStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for _, token := range s.refreshTokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) RevokeAccountRefreshTokens(ctx context.Context, accountID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for _, token := range s.refreshTokens {
+		if token.AccountID == accountID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+		}
+	}
+
+	return nil
+}
+
+// Same rule as the totp_last_counter update: only later steps are accepted
+func (s *memoryStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.totpCounters[id]; ok && last >= counter {
+		return false, nil
+	}
+
+	s.totpCounters[id] = counter
+
+	return true, nil
+}
+
+func (s *memoryStore) ReplaceRecoveryCodes(ctx context.Context, accountID int, hashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	codes := map[string]bool{}
+
+	for _, hash := range hashes {
+		codes[hash] = false
+	}
+
+	s.recoveryCodes[accountID] = codes
+
+	return nil
+}
+
+func (s *memoryStore) CountRecoveryCodes(ctx context.Context, accountID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+
+	for _, used := range s.recoveryCodes[accountID] {
+		if !used {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *memoryStore) UseRecoveryCode(ctx context.Context, accountID int, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.recoveryCodes[accountID][hash]
+
+	if !ok || used {
+		return false, nil
+	}
+
+	s.recoveryCodes[accountID][hash] = true
+
+	return true, nil
+}
+
+func (s *memoryStore) CreateIdentity(ctx context.Context, identity *domain.Identity) (*domain.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.identities[identity.Provider+"|"+identity.Subject] = identity
+
+	return identity, nil
+}
+
+func (s *memoryStore) GetIdentity(ctx context.Context, provider string, subject string) (*domain.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.identities[provider+"|"+subject]
+
+	if !ok {
+		return nil, notFoundError("Identity")
+	}
+
+	return identity, nil
+}
+
+func (s *memoryStore) GetIdentitiesByAccount(ctx context.Context, accountID int) ([]*domain.Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identities := []*domain.Identity{}
+
+	for _, identity := range s.identities {
+		if identity.AccountID == accountID {
+			copied := *identity
+			identities = append(identities, &copied)
+		}
+	}
+
+	return identities, nil
+}
+
+func (s *memoryStore) GetAccounts(ctx context.Context) ([]*domain.Account, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	accounts := []*domain.Account{}
+
+	for _, acc := range s.accounts {
+		copied := *acc
+		accounts = append(accounts, &copied)
+	}
+
+	return accounts, nil
+}
+
+func (s *memoryStore) addAPIKey(key *domain.APIKey) *domain.APIKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	key.ID = s.nextID
+	s.apiKeys = append(s.apiKeys, key)
+
+	return key
+}
+
+func (s *memoryStore) CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error) {
+	stored := *key
+	s.addAPIKey(&stored)
+
+	copied := stored
+
+	return &copied, nil
+}
+
+func (s *memoryStore) GetAPIKeysByCreator(ctx context.Context, accountID int) ([]*domain.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []*domain.APIKey{}
+
+	for _, key := range s.apiKeys {
+		if key.CreatedBy == accountID {
+			copied := *key
+			keys = append(keys, &copied)
+		}
+	}
+
+	return keys, nil
+}
+
+func (s *memoryStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.apiKeys {
+		if key.Prefix == prefix {
+			copied := *key
+			return &copied, nil
+		}
+	}
+
+	return nil, notFoundError("API key")
+}
+
+func (s *memoryStore) TouchAPIKey(ctx context.Context, id int) error {
+	return nil
+}
+
+func (s *memoryStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[id]
+
+	if !ok {
+		return notFoundError(fmt.Sprintf("Account with ID %d", id))
+	}
+
+	acc.TOTPSecret = secret
+	acc.TOTPEnabledAt = nil
+	delete(s.totpCounters, id)
+
+	return nil
+}
+
+func (s *memoryStore) EnableTOTP(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acc, ok := s.accounts[id]
+
+	if !ok {
+		return notFoundError(fmt.Sprintf("Account with ID %d", id))
+	}
+
+	now := time.Now().UTC()
+	acc.TOTPEnabledAt = &now
+
+	return nil
+}
+
+func (s *memoryStore) deleteAccount(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.accounts, id)
+}
+
+func (s *memoryStore) CreateGym(ctx context.Context, gym *domain.Gym) (*domain.Gym, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	stored := *gym
+	stored.ID = s.nextID
+	s.gyms[stored.ID] = &stored
+
+	copied := stored
+
+	return &copied, nil
+}
+
+func (s *memoryStore) GetGymByID(ctx context.Context, id int) (*domain.Gym, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gym, ok := s.gyms[id]
+
+	if !ok {
+		return nil, notFoundError(fmt.Sprintf("Gym with ID %d", id))
+	}
+
+	copied := *gym
+
+	return &copied, nil
+}
+
+func (s *memoryStore) GetGyms(ctx context.Context) ([]*domain.Gym, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	gyms := []*domain.Gym{}
+
+	for id := 1; id <= s.nextID; id++ {
+		if gym, ok := s.gyms[id]; ok {
+			copied := *gym
+			gyms = append(gyms, &copied)
+		}
+	}
+
+	return gyms, nil
+}
+
+func (s *memoryStore) UpdateGym(ctx context.Context, gym *domain.Gym) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.gyms[gym.ID]
+
+	if !ok {
+		return notFoundError(fmt.Sprintf("Gym with ID %d", gym.ID))
+	}
+
+	stored.Name = gym.Name
+	stored.Description = gym.Description
+	stored.UpdatedAt = gym.UpdatedAt
+
+	return nil
+}
+
+func (s *memoryStore) DeleteGym(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.gyms[id]; !ok {
+		return notFoundError(fmt.Sprintf("Gym with ID %d", id))
+	}
+
+	delete(s.gyms, id)
+	s.gymDeletedAt = time.Now().UTC()
+
+	return nil
+}
+
+func (s *memoryStore) GetLastGymDeletion(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.gymDeletedAt, nil
+}
+
+// Same rules as the upsert in ClaimIdempotencyKey: live keys conflict,
+// expired ones are taken over
+func (s *memoryStore) ClaimIdempotencyKey(ctx context.Context, k *domain.IdempotencyKey) (*domain.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.idempotencyKeys[k.Scope+"|"+k.Key]; ok && existing.ExpiresAt.After(k.CreatedAt) {
+		return nil, &storage.Error{Kind: storage.ErrConflict, Message: "Idempotency key already in use"}
+	}
+
+	s.nextID++
+	claimed := *k
+	claimed.ID = s.nextID
+	s.idempotencyKeys[k.Scope+"|"+k.Key] = &claimed
+
+	copied := claimed
+
+	return &copied, nil
+}
+
+func (s *memoryStore) GetIdempotencyKey(ctx context.Context, scope string, key string) (*domain.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k, ok := s.idempotencyKeys[scope+"|"+key]
+
+	if !ok || !k.ExpiresAt.After(time.Now().UTC()) {
+		return nil, notFoundError("Idempotency key")
+	}
+
+	copied := *k
+
+	return &copied, nil
+}
+
+func (s *memoryStore) CompleteIdempotencyKey(ctx context.Context, id int, status int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, k := range s.idempotencyKeys {
+		if k.ID == id {
+			k.ResponseStatus = status
+			k.ResponseContentType = contentType
+			k.ResponseBody = body
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) DeleteIdempotencyKey(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, k := range s.idempotencyKeys {
+		if k.ID == id {
+			delete(s.idempotencyKeys, name)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) DeleteIdempotencyKeysByScope(ctx context.Context, scope string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, k := range s.idempotencyKeys {
+		if k.Scope == scope {
+			delete(s.idempotencyKeys, name)
+		}
+	}
+
+	return nil
+}
+
+func (s *memoryStore) CreateAccountToken(ctx context.Context, token *domain.AccountToken) (*domain.AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	stored := *token
+	stored.ID = s.nextID
+	s.accountTokens = append(s.accountTokens, &stored)
+
+	copied := stored
+
+	return &copied, nil
+}
+
+// Same conditions as the single UPDATE in PostgreSQL
+func (s *memoryStore) ConsumeAccountToken(ctx context.Context, hash string, purpose string) (*domain.AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.consumeAccountToken(hash, purpose)
+}
+
+// A conflict on the address leaves the token unused, like the rolled back
+// transaction
+func (s *memoryStore) ConsumeEmailVerificationToken(ctx context.Context, hash string) (*domain.AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, err := s.consumeAccountToken(hash, domain.PurposeVerifyEmail)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.verifyAccountEmail(token.AccountID, token.Email); err != nil {
+		for _, stored := range s.accountTokens {
+			if stored.ID == token.ID {
+				stored.UsedAt = nil
+			}
+		}
+
+		return nil, err
+	}
+
+	return token, nil
+}
+
+func (s *memoryStore) consumeAccountToken(hash string, purpose string) (*domain.AccountToken, error) {
+	now := time.Now().UTC()
+
+	for _, token := range s.accountTokens {
+		if token.TokenHash == hash && token.Purpose == purpose && token.UsedAt == nil && token.ExpiresAt.After(now) {
+			token.UsedAt = &now
+
+			copied := *token
+			return &copied, nil
+		}
+	}
+
+	return nil, notFoundError("Token")
+}
+
+func (s *memoryStore) DeleteAccountTokens(ctx context.Context, accountID int, purpose string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.accountTokens = slices.DeleteFunc(s.accountTokens, func(token *domain.AccountToken) bool {
+		return token.AccountID == accountID && token.Purpose == purpose
+	})
+
+	return nil
+}
+
+func (s *memoryStore) GetLatestAccountToken(ctx context.Context, accountID int, purpose string) (*domain.AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.accountTokens) - 1; i >= 0; i-- {
+		if token := s.accountTokens[i]; token.AccountID == accountID && token.Purpose == purpose {
+			copied := *token
+			return &copied, nil
+		}
+	}
+
+	return nil, notFoundError("Token")
+}
+
+func (s *memoryStore) GetAccountTokensByAccount(ctx context.Context, accountID int) ([]*domain.AccountToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tokens := []*domain.AccountToken{}
+
+	for _, token := range s.accountTokens {
+		if token.AccountID == accountID {
+			copied := *token
+			tokens = append(tokens, &copied)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (s *memoryStore) CreateRating(ctx context.Context, rating *domain.Rating) (*domain.Rating, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.gyms[rating.GymID]; !ok {
+		return nil, notFoundError("Gym")
+	}
+
+	s.nextID++
+	stored := *rating
+	stored.ID = s.nextID
+	s.ratings = append(s.ratings, &stored)
+
+	copied := stored
+
+	return &copied, nil
+}
+
+func (s *memoryStore) GetRatingsByAccount(ctx context.Context, accountID int) ([]*domain.Rating, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ratings := []*domain.Rating{}
+
+	for _, rating := range s.ratings {
+		if rating.AccountID == accountID {
+			copied := *rating
+			ratings = append(ratings, &copied)
+		}
+	}
+
+	return ratings, nil
+}
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+// Random URL safe string with `size` bytes of entropy
+func generateOpaqueToken(size int) (string, error) {
+	bytes := make([]byte, size)
+
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// Refresh tokens are random enough that a plain SHA-256 is fine, no need for
+// bcrypt here. It also lets us look tokens up by their hash.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Creates a refresh token for `familyID` and returns the raw value, which is
+// the only time it's ever visible.
+func (s *APIServer) newRefreshToken(accountID int, familyID string) (string, *domain.RefreshToken, error) {
+	raw, err := generateOpaqueToken(32)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	return raw, domain.NewRefreshToken(accountID, familyID, hashToken(raw), s.config.RefreshTokenTTL), nil
+}
+
+func (s *APIServer) newLoginResponse(account *domain.Account, refreshToken string) (*LoginResponse, error) {
+	accessToken, err := s.CreateJWT(account)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.config.AccessTokenTTL.Seconds()),
+		AccountID:    account.ID,
+	}, nil
+}
+
+// Issues an access token and starts a new refresh token family, used on every
+// fresh login.
+func (s *APIServer) issueTokens(ctx context.Context, account *domain.Account) (*LoginResponse, error) {
+	familyID, err := generateOpaqueToken(16)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, refreshToken, err := s.newRefreshToken(account.ID, familyID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.store.CreateRefreshToken(ctx, refreshToken); err != nil {
+		return nil, err
+	}
+
+	return s.newLoginResponse(account, raw)
+}
+
+func (s *APIServer) handleRefresh(w http.ResponseWriter, req *http.Request) error {
+	var refreshRequest domain.RefreshTokenRequest
+
+	if err := decodeJSON(w, req, &refreshRequest); err != nil {
+		return err
+	}
+
+	token, err := s.store.GetRefreshTokenByHash(req.Context(), hashToken(refreshRequest.RefreshToken))
+
+	if errors.Is(err, storage.ErrNotFound) {
+		metrics.AuthFailures.WithLabelValues("refresh_token").Inc()
+		return unauthorized("Invalid refresh token")
+	}
+
+	// Anything else is on us, a 401 would make clients drop a working session
+	if err != nil {
+		return err
+	}
+
+	if token.RevokedAt != nil || token.IsExpired() {
+		metrics.AuthFailures.WithLabelValues("refresh_token").Inc()
+		return unauthorized("Invalid refresh token")
+	}
+
+	if token.UsedAt != nil {
+		return s.handleRefreshTokenReuse(w, req, token)
+	}
+
+	account, err := s.store.GetAccountByID(req.Context(), token.AccountID)
+
+	if err != nil {
+		return err
+	}
+
+	raw, next, err := s.newRefreshToken(account.ID, token.FamilyID)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.store.RotateRefreshToken(req.Context(), token, next); err != nil {
+		// Lost a race against another refresh with the same token
+		if errors.Is(err, storage.ErrRefreshTokenReused) {
+			return s.handleRefreshTokenReuse(w, req, token)
+		}
+		return err
+	}
+
+	resp, err := s.newLoginResponse(account, raw)
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+// A refresh token was presented twice: either the client or an attacker holds
+// a stolen copy, and we can't tell which. Kill the whole family so both have
+// to log in again.
+func (s *APIServer) handleRefreshTokenReuse(w http.ResponseWriter, req *http.Request, token *domain.RefreshToken) error {
+	slog.WarnContext(req.Context(), "Refresh token reuse detected, revoking its family", "account_id", token.AccountID, "family_id", token.FamilyID)
+
+	if err := s.store.RevokeRefreshTokenFamily(req.Context(), token.FamilyID); err != nil {
+		return err
+	}
+
+	metrics.AuthFailures.WithLabelValues("refresh_token").Inc()
+
+	return unauthorized("Invalid refresh token")
+}
+
+// Revokes the session the refresh token belongs to. Access tokens already
+// handed out stay valid until they expire, which is why they are short lived.
+func (s *APIServer) handleLogout(w http.ResponseWriter, req *http.Request) error {
+	var logoutRequest domain.RefreshTokenRequest
+
+	if err := decodeJSON(w, req, &logoutRequest); err != nil {
+		return err
+	}
+
+	token, err := s.store.GetRefreshTokenByHash(req.Context(), hashToken(logoutRequest.RefreshToken))
+
+	// Logging out an unknown session is a no-op
+	if errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	// Clients must not think the session is gone when we couldn't check
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.RevokeRefreshTokenFamily(req.Context(), token.FamilyID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+func login(t *testing.T, handler http.Handler) LoginResponse {
+	t.Helper()
+
+	var resp LoginResponse
+
+	rec := doJSON(t, handler, "POST", "/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}, nil, &resp)
+
+	if rec.Code != http.StatusOK || resp.RefreshToken == "" {
+		t.Fatalf("Login: got %d %s, want tokens", rec.Code, rec.Body.String())
+	}
+
+	return resp
+}
+
+func refresh(t *testing.T, handler http.Handler, refreshToken string) (int, LoginResponse) {
+	t.Helper()
+
+	var resp LoginResponse
+
+	rec := doJSON(t, handler, "POST", "/auth/refresh", domain.RefreshTokenRequest{RefreshToken: refreshToken}, nil, &resp)
+
+	return rec.Code, resp
+}
+
+func newTokenTestServer(t *testing.T) (http.Handler, *memoryStore) {
+	t.Helper()
+
+	s, store := newAuthTestServer(t, &config.Config{})
+	store.addAccount(domain.NewAccount("alice", "correct horse"))
+
+	return s.router(), store
+}
+
+func TestRefreshRotates(t *testing.T) {
+	handler, _ := newTokenTestServer(t)
+
+	first := login(t, handler)
+
+	code, second := refresh(t, handler, first.RefreshToken)
+
+	if code != http.StatusOK || second.Token == "" || second.RefreshToken == "" {
+		t.Fatalf("Got %d, want new tokens", code)
+	}
+
+	if second.RefreshToken == first.RefreshToken {
+		t.Error("Refresh handed back the same refresh token")
+	}
+
+	if code, _ := refresh(t, handler, second.RefreshToken); code != http.StatusOK {
+		t.Errorf("Refreshing with the new token: got %d, want 200", code)
+	}
+}
+
+// Presenting a used token again revokes every token of its family, including
+// the one the legitimate client holds now
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	handler, store := newTokenTestServer(t)
+
+	first := login(t, handler)
+	other := login(t, handler)
+
+	_, second := refresh(t, handler, first.RefreshToken)
+
+	if code, _ := refresh(t, handler, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Fatalf("Reused token: got %d, want 401", code)
+	}
+
+	if code, _ := refresh(t, handler, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Latest token of the family: got %d, want 401", code)
+	}
+
+	if code, _ := refresh(t, handler, other.RefreshToken); code != http.StatusOK {
+		t.Errorf("Another session: got %d, want 200", code)
+	}
+
+	for _, token := range store.refreshTokens {
+		if token.FamilyID == store.refreshTokens[0].FamilyID && token.RevokedAt == nil {
+			t.Errorf("Token %d of the reused family wasn't revoked", token.ID)
+		}
+	}
+}
+
+func TestRefreshAfterLogout(t *testing.T) {
+	handler, _ := newTokenTestServer(t)
+
+	first := login(t, handler)
+	_, second := refresh(t, handler, first.RefreshToken)
+
+	rec := doJSON(t, handler, "POST", "/auth/logout", domain.RefreshTokenRequest{RefreshToken: second.RefreshToken}, nil, nil)
+
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Logout: got %d, want 204", rec.Code)
+	}
+
+	if code, _ := refresh(t, handler, second.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Refresh after logout: got %d, want 401", code)
+	}
+
+	// Logging out twice, or with a token we never issued, is a no-op
+	for _, token := range []string{second.RefreshToken, "unknown"} {
+		if rec := doJSON(t, handler, "POST", "/auth/logout", domain.RefreshTokenRequest{RefreshToken: token}, nil, nil); rec.Code != http.StatusNoContent {
+			t.Errorf("Logout with %q: got %d, want 204", token, rec.Code)
+		}
+	}
+}
+
+func TestRefreshInvalidTokens(t *testing.T) {
+	handler, store := newTokenTestServer(t)
+
+	first := login(t, handler)
+
+	store.mu.Lock()
+	store.refreshTokens[0].ExpiresAt = time.Now().Add(-time.Minute)
+	store.mu.Unlock()
+
+	if code, _ := refresh(t, handler, first.RefreshToken); code != http.StatusUnauthorized {
+		t.Errorf("Expired token: got %d, want 401", code)
+	}
+
+	if code, _ := refresh(t, handler, "unknown"); code != http.StatusUnauthorized {
+		t.Errorf("Unknown token: got %d, want 401", code)
+	}
+}
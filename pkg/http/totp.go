@@ -0,0 +1,378 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+	"github.com/grez-lucas/go-gym/pkg/storage"
+	"github.com/grez-lucas/go-gym/pkg/totp"
+)
+
+const (
+	totpIssuer            = "Go Gym"
+	mfaChallengeAudience  = "go-gym-mfa"
+	mfaChallengeTTL       = 5 * time.Minute
+	mfaMaxFailures        = 5
+	recoveryCodeCount     = 10
+	recoveryCodeByteCount = 5 // 8 base32 characters each
+)
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type DisableTOTPRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code"`
+}
+
+// Second step of a login for accounts with 2FA, either field works
+type MFALoginRequest struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recoveryCode"`
+}
+
+type MFAChallengeResponse struct {
+	MFARequired    bool   `json:"mfaRequired"`
+	ChallengeToken string `json:"challengeToken"`
+	ExpiresIn      int    `json:"expiresIn"`
+}
+
+type TOTPEnrollmentResponse struct {
+	Secret string `json:"secret"`
+	// Show it as a QR code for authenticator apps
+	URI string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	// Only ever shown here, each works once in place of a TOTP code
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// Ends a successful first factor. Accounts with 2FA get a short lived
+// challenge token to trade for real tokens at `POST /auth/login/2fa`, the
+// rest are logged in right away.
+func (s *APIServer) completeLogin(w http.ResponseWriter, req *http.Request, acc *domain.Account) error {
+	if !acc.IsTOTPEnabled() {
+		resp, err := s.issueTokens(req.Context(), acc)
+
+		if err != nil {
+			return err
+		}
+
+		return WriteJSON(w, http.StatusOK, resp)
+	}
+
+	now := time.Now().UTC()
+
+	challenge, err := s.keys.Sign(&jwt.RegisteredClaims{
+		Issuer:    s.config.JWTIssuer,
+		Subject:   strconv.Itoa(acc.ID),
+		Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, MFAChallengeResponse{
+		MFARequired:    true,
+		ChallengeToken: challenge,
+		ExpiresIn:      int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+func (s *APIServer) handleMFALogin(w http.ResponseWriter, req *http.Request) error {
+	var mfaRequest MFALoginRequest
+
+	if err := decodeJSON(w, req, &mfaRequest); err != nil {
+		return err
+	}
+
+	claims := &jwt.RegisteredClaims{}
+
+	_, err := jwt.ParseWithClaims(mfaRequest.ChallengeToken, claims, s.keys.Keyfunc,
+		jwt.WithValidMethods(s.keys.ValidMethods()),
+		jwt.WithIssuer(s.config.JWTIssuer),
+		jwt.WithAudience(mfaChallengeAudience),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("second_factor").Inc()
+		return unauthorized("Invalid or expired challenge, log in again")
+	}
+
+	accountID, err := strconv.Atoi(claims.Subject)
+
+	if err != nil {
+		metrics.AuthFailures.WithLabelValues("second_factor").Inc()
+		return unauthorized("Invalid or expired challenge, log in again")
+	}
+
+	// Six digits are quick to guess, so failures lock the second step too
+	mfaKey := fmt.Sprintf("mfa:%d", accountID)
+
+	attempts, err := s.store.GetLoginAttempts(req.Context(), mfaKey)
+
+	if err != nil {
+		return err
+	}
+
+	if attempts.IsLocked() {
+		return writeLoginLocked(w, req, *attempts.LockedUntil)
+	}
+
+	acc, err := s.store.GetAccountByID(req.Context(), accountID)
+
+	if err != nil {
+		return err
+	}
+
+	ok, err := s.verifySecondFactor(req.Context(), acc, mfaRequest.Code, mfaRequest.RecoveryCode)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		if err := s.recordLoginFailure(req.Context(), mfaKey, mfaMaxFailures); err != nil {
+			return err
+		}
+
+		metrics.AuthFailures.WithLabelValues("second_factor").Inc()
+		return unauthorized("Invalid code")
+	}
+
+	if err := s.store.ResetLoginAttempts(req.Context(), mfaKey); err != nil {
+		return err
+	}
+
+	resp, err := s.issueTokens(req.Context(), acc)
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, resp)
+}
+
+// Checks a TOTP code, or a recovery code when no TOTP code is given
+func (s *APIServer) verifySecondFactor(ctx context.Context, acc *domain.Account, code string, recoveryCode string) (bool, error) {
+	if !acc.IsTOTPEnabled() {
+		return false, nil
+	}
+
+	if code == "" {
+		if recoveryCode == "" {
+			return false, nil
+		}
+
+		return s.store.UseRecoveryCode(ctx, acc.ID, hashToken(normalizeRecoveryCode(recoveryCode)))
+	}
+
+	return s.verifyTOTPCode(ctx, acc, code)
+}
+
+// Validates the code and burns its time step so it can't be replayed
+func (s *APIServer) verifyTOTPCode(ctx context.Context, acc *domain.Account, code string) (bool, error) {
+	counter, ok := totp.Validate(acc.TOTPSecret, code, time.Now())
+
+	if !ok {
+		return false, nil
+	}
+
+	return s.store.UseTOTPCounter(ctx, acc.ID, counter)
+}
+
+// Starts enrollment with a fresh secret, 2FA is only enabled once a code
+// from it is confirmed
+func (s *APIServer) handleEnrollTOTP(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	if acc.IsTOTPEnabled() {
+		return conflict("Two-factor authentication is already enabled")
+	}
+
+	secret, err := totp.GenerateSecret()
+
+	if err != nil {
+		return err
+	}
+
+	if err := s.store.SetTOTPSecret(req.Context(), acc.ID, secret); err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, TOTPEnrollmentResponse{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, acc.UserName, secret),
+	})
+}
+
+func (s *APIServer) handleConfirmTOTP(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	var codeRequest TOTPCodeRequest
+
+	if err := decodeJSON(w, req, &codeRequest); err != nil {
+		return err
+	}
+
+	if acc.TOTPSecret == "" || acc.IsTOTPEnabled() {
+		return conflict("No two-factor enrollment in progress")
+	}
+
+	ok, err := s.verifyTOTPCode(req.Context(), acc, codeRequest.Code)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return badRequest("Invalid code")
+	}
+
+	if err := s.store.EnableTOTP(req.Context(), acc.ID); err != nil {
+		return err
+	}
+
+	codes, err := s.replaceRecoveryCodes(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "Two-factor authentication enabled")
+
+	return WriteJSON(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (s *APIServer) handleDisableTOTP(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	var disableRequest DisableTOTPRequest
+
+	if err := decodeJSON(w, req, &disableRequest); err != nil {
+		return err
+	}
+
+	if !storage.VerifyHashedPassword(disableRequest.Password, acc.Password) {
+		return forbidden("Invalid Password")
+	}
+
+	if acc.IsTOTPEnabled() {
+		ok, err := s.verifyTOTPCode(req.Context(), acc, disableRequest.Code)
+
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return forbidden("Invalid code")
+		}
+	}
+
+	if err := s.store.DisableTOTP(req.Context(), acc.ID); err != nil {
+		return err
+	}
+
+	slog.InfoContext(req.Context(), "Two-factor authentication disabled")
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Replaces the recovery codes, e.g. after using up most of them
+func (s *APIServer) handleRegenerateRecoveryCodes(w http.ResponseWriter, req *http.Request) error {
+	acc, err := s.accountFromContext(req)
+
+	if err != nil {
+		return err
+	}
+
+	var codeRequest TOTPCodeRequest
+
+	if err := decodeJSON(w, req, &codeRequest); err != nil {
+		return err
+	}
+
+	ok, err := s.verifyTOTPCode(req.Context(), acc, codeRequest.Code)
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return forbidden("Invalid code")
+	}
+
+	codes, err := s.replaceRecoveryCodes(req.Context(), acc.ID)
+
+	if err != nil {
+		return err
+	}
+
+	return WriteJSON(w, http.StatusOK, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (s *APIServer) replaceRecoveryCodes(ctx context.Context, accountID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		secret, err := totp.GenerateSecret()
+
+		if err != nil {
+			return nil, err
+		}
+
+		// Shown as `abcd-efgh`
+		code := strings.ToLower(secret[:recoveryCodeByteCount*8/5])
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+
+	if err := s.store.ReplaceRecoveryCodes(ctx, accountID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+func (s *APIServer) accountFromContext(req *http.Request) (*domain.Account, error) {
+	accountID, ok := AccountIDFromContext(req.Context())
+
+	if !ok {
+		return nil, fmt.Errorf("Unable to retrieve ID from context")
+	}
+
+	return s.store.GetAccountByID(req.Context(), int(accountID))
+}
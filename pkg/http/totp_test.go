@@ -0,0 +1,226 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+	"github.com/grez-lucas/go-gym/pkg/totp"
+)
+
+// A server backed by a memoryStore, with real signing keys
+func newAuthTestServer(t *testing.T, cfg *config.Config) (*APIServer, *memoryStore) {
+	t.Helper()
+
+	cfg.JWTIssuer = "go-gym-test"
+	cfg.AccessTokenTTL = 15 * time.Minute
+	cfg.RefreshTokenTTL = time.Hour
+
+	manager, err := keys.NewManager(keys.Options{Algorithm: keys.EdDSA, GracePeriod: time.Hour})
+
+	if err != nil {
+		t.Fatalf("Error creating key manager: %v", err)
+	}
+
+	store := newMemoryStore()
+
+	s, err := NewAPIServer(":0", store, cfg, manager, nil, nil, nil)
+
+	if err != nil {
+		t.Fatalf("Error creating server: %v", err)
+	}
+
+	return s, store
+}
+
+// Sends `body` as JSON and decodes the response into `into` when it's set
+func doJSON(t *testing.T, handler http.Handler, method string, path string, body any, header http.Header, into any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var payload bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatalf("Error encoding request: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if into != nil && rec.Code < 300 {
+		if err := json.Unmarshal(rec.Body.Bytes(), into); err != nil {
+			t.Fatalf("Error decoding %s %s response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+
+	return rec
+}
+
+func jwtHeader(token string) http.Header {
+	return http.Header{"X-Jwt-Token": {token}}
+}
+
+// Logs in with the password and returns the challenge for the second step
+func startMFALogin(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	var challenge MFAChallengeResponse
+
+	rec := doJSON(t, handler, "POST", "/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}, nil, &challenge)
+
+	if rec.Code != http.StatusOK || !challenge.MFARequired || challenge.ChallengeToken == "" {
+		t.Fatalf("Password step: got %d %s, want an MFA challenge", rec.Code, rec.Body.String())
+	}
+
+	return challenge.ChallengeToken
+}
+
+func TestTwoStepLogin(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	secret, _ := totp.GenerateSecret()
+	enabledAt := time.Now().UTC()
+
+	acc := domain.NewAccount("alice", "correct horse")
+	acc.TOTPSecret = secret
+	acc.TOTPEnabledAt = &enabledAt
+	acc = store.addAccount(acc)
+
+	recoveryCodes, err := s.replaceRecoveryCodes(context.Background(), acc.ID)
+
+	if err != nil {
+		t.Fatalf("Error creating recovery codes: %v", err)
+	}
+
+	challenge := startMFALogin(t, handler)
+
+	// The challenge is signed with the same keys as access tokens, it must
+	// not pass for one
+	if rec := doJSON(t, handler, "GET", "/accounts/me", nil, jwtHeader(challenge), nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Challenge used as an access token: got %d, want 401", rec.Code)
+	}
+
+	code, _ := totp.Code(secret, totp.Counter(time.Now()))
+	stale, _ := totp.Code(secret, totp.Counter(time.Now())-10)
+
+	if rec := doJSON(t, handler, "POST", "/auth/login/2fa", MFALoginRequest{ChallengeToken: challenge, Code: stale}, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Stale code: got %d, want 401", rec.Code)
+	}
+
+	var login LoginResponse
+
+	rec := doJSON(t, handler, "POST", "/auth/login/2fa", MFALoginRequest{ChallengeToken: challenge, Code: code}, nil, &login)
+
+	if rec.Code != http.StatusOK || login.Token == "" || login.RefreshToken == "" {
+		t.Fatalf("Valid code: got %d %s, want tokens", rec.Code, rec.Body.String())
+	}
+
+	if rec := doJSON(t, handler, "GET", "/accounts/me", nil, jwtHeader(login.Token), nil); rec.Code != http.StatusOK {
+		t.Errorf("Access token from the second step: got %d, want 200", rec.Code)
+	}
+
+	// The time step of a used code is burnt, even within the same login
+	if rec := doJSON(t, handler, "POST", "/auth/login/2fa", MFALoginRequest{ChallengeToken: startMFALogin(t, handler), Code: code}, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Replayed code: got %d, want 401", rec.Code)
+	}
+
+	// Recovery codes work once, however they're typed
+	recovery := MFALoginRequest{ChallengeToken: startMFALogin(t, handler), RecoveryCode: recoveryCodes[0]}
+
+	if rec := doJSON(t, handler, "POST", "/auth/login/2fa", recovery, nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("Recovery code: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+
+	recovery.ChallengeToken = startMFALogin(t, handler)
+
+	if rec := doJSON(t, handler, "POST", "/auth/login/2fa", recovery, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Reused recovery code: got %d, want 401", rec.Code)
+	}
+
+	other := MFALoginRequest{ChallengeToken: startMFALogin(t, handler), RecoveryCode: " " + recoveryCodes[1][:4] + recoveryCodes[1][5:] + " "}
+
+	if rec := doJSON(t, handler, "POST", "/auth/login/2fa", other, nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("Recovery code without its dash: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+}
+
+// Without 2FA the password step logs in right away
+func TestLoginWithout2FA(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	store.addAccount(domain.NewAccount("alice", "correct horse"))
+
+	var login LoginResponse
+
+	rec := doJSON(t, handler, "POST", "/auth/login", LoginRequest{Username: "alice", Password: "correct horse"}, nil, &login)
+
+	if rec.Code != http.StatusOK || login.Token == "" {
+		t.Fatalf("Got %d %s, want tokens", rec.Code, rec.Body.String())
+	}
+
+	// Nor can an access token stand in for a challenge
+	if rec := doJSON(t, handler, "POST", "/auth/login/2fa", MFALoginRequest{ChallengeToken: login.Token, Code: "000000"}, nil, nil); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Access token used as a challenge: got %d, want 401", rec.Code)
+	}
+}
+
+// A code whose time step was already used can't confirm an enrollment either
+func TestConfirmTOTPReplayedCode(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	acc := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	token, _ := s.CreateJWT(acc)
+
+	var enrollment TOTPEnrollmentResponse
+
+	if rec := doJSON(t, handler, "POST", "/accounts/me/2fa/totp", nil, jwtHeader(token), &enrollment); rec.Code != http.StatusOK {
+		t.Fatalf("Enroll: got %d %s, want 200", rec.Code, rec.Body.String())
+	}
+
+	counter := totp.Counter(time.Now())
+	code, _ := totp.Code(enrollment.Secret, counter)
+
+	if _, err := store.UseTOTPCounter(context.Background(), acc.ID, counter); err != nil {
+		t.Fatalf("Error using counter: %v", err)
+	}
+
+	if rec := doJSON(t, handler, "POST", "/accounts/me/2fa/totp/confirm", TOTPCodeRequest{Code: code}, jwtHeader(token), nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Replayed code: got %d, want 400", rec.Code)
+	}
+
+	if acc, _ := store.GetAccountByID(context.Background(), acc.ID); acc.IsTOTPEnabled() {
+		t.Error("Replayed code enabled two-factor authentication")
+	}
+}
+
+// A token outliving its account is a missing account, not a failed login
+func TestEnrollTOTPDeletedAccount(t *testing.T) {
+	s, store := newAuthTestServer(t, &config.Config{})
+	handler := s.router()
+
+	acc := store.addAccount(domain.NewAccount("alice", "correct horse"))
+	token, _ := s.CreateJWT(acc)
+	store.deleteAccount(acc.ID)
+
+	if rec := doJSON(t, handler, "POST", "/accounts/me/2fa/totp", nil, jwtHeader(token), nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Deleted account: got %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,57 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/grez-lucas/go-gym/pkg/logging"
+)
+
+var tracer = otel.Tracer("github.com/grez-lucas/go-gym/pkg/http")
+
+// Starts a server span for every request, continuing the trace of the caller
+// when it sent a W3C `traceparent` header. The span is named after the route
+// and the trace ID is added to the request's logs.
+func withTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("%s %s", req.Method, routeLabel(req)),
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", req.Method),
+				attribute.String("url.path", req.URL.Path),
+				attribute.String("http.route", routeLabel(req)),
+				attribute.String("client.address", req.RemoteAddr),
+				attribute.String("user_agent.original", req.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		if spanContext := span.SpanContext(); spanContext.IsValid() {
+			logging.AddAttrs(ctx, slog.String("trace_id", spanContext.TraceID().String()))
+		}
+
+		req = req.WithContext(ctx)
+		recorder := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, req)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+
+		span.SetAttributes(attribute.Int("http.response.status_code", recorder.status))
+
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
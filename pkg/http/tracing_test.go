@@ -0,0 +1,112 @@
+package http
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/grez-lucas/go-gym/pkg/storage"
+)
+
+var (
+	spanRecorder      *tracetest.SpanRecorder
+	setupSpanRecorder sync.Once
+)
+
+// Records every span of the package's tests. The tracers were made before
+// any test ran and only follow the first provider set, so it's set once and
+// shared, tests tell their spans apart by trace ID.
+func recordSpans() *tracetest.SpanRecorder {
+	setupSpanRecorder.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+
+	return spanRecorder
+}
+
+func spansOfTrace(recorder *tracetest.SpanRecorder, traceID trace.TraceID) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+func TestTracing(t *testing.T) {
+	recorder := recordSpans()
+
+	s, store := newGymsTestServer(t)
+	s.store = storage.NewInstrumentedStore(store)
+	handler := s.middleware(s.router())
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	parentID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	rec := get(handler, "/gyms/1", http.Header{"Traceparent": {"00-" + traceID.String() + "-" + parentID.String() + "-01"}})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Got %d, want 200", rec.Code)
+	}
+
+	spans := spansOfTrace(recorder, traceID)
+
+	var server sdktrace.ReadOnlySpan
+
+	for _, span := range spans {
+		if span.SpanKind() == trace.SpanKindServer {
+			server = span
+		}
+	}
+
+	if server == nil {
+		t.Fatalf("Got %d spans in the caller's trace, none of them a server span", len(spans))
+	}
+
+	if server.Name() != "GET /gyms/{id}" {
+		t.Errorf("Got server span %q, want it named after the route", server.Name())
+	}
+
+	if server.Parent().SpanID() != parentID || !server.Parent().IsRemote() {
+		t.Errorf("Got parent %v, want the caller's span %v", server.Parent().SpanID(), parentID)
+	}
+
+	var storageSpans []string
+
+	for _, span := range spans {
+		if span.Parent().SpanID() == server.SpanContext().SpanID() {
+			storageSpans = append(storageSpans, span.Name())
+		}
+	}
+
+	if len(storageSpans) != 1 || storageSpans[0] != "storage.GetGymByID" {
+		t.Errorf("Got child spans %v, want [storage.GetGymByID]", storageSpans)
+	}
+
+	// Without a traceparent a new trace is started
+	before := len(recorder.Ended())
+
+	get(handler, "/no/such/route", nil)
+
+	ended := recorder.Ended()
+
+	if len(ended) != before+1 {
+		t.Fatalf("Got %d new spans, want 1", len(ended)-before)
+	}
+
+	if span := ended[before]; span.Name() != "GET unmatched" || span.Parent().IsValid() || span.SpanContext().TraceID() == traceID {
+		t.Errorf("Got %q in trace %v with parent %v, want a new root span", span.Name(), span.SpanContext().TraceID(), span.Parent().SpanID())
+	}
+}
@@ -0,0 +1,73 @@
+package keys
+
+import "testing"
+
+// Examples from RFC 7638 section 3.1 and RFC 8037 appendix A.3
+func TestThumbprint(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+		want string
+	}{
+		{
+			name: "RSA",
+			jwk: JWK{
+				KeyType: "RSA",
+				N:       "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+				E:       "AQAB",
+			},
+			want: "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs",
+		},
+		{
+			name: "Ed25519",
+			jwk: JWK{
+				KeyType: "OKP",
+				Curve:   "Ed25519",
+				X:       "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo",
+			},
+			want: "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			public, err := tt.jwk.PublicKey()
+
+			if err != nil {
+				t.Fatalf("Error decoding JWK: %v", err)
+			}
+
+			got, err := Thumbprint(public)
+
+			if err != nil {
+				t.Fatalf("Error computing thumbprint: %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("Got thumbprint %s, want %s", got, tt.want)
+			}
+
+			// The JWK has to survive the round trip unchanged
+			if jwk, _ := NewJWK(public); jwk != tt.jwk {
+				t.Errorf("Got JWK %+v, want %+v", jwk, tt.jwk)
+			}
+		})
+	}
+}
+
+// Generated keys are identified by their thumbprint
+func TestGeneratedKeyID(t *testing.T) {
+	for _, alg := range []Algorithm{RS256, EdDSA} {
+		key, err := GenerateKey(alg)
+
+		if err != nil {
+			t.Fatalf("Error generating %s key: %v", alg, err)
+		}
+
+		thumbprint, _ := Thumbprint(key.Public())
+
+		if key.ID != thumbprint {
+			t.Errorf("%s key has kid %s, want its thumbprint %s", alg, key.ID, thumbprint)
+		}
+	}
+}
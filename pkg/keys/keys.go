@@ -0,0 +1,145 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signing algorithms we know how to produce keys for
+type Algorithm string
+
+const (
+	RS256 Algorithm = "RS256"
+	EdDSA Algorithm = "EdDSA"
+	// Shared secret signing, kept for deployments where nobody else needs to
+	// verify our tokens. HS256 keys are never published in the JWKS.
+	HS256 Algorithm = "HS256"
+)
+
+func ParseAlgorithm(alg string) (Algorithm, error) {
+	switch Algorithm(alg) {
+	case RS256, EdDSA, HS256:
+		return Algorithm(alg), nil
+	}
+
+	return "", fmt.Errorf("Unsupported signing algorithm `%s`", alg)
+}
+
+func (a Algorithm) SigningMethod() jwt.SigningMethod {
+	switch a {
+	case RS256:
+		return jwt.SigningMethodRS256
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// A Key is one signing key with its `kid`. Keys are published from the
+// start but only sign from ActiveAt on, and stay usable for verification
+// until RetiresAt, which is set once a newer key is added.
+type Key struct {
+	ID        string
+	Algorithm Algorithm
+	CreatedAt time.Time
+	ActiveAt  time.Time
+	RetiresAt time.Time
+
+	private any // *rsa.PrivateKey, ed25519.PrivateKey or []byte for HS256
+}
+
+func GenerateKey(alg Algorithm) (*Key, error) {
+	var private any
+
+	switch alg {
+	case RS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		private = rsaKey
+	case EdDSA:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		private = edKey
+	default:
+		return nil, fmt.Errorf("Can't generate keys for `%s`", alg)
+	}
+
+	return newKey(alg, private, time.Now().UTC())
+}
+
+// Wraps a shared HMAC secret. Its kid is derived from the secret so every
+// replica configured with the same secret agrees on it.
+func NewSecretKey(secret []byte) *Key {
+	sum := sha256.Sum256(secret)
+
+	return &Key{
+		ID:        "hs-" + hex.EncodeToString(sum[:6]),
+		Algorithm: HS256,
+		CreatedAt: time.Now().UTC(),
+		private:   secret,
+	}
+}
+
+func newKey(alg Algorithm, private any, createdAt time.Time) (*Key, error) {
+	key := &Key{
+		Algorithm: alg,
+		CreatedAt: createdAt,
+		private:   private,
+	}
+
+	thumbprint, err := Thumbprint(key.Public())
+
+	if err != nil {
+		return nil, err
+	}
+
+	key.ID = thumbprint
+
+	return key, nil
+}
+
+// The key used to sign tokens
+func (k *Key) SigningKey() any {
+	return k.private
+}
+
+// The key used to verify tokens
+func (k *Key) VerificationKey() any {
+	if k.Algorithm == HS256 {
+		return k.private
+	}
+
+	return k.Public()
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	switch private := k.private.(type) {
+	case *rsa.PrivateKey:
+		return &private.PublicKey
+	case ed25519.PrivateKey:
+		return private.Public()
+	}
+
+	return nil
+}
+
+func (k *Key) isActive(now time.Time) bool {
+	return !now.Before(k.ActiveAt)
+}
+
+func (k *Key) isRetired(now time.Time) bool {
+	return !k.RetiresAt.IsZero() && now.After(k.RetiresAt)
+}
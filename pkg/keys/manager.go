@@ -0,0 +1,439 @@
+package keys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// How often Run reloads the keys directory and checks for rotation
+	reloadInterval = time.Minute
+	// How long clients may cache the JWKS
+	JWKSMaxAge = 5 * time.Minute
+	// New keys sign once every replica reloaded them and every cached JWKS
+	// expired, with a reload to spare
+	DefaultPublishDelay = 2*reloadInterval + JWKSMaxAge
+)
+
+type Options struct {
+	Algorithm Algorithm
+	// Only used with HS256
+	Secret []byte
+	// Directory holding PKCS #8 PEM private keys named `<kid>.pem`. Replicas
+	// sharing it share their keys. Keys are kept in memory only when empty,
+	// so every restart invalidates outstanding tokens.
+	Dir string
+	// How often a new signing key is generated, zero disables rotation
+	RotationInterval time.Duration
+	// How long a replaced key keeps verifying tokens. It should be longer
+	// than the lifetime of the tokens it signed.
+	GracePeriod time.Duration
+	// How long a new key is only published before it starts signing, so
+	// other replicas and JWKS caches know it by the time its tokens show up.
+	// Zero means DefaultPublishDelay.
+	PublishDelay time.Duration
+}
+
+// The Manager owns our signing keys. The newest key past its publish delay
+// signs, older keys keep verifying until their grace period runs out.
+type Manager struct {
+	options Options
+
+	mu      sync.RWMutex
+	keys    map[string]*Key
+	current *Key
+}
+
+func NewManager(options Options) (*Manager, error) {
+	if options.PublishDelay == 0 {
+		options.PublishDelay = DefaultPublishDelay
+	}
+
+	m := &Manager{
+		options: options,
+		keys:    map[string]*Key{},
+	}
+
+	if options.Algorithm == HS256 {
+		if len(options.Secret) == 0 {
+			return nil, fmt.Errorf("HS256 signing requires a secret")
+		}
+		m.add(NewSecretKey(options.Secret))
+		return m, nil
+	}
+
+	if options.Dir != "" {
+		if err := os.MkdirAll(options.Dir, 0o700); err != nil {
+			return nil, err
+		}
+		if err := m.load(); err != nil {
+			return nil, err
+		}
+	} else {
+		slog.Warn("JWT_KEYS_DIR is not set, signing keys will only live in memory")
+	}
+
+	// The newest key, not the signer: a key still being published counts
+	if newest := m.newest(); newest == nil || newest.Algorithm != options.Algorithm {
+		if err := m.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Signs the claims with the current key and sets its `kid` header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	m.mu.RLock()
+	key := m.current
+	m.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.Algorithm.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.SigningKey())
+}
+
+// A jwt.Keyfunc resolving the verification key from the `kid` header
+func (m *Manager) Keyfunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	// RetiresAt is set by add() under the write lock, so it's read under ours
+	m.mu.RLock()
+	key, ok := m.keys[kid]
+	retired := ok && key.isRetired(time.Now().UTC())
+	m.mu.RUnlock()
+
+	if !ok || retired {
+		return nil, fmt.Errorf("Unknown signing key `%s`", kid)
+	}
+
+	if t.Method.Alg() != string(key.Algorithm) {
+		return nil, fmt.Errorf("Unexpected signing method: `%v`", t.Header["alg"])
+	}
+
+	return key.VerificationKey(), nil
+}
+
+// Algorithms accepted by Keyfunc, to pass to jwt.WithValidMethods
+func (m *Manager) ValidMethods() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := map[string]bool{}
+	methods := []string{}
+
+	for _, key := range m.keys {
+		if !seen[string(key.Algorithm)] {
+			seen[string(key.Algorithm)] = true
+			methods = append(methods, string(key.Algorithm))
+		}
+	}
+
+	return methods
+}
+
+// The public keys of every key that can still verify tokens
+func (m *Manager) JWKS() JWKSet {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now().UTC()
+
+	for _, key := range m.sortedKeys() {
+		if key.Algorithm == HS256 || key.isRetired(now) {
+			continue
+		}
+
+		jwk, err := NewJWK(key.Public())
+
+		if err != nil {
+			slog.Warn("Skipping key in JWKS", "kid", key.ID, "error", err)
+			continue
+		}
+
+		jwk.KeyID = key.ID
+		jwk.Use = "sig"
+		jwk.Algorithm = string(key.Algorithm)
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set
+}
+
+// Generates a new signing key. It's published right away and takes over
+// signing after the publish delay, the previous one keeps verifying for the
+// grace period after that.
+func (m *Manager) Rotate() error {
+	if m.options.Algorithm == HS256 {
+		return nil
+	}
+
+	key, err := GenerateKey(m.options.Algorithm)
+
+	if err != nil {
+		return err
+	}
+
+	if m.options.Dir != "" {
+		if err := m.save(key); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.add(key)
+	slog.Info("Rotated JWT signing key", "kid", key.ID, "active_at", key.ActiveAt)
+
+	return nil
+}
+
+// Rotates the signing key on schedule and drops retired keys until the
+// context is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	if m.options.Algorithm == HS256 || m.options.RotationInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.tick()
+		}
+	}
+}
+
+func (m *Manager) tick() {
+	// Pick up keys written by other replicas
+	if m.options.Dir != "" {
+		m.mu.Lock()
+		err := m.load()
+		m.mu.Unlock()
+
+		if err != nil {
+			slog.Error("Error reloading JWT keys", "error", err)
+		}
+	}
+
+	m.mu.Lock()
+	m.promote(time.Now().UTC())
+	due := time.Since(m.newest().CreatedAt) >= m.options.RotationInterval
+	m.mu.Unlock()
+
+	if due {
+		if err := m.Rotate(); err != nil {
+			slog.Error("Error rotating JWT signing key", "error", err)
+		}
+	}
+
+	m.prune()
+}
+
+func (m *Manager) prune() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+
+	for kid, key := range m.keys {
+		if !key.isRetired(now) {
+			continue
+		}
+
+		delete(m.keys, kid)
+		slog.Info("Dropped retired JWT signing key", "kid", kid)
+
+		if m.options.Dir != "" {
+			if err := os.Remove(m.keyPath(kid)); err != nil && !os.IsNotExist(err) {
+				slog.Error("Error removing retired key file", "kid", kid, "error", err)
+			}
+		}
+	}
+}
+
+// Adds a key, which signs once its publish delay is over. Everything older
+// retires a grace period after its successor starts signing. Callers hold
+// the lock.
+func (m *Manager) add(key *Key) {
+	// Derived from the creation time, so replicas sharing a keys directory
+	// switch keys at the same time
+	key.ActiveAt = key.CreatedAt.Add(m.options.PublishDelay)
+	m.keys[key.ID] = key
+
+	sorted := m.sortedKeys()
+
+	for i := 0; i < len(sorted)-1; i++ {
+		if sorted[i].RetiresAt.IsZero() {
+			sorted[i].RetiresAt = sorted[i+1].ActiveAt.Add(m.options.GracePeriod)
+		}
+	}
+
+	m.promote(time.Now().UTC())
+}
+
+// Makes the newest active key the signer. Before any key is active, e.g. on
+// a fresh start, the oldest one signs since nobody has seen the others yet
+// either. Callers hold the lock.
+func (m *Manager) promote(now time.Time) {
+	sorted := m.sortedKeys()
+	current := sorted[0]
+
+	for _, key := range sorted {
+		if key.isActive(now) {
+			current = key
+		}
+	}
+
+	if m.current != nil && m.current != current {
+		slog.Info("Switched JWT signing key", "kid", current.ID)
+	}
+
+	m.current = current
+}
+
+// The most recently created key, nil when there's none. Callers hold the
+// lock.
+func (m *Manager) newest() *Key {
+	sorted := m.sortedKeys()
+
+	if len(sorted) == 0 {
+		return nil
+	}
+
+	return sorted[len(sorted)-1]
+}
+
+// Oldest first
+func (m *Manager) sortedKeys() []*Key {
+	sorted := make([]*Key, 0, len(m.keys))
+
+	for _, key := range m.keys {
+		sorted = append(sorted, key)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	return sorted
+}
+
+func (m *Manager) keyPath(kid string) string {
+	return filepath.Join(m.options.Dir, kid+".pem")
+}
+
+// Reads every key in the keys directory, the file modification time is the
+// creation time of the key. Callers hold the lock (or own the Manager).
+func (m *Manager) load() error {
+	paths, err := filepath.Glob(filepath.Join(m.options.Dir, "*.pem"))
+
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+
+		if _, ok := m.keys[kid]; ok {
+			continue
+		}
+
+		key, err := readKeyFile(path)
+
+		if err != nil {
+			return fmt.Errorf("Error reading key %s: %w", path, err)
+		}
+
+		key.ID = kid
+		m.add(key)
+	}
+
+	return nil
+}
+
+func (m *Manager) save(key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.SigningKey())
+
+	if err != nil {
+		return err
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	// Write then rename so other replicas never read a partial key
+	tmp := m.keyPath(key.ID) + ".tmp"
+
+	if err := os.WriteFile(tmp, encoded, 0o600); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, m.keyPath(key.ID)); err != nil {
+		return err
+	}
+
+	return os.Chtimes(m.keyPath(key.ID), key.CreatedAt, key.CreatedAt)
+}
+
+func readKeyFile(path string) (*Key, error) {
+	contents, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(contents)
+
+	if block == nil {
+		return nil, fmt.Errorf("No PEM data found")
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var alg Algorithm
+
+	switch private.(type) {
+	case *rsa.PrivateKey:
+		alg = RS256
+	case ed25519.PrivateKey:
+		alg = EdDSA
+	default:
+		return nil, fmt.Errorf("Unsupported private key type %T", private)
+	}
+
+	return newKey(alg, private, info.ModTime().UTC())
+}
@@ -0,0 +1,368 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestManager(t *testing.T, options Options) *Manager {
+	t.Helper()
+
+	if options.GracePeriod == 0 {
+		options.GracePeriod = time.Hour
+	}
+
+	m, err := NewManager(options)
+
+	if err != nil {
+		t.Fatalf("Error creating key manager: %v", err)
+	}
+
+	return m
+}
+
+func sign(t *testing.T, m *Manager) string {
+	t.Helper()
+
+	token, err := m.Sign(jwt.RegisteredClaims{Subject: "1"})
+
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+
+	return token
+}
+
+func verify(m *Manager, token string) error {
+	_, err := jwt.Parse(token, m.Keyfunc, jwt.WithValidMethods(m.ValidMethods()))
+	return err
+}
+
+// Skips the publish delay of every key, as if it had passed
+func publishAll(m *Manager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.keys {
+		key.ActiveAt = key.CreatedAt
+	}
+
+	m.promote(time.Now().UTC())
+}
+
+func kidOf(t *testing.T, token string) string {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+
+	if err != nil {
+		t.Fatalf("Error parsing token: %v", err)
+	}
+
+	kid, _ := parsed.Header["kid"].(string)
+
+	return kid
+}
+
+// A new key is published first and signs after the publish delay, the one it
+// replaced keeps verifying
+func TestRotate(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: EdDSA})
+
+	before := sign(t, m)
+
+	if err := m.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+
+	if got := len(m.JWKS().Keys); got != 2 {
+		t.Errorf("Got %d keys in the JWKS, want 2", got)
+	}
+
+	if kid := kidOf(t, sign(t, m)); kid != kidOf(t, before) {
+		t.Errorf("Signed with %s during the publish delay, want the old key %s", kid, kidOf(t, before))
+	}
+
+	publishAll(m)
+
+	after := sign(t, m)
+
+	if kidOf(t, before) == kidOf(t, after) {
+		t.Fatalf("Rotation kept signing with %s", kidOf(t, before))
+	}
+
+	if kidOf(t, after) != m.current.ID {
+		t.Errorf("Signed with %s, want the current key %s", kidOf(t, after), m.current.ID)
+	}
+
+	for name, token := range map[string]string{"old": before, "new": after} {
+		if err := verify(m, token); err != nil {
+			t.Errorf("Token from the %s key was rejected: %v", name, err)
+		}
+	}
+
+	if got := len(m.JWKS().Keys); got != 2 {
+		t.Errorf("Got %d keys in the JWKS, want 2", got)
+	}
+}
+
+// Once its grace period is over a key stops verifying, leaves the JWKS and
+// gets pruned
+func TestGracePeriod(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: EdDSA})
+
+	token := sign(t, m)
+	kid := kidOf(t, token)
+
+	if err := m.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+
+	retiresAt := m.keys[kid].RetiresAt
+
+	// The grace period starts once the new key signs
+	if want := m.newest().ActiveAt.Add(time.Hour); !retiresAt.Equal(want) {
+		t.Errorf("Old key retires at %v, want %v", retiresAt, want)
+	}
+
+	if err := verify(m, token); err != nil {
+		t.Fatalf("Token was rejected within the grace period: %v", err)
+	}
+
+	publishAll(m)
+
+	m.mu.Lock()
+	m.keys[kid].RetiresAt = time.Now().UTC().Add(-time.Second)
+	m.mu.Unlock()
+
+	if err := verify(m, token); err == nil {
+		t.Error("Token from a retired key was accepted")
+	}
+
+	for _, jwk := range m.JWKS().Keys {
+		if jwk.KeyID == kid {
+			t.Error("Retired key is still published in the JWKS")
+		}
+	}
+
+	m.prune()
+
+	if _, ok := m.keys[kid]; ok {
+		t.Error("Retired key wasn't pruned")
+	}
+
+	if err := verify(m, sign(t, m)); err != nil {
+		t.Errorf("Token from the current key was rejected: %v", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: RS256})
+	token := sign(t, m)
+
+	set := m.JWKS()
+
+	if len(set.Keys) != 1 {
+		t.Fatalf("Got %d keys in the JWKS, want 1", len(set.Keys))
+	}
+
+	jwk := set.Keys[0]
+
+	if jwk.KeyType != "RSA" || jwk.Use != "sig" || jwk.Algorithm != "RS256" || jwk.KeyID != kidOf(t, token) {
+		t.Errorf("Got JWK %+v", jwk)
+	}
+
+	// Whoever reads the JWKS has to be able to verify our tokens
+	public, err := jwk.PublicKey()
+
+	if err != nil {
+		t.Fatalf("Error decoding published key: %v", err)
+	}
+
+	_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return public, nil
+	}, jwt.WithValidMethods([]string{jwk.Algorithm}))
+
+	if err != nil {
+		t.Errorf("Token didn't verify against the published key: %v", err)
+	}
+}
+
+// Shared secrets must never be published
+func TestJWKSOmitsSecrets(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: HS256, Secret: []byte("secret")})
+
+	if err := verify(m, sign(t, m)); err != nil {
+		t.Fatalf("HS256 token was rejected: %v", err)
+	}
+
+	if got := len(m.JWKS().Keys); got != 0 {
+		t.Errorf("Got %d keys in the JWKS, want none", got)
+	}
+}
+
+// A token claiming another algorithm for a known kid, e.g. HS256 with the
+// public key as the secret, must not verify
+func TestKeyfuncRejectsAlgorithmMismatch(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: EdDSA})
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "1"})
+	forged.Header["kid"] = m.current.ID
+
+	token, err := forged.SignedString([]byte(m.current.Public().(ed25519.PublicKey)))
+
+	if err != nil {
+		t.Fatalf("Error signing forged token: %v", err)
+	}
+
+	if _, err := jwt.Parse(token, m.Keyfunc); err == nil {
+		t.Error("Keyfunc accepted a token signed with the wrong algorithm")
+	}
+
+	if err := verify(m, token); err == nil {
+		t.Error("Token signed with the wrong algorithm was accepted")
+	}
+}
+
+func TestKeyfuncRejectsUnknownKey(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: EdDSA})
+	other := newTestManager(t, Options{Algorithm: EdDSA})
+
+	if err := verify(m, sign(t, other)); err == nil {
+		t.Error("Token from an unknown key was accepted")
+	}
+}
+
+// Replicas sharing a keys directory sign with the same key
+func TestSharedKeysDir(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newTestManager(t, Options{Algorithm: EdDSA, Dir: dir})
+	second := newTestManager(t, Options{Algorithm: EdDSA, Dir: dir})
+
+	if first.current.ID != second.current.ID {
+		t.Errorf("Replicas sign with %s and %s", first.current.ID, second.current.ID)
+	}
+
+	if err := verify(second, sign(t, first)); err != nil {
+		t.Errorf("Token from another replica was rejected: %v", err)
+	}
+}
+
+// Verifiers that only know the keys from before a rotation, like other
+// replicas that haven't reloaded yet or clients with a cached JWKS, keep
+// verifying what we sign right after it
+func TestRotatePublishesBeforeSigning(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newTestManager(t, Options{Algorithm: EdDSA, Dir: dir, RotationInterval: time.Hour})
+	second := newTestManager(t, Options{Algorithm: EdDSA, Dir: dir, RotationInterval: time.Hour})
+
+	cached := first.JWKS()
+
+	if err := first.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+
+	token := sign(t, first)
+
+	if err := verify(second, token); err != nil {
+		t.Errorf("Token was rejected by a replica that hadn't reloaded: %v", err)
+	}
+
+	_, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+		for _, jwk := range cached.Keys {
+			if jwk.KeyID == t.Header["kid"] {
+				return jwk.PublicKey()
+			}
+		}
+
+		return nil, fmt.Errorf("Key not in the cached JWKS")
+	}, jwt.WithValidMethods([]string{"EdDSA"}))
+
+	if err != nil {
+		t.Errorf("Token didn't verify against the JWKS fetched before rotating: %v", err)
+	}
+
+	// Replicas derive the switch from the key files, so they agree on it
+	second.tick()
+	publishAll(first)
+	publishAll(second)
+
+	if first.current.ID != second.current.ID {
+		t.Errorf("Replicas sign with %s and %s after the publish delay", first.current.ID, second.current.ID)
+	}
+}
+
+// A restart during the publish delay doesn't rotate again, nor sign with the
+// pending key early
+func TestRestartDuringPublishDelay(t *testing.T) {
+	dir := t.TempDir()
+
+	first := newTestManager(t, Options{Algorithm: EdDSA, Dir: dir})
+	signer := first.current.ID
+
+	if err := first.Rotate(); err != nil {
+		t.Fatalf("Error rotating: %v", err)
+	}
+
+	restarted := newTestManager(t, Options{Algorithm: EdDSA, Dir: dir})
+
+	if got := len(restarted.keys); got != 2 {
+		t.Errorf("Got %d keys after restarting, want 2", got)
+	}
+
+	if restarted.current.ID != signer {
+		t.Errorf("Restarted replica signs with %s, want %s", restarted.current.ID, signer)
+	}
+}
+
+// Meant for -race: verification runs while keys rotate and get pruned
+func TestConcurrentRotation(t *testing.T) {
+	m := newTestManager(t, Options{Algorithm: EdDSA})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				if err := verify(m, sign(t, m)); err != nil {
+					t.Errorf("Token was rejected: %v", err)
+					return
+				}
+
+				m.JWKS()
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		if err := m.Rotate(); err != nil {
+			t.Fatalf("Error rotating: %v", err)
+		}
+
+		m.prune()
+	}
+}
@@ -0,0 +1,145 @@
+// Package logging sets up the slog logger shared by the whole app: JSON (or
+// text) output, secrets redacted, and per-request attributes like the request
+// ID picked up from the context.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+// Sets the default slog logger, which the standard `log` package writes to as
+// well
+func Setup(cfg *config.Config, w io.Writer) *slog.Logger {
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	}
+
+	var handler slog.Handler = slog.NewJSONHandler(w, opts)
+
+	if cfg.LogFormat == "text" {
+		handler = slog.NewTextHandler(w, opts)
+	}
+
+	logger := slog.New(&contextHandler{Handler: handler})
+	slog.SetDefault(logger)
+
+	return logger
+}
+
+const redacted = "[REDACTED]"
+
+// Attributes named like one of these never make it to the logs
+var secretKeys = []string{
+	"password",
+	"secret",
+	"token",
+	"authorization",
+	"cookie",
+	"api_key",
+	"apikey",
+	"recovery_code",
+	"recoverycode",
+	"x-jwt-token",
+	"x-api-key",
+}
+
+// Catches secrets logged under innocent names: JWTs and our API keys
+var secretValues = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|gogym_[A-Za-z0-9]+_[A-Za-z0-9_-]+`)
+
+func redactAttr(_ []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(attr.Key, redacted)
+		}
+	}
+
+	if attr.Value.Kind() == slog.KindString {
+		value := attr.Value.String()
+
+		if secretValues.MatchString(value) {
+			return slog.String(attr.Key, secretValues.ReplaceAllString(value, redacted))
+		}
+	}
+
+	return attr
+}
+
+type contextKey struct{}
+
+// Attributes added while handling a request. Shared by pointer so attributes
+// added deep in the handler chain, like the account ID, also show up in the
+// access log written by the outermost middleware.
+type contextAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// Returns a context that collects attributes for every record logged with it
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, contextKey{}, &contextAttrs{attrs: attrs})
+}
+
+// Adds attributes to every later record logged with the context. Does nothing
+// for contexts not created by NewContext.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	holder, ok := ctx.Value(contextKey{}).(*contextAttrs)
+
+	if !ok {
+		return
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	holder.attrs = append(holder.attrs, attrs...)
+}
+
+func Attrs(ctx context.Context) []slog.Attr {
+	holder, ok := ctx.Value(contextKey{}).(*contextAttrs)
+
+	if !ok {
+		return nil
+	}
+
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+
+	return append([]slog.Attr(nil), holder.attrs...)
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs := Attrs(ctx); len(attrs) > 0 {
+		record = record.Clone()
+		record.AddAttrs(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
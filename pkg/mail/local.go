@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Prints every email to the log. Only for development, the emails contain
+// secret links.
+type LogMailer struct {
+	from string
+}
+
+func NewLogMailer(from string) *LogMailer {
+	return &LogMailer{from: from}
+}
+
+func (m *LogMailer) Send(msg Message) error {
+	// Development only: the body holds working links
+	slog.Info("Email", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+
+	return nil
+}
+
+// Writes every email as an .eml file into a directory
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) Send(msg Message) error {
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+
+	return os.WriteFile(filepath.Join(m.dir, name), msg.Format(m.from), 0o600)
+}
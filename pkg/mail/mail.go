@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // Plain text
+}
+
+// Anything that can deliver our emails. The SMTP mailer is for real
+// deployments, the log and file mailers let us follow links locally.
+type Mailer interface {
+	Send(Message) error
+}
+
+func NewMailer(config *config.Config) (Mailer, error) {
+	switch config.Mailer {
+	case "smtp":
+		return NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom), nil
+	case "file":
+		return NewFileMailer(config.MailDir, config.MailFrom)
+	case "log", "":
+		return NewLogMailer(config.MailFrom), nil
+	}
+
+	return nil, fmt.Errorf("Unknown mailer `%s`", config.Mailer)
+}
+
+// Renders the message as an RFC 5322 email
+func (m Message) Format(from string) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", m.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", m.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+
+	return []byte(b.String())
+}
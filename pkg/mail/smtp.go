@@ -0,0 +1,42 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// Without a username the server is used unauthenticated, e.g. a local relay
+func NewSMTPMailer(host string, port string, username string, password string, from string) *SMTPMailer {
+	var auth smtp.Auth
+
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPMailer{
+		addr: net.JoinHostPort(host, port),
+		auth: auth,
+		from: from,
+	}
+}
+
+func (m *SMTPMailer) Send(msg Message) error {
+	// Header injection guard, the recipient and subject end up in headers
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("Invalid email header")
+	}
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, msg.Format(m.from)); err != nil {
+		return fmt.Errorf("Error sending email to `%s`: %w", msg.To, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package oidc
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+)
+
+// A MockProvider is a tiny OpenID Connect provider for local development. It
+// signs in whoever asks without a login page: the `login_hint` parameter of
+// the authorization request picks the user, e.g. `alice` becomes
+// `alice@example.com`. Never enable it in production.
+type MockProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	key          *keys.Key
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        string
+	expiresAt   time.Time
+}
+
+func NewMockProvider(issuer string, clientID string, clientSecret string) (*MockProvider, error) {
+	key, err := keys.GenerateKey(keys.EdDSA)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &MockProvider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		key:          key,
+		codes:        map[string]mockGrant{},
+	}, nil
+}
+
+// Serves the provider with paths relative to the issuer
+func (m *MockProvider) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/.well-known/openid-configuration":
+		m.writeJSON(w, http.StatusOK, Metadata{
+			Issuer:                m.issuer,
+			AuthorizationEndpoint: m.issuer + "/authorize",
+			TokenEndpoint:         m.issuer + "/token",
+			JWKSURI:               m.issuer + "/jwks",
+		})
+	case "/jwks":
+		jwk, _ := keys.NewJWK(m.key.Public())
+		jwk.KeyID = m.key.ID
+		jwk.Algorithm = string(m.key.Algorithm)
+		jwk.Use = "sig"
+		m.writeJSON(w, http.StatusOK, keys.JWKSet{Keys: []keys.JWK{jwk}})
+	case "/authorize":
+		m.handleAuthorize(w, req)
+	case "/token":
+		m.handleToken(w, req)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (m *MockProvider) handleAuthorize(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+
+	if query.Get("client_id") != m.clientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	redirectURI, err := url.Parse(query.Get("redirect_uri"))
+
+	if err != nil || redirectURI.Scheme == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	user := query.Get("login_hint")
+	if user == "" {
+		user = "mock-user"
+	}
+
+	code, err := GenerateVerifier()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.mu.Lock()
+	for unused, grant := range m.codes {
+		if time.Now().After(grant.expiresAt) {
+			delete(m.codes, unused)
+		}
+	}
+	m.codes[code] = mockGrant{
+		clientID:    m.clientID,
+		redirectURI: redirectURI.String(),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		user:        user,
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+
+	http.Redirect(w, req, redirectURI.String(), http.StatusFound)
+}
+
+func (m *MockProvider) handleToken(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost || req.ParseForm() != nil {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := req.PostForm.Get("code")
+
+	// Codes are single use
+	m.mu.Lock()
+	grant, ok := m.codes[code]
+	delete(m.codes, code)
+	m.mu.Unlock()
+
+	if !ok || time.Now().After(grant.expiresAt) {
+		m.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	clientSecret := req.PostForm.Get("client_secret")
+
+	if req.PostForm.Get("client_id") != grant.clientID ||
+		subtle.ConstantTimeCompare([]byte(clientSecret), []byte(m.clientSecret)) != 1 {
+		m.writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	if req.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		S256Challenge(req.PostForm.Get("code_verifier")) != grant.challenge {
+		m.writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	user := strings.ToLower(grant.user)
+
+	claims := &IDTokenClaims{
+		Email:             fmt.Sprintf("%s@example.com", user),
+		EmailVerified:     true,
+		PreferredUsername: user,
+		Name:              grant.user,
+		Nonce:             grant.nonce,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    m.issuer,
+			Subject:   "mock|" + user,
+			Audience:  jwt.ClaimStrings{grant.clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	}
+
+	token := jwt.NewWithClaims(m.key.Algorithm.SigningMethod(), claims)
+	token.Header["kid"] = m.key.ID
+
+	idToken, err := token.SignedString(m.key.SigningKey())
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	m.writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": code,
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (m *MockProvider) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,283 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/grez-lucas/go-gym/pkg/keys"
+)
+
+type ProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// The parts of the discovery document we use
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims of an ID token we care about
+type IDTokenClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// A Provider is an OpenID Connect provider we let users sign in with. Its
+// discovery document and keys are fetched on first use and cached.
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     map[string]any
+}
+
+func NewProvider(config ProviderConfig) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// Where to send the user to sign in. `loginHint` is passed on to the
+// provider when set.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string, loginHint string) (string, error) {
+	metadata, err := p.discover(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {S256Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	if loginHint != "" {
+		params.Set("login_hint", loginHint)
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Trades the authorization code for tokens and returns the verified claims
+// of the ID token. The caller still has to check the nonce.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string) (*IDTokenClaims, error) {
+	metadata, err := p.discover(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"client_secret": {p.config.ClientSecret},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("Error calling token endpoint of `%s`: %w", p.config.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Token endpoint of `%s` returned %d", p.config.Name, resp.StatusCode)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("No ID token returned by `%s`", p.config.Name)
+	}
+
+	return p.verifyIDToken(ctx, tokens.IDToken)
+}
+
+func (p *Provider) verifyIDToken(ctx context.Context, idToken string) (*IDTokenClaims, error) {
+	claims := &IDTokenClaims{}
+
+	_, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "EdDSA"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid ID token from `%s`: %w", p.config.Name, err)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("ID token from `%s` has no subject", p.config.Name)
+	}
+
+	return claims, nil
+}
+
+// Looks up a signing key of the provider, refetching its JWKS once when the
+// kid is unknown since that's how a key rotation shows up
+func (p *Provider) key(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	p.mu.Unlock()
+
+	if ok {
+		return key, nil
+	}
+
+	if err := p.fetchKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("Unknown signing key `%s`", kid)
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) error {
+	metadata, err := p.discover(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	var set keys.JWKSet
+
+	if err := p.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return err
+	}
+
+	fetched := map[string]any{}
+
+	for _, jwk := range set.Keys {
+		public, err := jwk.PublicKey()
+
+		// Providers publish keys for other uses too, skip what we can't read
+		if err != nil {
+			continue
+		}
+
+		fetched[jwk.KeyID] = public
+	}
+
+	p.mu.Lock()
+	p.keys = fetched
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	metadata := p.metadata
+	p.mu.Unlock()
+
+	if metadata != nil {
+		return metadata, nil
+	}
+
+	metadata = new(Metadata)
+	discoveryURL := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+
+	if err := p.getJSON(ctx, discoveryURL, metadata); err != nil {
+		return nil, err
+	}
+
+	if metadata.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("Issuer mismatch for `%s`: got `%s`", p.config.Name, metadata.Issuer)
+	}
+
+	p.mu.Lock()
+	p.metadata = metadata
+	p.mu.Unlock()
+
+	return metadata, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("Error fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Fetching %s returned %d", url, resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often full buckets are dropped to free memory
+const sweepInterval = time.Minute
+
+// Keeps buckets in this process
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// time.Now, replaced in tests
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	// Tokens were counted at this time, refills are added lazily
+	updatedAt time.Time
+	policy    Policy
+}
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryBackend) Take(_ context.Context, key string, policy Policy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	key = policy.Name + ":" + key
+
+	b, ok := m.buckets[key]
+
+	if !ok {
+		b = &bucket{tokens: float64(policy.Limit), updatedAt: now, policy: policy}
+		m.buckets[key] = b
+	}
+
+	b.refill(now)
+
+	result := Result{Limit: policy.Limit}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(policy.interval()))
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(policy.Limit) - b.tokens) * float64(policy.interval()))
+
+	return result, nil
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updatedAt)
+	b.updatedAt = now
+
+	b.tokens = min(float64(b.policy.Limit), b.tokens+float64(elapsed)/float64(b.policy.interval()))
+}
+
+// A full bucket is the same as no bucket, so those can go
+func (m *MemoryBackend) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		b.refill(now)
+
+		if b.tokens >= float64(b.policy.Limit) {
+			delete(m.buckets, key)
+		}
+	}
+}
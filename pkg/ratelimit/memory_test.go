@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A backend whose clock only moves when the test says so
+func newTestBackend() (*MemoryBackend, *time.Time) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m := NewMemoryBackend()
+	m.now = func() time.Time { return now }
+
+	return m, &now
+}
+
+func take(t *testing.T, m *MemoryBackend, key string, policy Policy) Result {
+	t.Helper()
+
+	result, err := m.Take(context.Background(), key, policy)
+
+	if err != nil {
+		t.Fatalf("Error taking a token: %v", err)
+	}
+
+	return result
+}
+
+// 6 a minute is a token every 10 seconds
+var testPolicy = Policy{Name: "test", Limit: 6, Period: time.Minute}
+
+func TestBurst(t *testing.T) {
+	m, _ := newTestBackend()
+
+	for i := 0; i < testPolicy.Limit; i++ {
+		result := take(t, m, "alice", testPolicy)
+
+		if !result.Allowed || result.Remaining != testPolicy.Limit-i-1 || result.Limit != testPolicy.Limit {
+			t.Fatalf("Request %d: got %+v", i+1, result)
+		}
+	}
+
+	result := take(t, m, "alice", testPolicy)
+
+	if result.Allowed || result.Remaining != 0 {
+		t.Fatalf("Request over the burst: got %+v, want it rejected", result)
+	}
+
+	if result.RetryAfter != 10*time.Second || result.Reset != time.Minute {
+		t.Errorf("Got RetryAfter %v and Reset %v, want 10s and 1m", result.RetryAfter, result.Reset)
+	}
+}
+
+func TestRefill(t *testing.T) {
+	m, now := newTestBackend()
+
+	for i := 0; i < testPolicy.Limit; i++ {
+		take(t, m, "alice", testPolicy)
+	}
+
+	// Half a token back
+	*now = now.Add(5 * time.Second)
+
+	if result := take(t, m, "alice", testPolicy); result.Allowed || result.RetryAfter != 5*time.Second {
+		t.Errorf("Half a token in: got %+v, want rejected with RetryAfter 5s", result)
+	}
+
+	*now = now.Add(5 * time.Second)
+
+	if result := take(t, m, "alice", testPolicy); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("A token in: got %+v, want allowed with none left", result)
+	}
+
+	// Refills stop at the limit
+	*now = now.Add(time.Hour)
+
+	if result := take(t, m, "alice", testPolicy); !result.Allowed || result.Remaining != testPolicy.Limit-1 {
+		t.Errorf("After an hour: got %+v, want a full bucket", result)
+	}
+}
+
+// Keys and policies each get their own bucket
+func TestBucketKeys(t *testing.T) {
+	m, _ := newTestBackend()
+	other := Policy{Name: "other", Limit: 6, Period: time.Minute}
+
+	for i := 0; i < testPolicy.Limit; i++ {
+		take(t, m, "alice", testPolicy)
+	}
+
+	if result := take(t, m, "bob", testPolicy); !result.Allowed {
+		t.Error("Another key was limited by alice's bucket")
+	}
+
+	if result := take(t, m, "alice", other); !result.Allowed || result.Remaining != other.Limit-1 {
+		t.Errorf("Another policy: got %+v, want its own full bucket", result)
+	}
+}
+
+// Full buckets are dropped, the rest are kept
+func TestSweep(t *testing.T) {
+	m, now := newTestBackend()
+
+	take(t, m, "alice", testPolicy)
+
+	for i := 0; i < testPolicy.Limit; i++ {
+		take(t, m, "bob", testPolicy)
+	}
+
+	// Alice's bucket refilled, Bob's only by 2 tokens
+	*now = now.Add(sweepInterval / 3)
+	take(t, m, "carol", testPolicy)
+
+	*now = now.Add(sweepInterval)
+	take(t, m, "carol", testPolicy)
+
+	if _, ok := m.buckets["test:alice"]; ok {
+		t.Error("Full bucket wasn't swept")
+	}
+
+	if _, ok := m.buckets["test:carol"]; !ok {
+		t.Error("Bucket in use was swept")
+	}
+}
@@ -0,0 +1,38 @@
+// Package ratelimit implements token bucket rate limiting. Buckets live in a
+// Backend, so replicas can share them through an external store, the memory
+// backend only limits each replica on its own.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// A bucket of Limit tokens, refilled at Limit tokens per Period. Clients can
+// burst up to Limit requests, then get one every Period / Limit.
+type Policy struct {
+	// Shows up in metrics and keeps buckets of different policies apart
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Time for a single token to come back
+func (p Policy) interval() time.Duration {
+	return p.Period / time.Duration(p.Limit)
+}
+
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Until the bucket is full again
+	Reset time.Duration
+	// Until the next request would be allowed, zero when allowed
+	RetryAfter time.Duration
+}
+
+type Backend interface {
+	// Takes a token from the bucket `key` of the policy
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+type AccountTokenStorage interface {
+	CreateAccountToken(context.Context, *domain.AccountToken) (*domain.AccountToken, error)
+	ConsumeAccountToken(ctx context.Context, hash string, purpose string) (*domain.AccountToken, error)
+	ConsumeEmailVerificationToken(ctx context.Context, hash string) (*domain.AccountToken, error)
+	DeleteAccountTokens(ctx context.Context, accountID int, purpose string) error
+	GetLatestAccountToken(ctx context.Context, accountID int, purpose string) (*domain.AccountToken, error)
+	GetAccountTokensByAccount(context.Context, int) ([]*domain.AccountToken, error)
+}
+
+func (s *PostgreSQLStore) CreateAccountTokensTable() error {
+	query := `
+    CREATE table if not exists account_tokens (
+      id SERIAL PRIMARY KEY,
+      account_id INT REFERENCES accounts(id) ON DELETE CASCADE NOT NULL,
+      purpose VARCHAR(50) NOT NULL,
+      token_hash VARCHAR(64) UNIQUE NOT NULL,
+      email VARCHAR(254),
+      expires_at TIMESTAMP NOT NULL,
+      used_at TIMESTAMP,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+  );
+    ALTER TABLE account_tokens ADD COLUMN IF NOT EXISTS email VARCHAR(254);
+    CREATE INDEX if not exists account_tokens_account_id_idx ON account_tokens (account_id, purpose)`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+const accountTokenColumns = `id, account_id, purpose, token_hash, email, expires_at, used_at, created_at`
+
+const consumeAccountTokenQuery = `
+    UPDATE account_tokens
+    SET used_at=$3
+    WHERE token_hash=$1 AND purpose=$2 AND used_at IS NULL AND expires_at > $3
+    RETURNING ` + accountTokenColumns
+
+func (s *PostgreSQLStore) CreateAccountToken(ctx context.Context, t *domain.AccountToken) (*domain.AccountToken, error) {
+	query := `
+    INSERT INTO account_tokens (account_id, purpose, token_hash, email, expires_at, created_at)
+    VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+    RETURNING ` + accountTokenColumns
+
+	row := s.db.QueryRowContext(ctx, query, t.AccountID, t.Purpose, t.TokenHash, t.Email, t.ExpiresAt, t.CreatedAt)
+
+	return scanIntoAccountToken(row)
+}
+
+// Marks the token as used and returns it, as long as it exists for this
+// purpose, is unused and hasn't expired. Checking and consuming in a single
+// statement means a token can never be redeemed twice.
+func (s *PostgreSQLStore) ConsumeAccountToken(ctx context.Context, hash string, purpose string) (*domain.AccountToken, error) {
+	now := time.Now().UTC()
+
+	token, err := scanIntoAccountToken(s.db.QueryRowContext(ctx, consumeAccountTokenQuery, hash, purpose, now))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("Token")
+	}
+
+	return token, translateError(err)
+}
+
+// Consumes an email verification token and gives its account the address,
+// in one transaction. When another account verified the same address first
+// the unique index fails the update with ErrConflict, and the token is left
+// unused instead of burned.
+func (s *PostgreSQLStore) ConsumeEmailVerificationToken(ctx context.Context, hash string) (*domain.AccountToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	token, err := scanIntoAccountToken(tx.QueryRowContext(ctx, consumeAccountTokenQuery, hash, domain.PurposeVerifyEmail, now))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("Token")
+	}
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	query := `
+    UPDATE accounts
+    SET email=$2, email_verified_at=$3, updated_at=$3
+    WHERE id=$1`
+
+	if _, err := tx.ExecContext(ctx, query, token.AccountID, token.Email, now); err != nil {
+		return nil, translateError(err)
+	}
+
+	return token, translateError(tx.Commit())
+}
+
+// Throws away the tokens of an account, e.g. older reset links once a new
+// one is requested
+func (s *PostgreSQLStore) DeleteAccountTokens(ctx context.Context, accountID int, purpose string) error {
+	query := `
+    DELETE FROM account_tokens
+    WHERE account_id=$1 AND purpose=$2`
+
+	_, err := s.db.ExecContext(ctx, query, accountID, purpose)
+
+	return translateError(err)
+}
+
+// The newest token of an account for `purpose`, used or not. For email
+// verification that's the address waiting to be verified.
+func (s *PostgreSQLStore) GetLatestAccountToken(ctx context.Context, accountID int, purpose string) (*domain.AccountToken, error) {
+	query := `
+    SELECT ` + accountTokenColumns + `
+    FROM account_tokens
+    WHERE account_id=$1 AND purpose=$2
+    ORDER BY created_at DESC, id DESC
+    LIMIT 1`
+
+	token, err := scanIntoAccountToken(s.db.QueryRowContext(ctx, query, accountID, purpose))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("Token")
+	}
+
+	return token, translateError(err)
+}
+
+func (s *PostgreSQLStore) GetAccountTokensByAccount(ctx context.Context, accountID int) ([]*domain.AccountToken, error) {
+	query := `
+    SELECT ` + accountTokenColumns + `
+    FROM account_tokens
+    WHERE account_id=$1
+    ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	tokens := []*domain.AccountToken{}
+
+	for rows.Next() {
+		token, err := scanIntoAccountToken(rows)
+
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, translateError(rows.Err())
+}
+
+func scanIntoAccountToken(row scanner) (*domain.AccountToken, error) {
+	token := new(domain.AccountToken)
+
+	var email sql.NullString
+	var usedAt sql.NullTime
+
+	err := row.Scan(
+		&token.ID,
+		&token.AccountID,
+		&token.Purpose,
+		&token.TokenHash,
+		&email,
+		&token.ExpiresAt,
+		&usedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	token.Email = email.String
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	return token, nil
+}
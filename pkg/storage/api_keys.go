@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/lib/pq"
+)
+
+// Last use is only written this often, not on every request
+const apiKeyTouchInterval = time.Minute
+
+type APIKeyStorage interface {
+	CreateAPIKey(context.Context, *domain.APIKey) (*domain.APIKey, error)
+	GetAPIKeys(context.Context) ([]*domain.APIKey, error)
+	GetAPIKeysByCreator(context.Context, int) ([]*domain.APIKey, error)
+	GetAPIKeyByPrefix(context.Context, string) (*domain.APIKey, error)
+	RevokeAPIKey(context.Context, int) error
+	TouchAPIKey(context.Context, int) error
+}
+
+func (s *PostgreSQLStore) CreateAPIKeysTable() error {
+	query := `
+    CREATE table if not exists api_keys (
+      id SERIAL PRIMARY KEY,
+      name VARCHAR(100) NOT NULL,
+      prefix VARCHAR(16) UNIQUE NOT NULL,
+      key_hash VARCHAR(64) NOT NULL,
+      scopes TEXT[] NOT NULL DEFAULT '{}',
+      created_by INT REFERENCES accounts(id) ON DELETE SET NULL,
+      expires_at TIMESTAMP,
+      last_used_at TIMESTAMP,
+      revoked_at TIMESTAMP,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+  )`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+const apiKeyColumns = `id, name, prefix, key_hash, scopes, created_by, expires_at, last_used_at, revoked_at, created_at`
+
+func (s *PostgreSQLStore) CreateAPIKey(ctx context.Context, k *domain.APIKey) (*domain.APIKey, error) {
+	query := `
+    INSERT INTO api_keys (name, prefix, key_hash, scopes, created_by, expires_at, created_at)
+    VALUES ($1, $2, $3, $4, $5, $6, $7)
+    RETURNING ` + apiKeyColumns
+
+	row := s.db.QueryRowContext(ctx, query, k.Name, k.Prefix, k.KeyHash, pq.Array(k.Scopes), k.CreatedBy, k.ExpiresAt, k.CreatedAt)
+
+	return scanIntoAPIKey(row)
+}
+
+func (s *PostgreSQLStore) GetAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ORDER BY id`
+
+	return s.queryAPIKeys(ctx, query)
+}
+
+func (s *PostgreSQLStore) GetAPIKeysByCreator(ctx context.Context, accountID int) ([]*domain.APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE created_by=$1 ORDER BY id`
+
+	return s.queryAPIKeys(ctx, query, accountID)
+}
+
+func (s *PostgreSQLStore) queryAPIKeys(ctx context.Context, query string, args ...any) ([]*domain.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	apiKeys := []*domain.APIKey{}
+
+	for rows.Next() {
+		apiKey, err := scanIntoAPIKey(rows)
+
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, translateError(rows.Err())
+}
+
+func (s *PostgreSQLStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	query := `
+    SELECT ` + apiKeyColumns + `
+    FROM api_keys
+    WHERE prefix=$1`
+
+	apiKey, err := scanIntoAPIKey(s.db.QueryRowContext(ctx, query, prefix))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("API key")
+	}
+
+	return apiKey, translateError(err)
+}
+
+func (s *PostgreSQLStore) RevokeAPIKey(ctx context.Context, id int) error {
+	query := `
+    UPDATE api_keys
+    SET revoked_at=$2
+    WHERE id=$1 AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, id, time.Now().UTC())
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("API key")
+	}
+
+	return nil
+}
+
+// Records that the key was used, at most once per apiKeyTouchInterval
+func (s *PostgreSQLStore) TouchAPIKey(ctx context.Context, id int) error {
+	now := time.Now().UTC()
+
+	query := `
+    UPDATE api_keys
+    SET last_used_at=$2
+    WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < $3)`
+
+	_, err := s.db.ExecContext(ctx, query, id, now, now.Add(-apiKeyTouchInterval))
+
+	return translateError(err)
+}
+
+// Satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanIntoAPIKey(row scanner) (*domain.APIKey, error) {
+	apiKey := new(domain.APIKey)
+
+	var createdBy sql.NullInt64
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&apiKey.ID,
+		&apiKey.Name,
+		&apiKey.Prefix,
+		&apiKey.KeyHash,
+		pq.Array(&apiKey.Scopes),
+		&createdBy,
+		&expiresAt,
+		&lastUsedAt,
+		&revokedAt,
+		&apiKey.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	apiKey.CreatedBy = int(createdBy.Int64)
+
+	if expiresAt.Valid {
+		apiKey.ExpiresAt = &expiresAt.Time
+	}
+
+	if lastUsedAt.Valid {
+		apiKey.LastUsedAt = &lastUsedAt.Time
+	}
+
+	if revokedAt.Valid {
+		apiKey.RevokedAt = &revokedAt.Time
+	}
+
+	return apiKey, nil
+}
@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/grez-lucas/go-gym/pkg/cache"
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+)
+
+// A read-through cache for gyms and their ratings, the bulk of our reads.
+// Everything else goes straight to the wrapped Storage.
+//
+// Writes through this store invalidate what they touch. Writes by other
+// replicas don't, their changes show up here once entries expire.
+type CachedStore struct {
+	Storage
+
+	gyms     *cache.LRU[int, *domain.Gym]
+	gymLists *cache.LRU[struct{}, []*domain.Gym]
+	averages *cache.LRU[int, float32]
+
+	// Concurrent misses for the same entry share one query
+	group singleflight.Group
+	// Bumped by every invalidation. A query that started before one doesn't
+	// get to cache its, possibly stale, result.
+	generation atomic.Uint64
+	// Held to bump the generation and drop entries, and to compare it and
+	// cache a result, so no invalidation can slip in between the two
+	mu sync.Mutex
+}
+
+// Keeps up to `size` entries of each kind for `ttl`
+func NewCachedStore(next Storage, size int, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		Storage:  next,
+		gyms:     cache.NewLRU[int, *domain.Gym](size, ttl),
+		gymLists: cache.NewLRU[struct{}, []*domain.Gym](1, ttl),
+		averages: cache.NewLRU[int, float32](size, ttl),
+	}
+}
+
+func (s *CachedStore) GetGymByID(ctx context.Context, id int) (*domain.Gym, error) {
+	gym, err := readThrough(ctx, s, s.gyms, "gym", id, func(ctx context.Context) (*domain.Gym, error) {
+		return s.Storage.GetGymByID(ctx, id)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cloneGym(gym), nil
+}
+
+func (s *CachedStore) GetGyms(ctx context.Context) ([]*domain.Gym, error) {
+	gyms, err := readThrough(ctx, s, s.gymLists, "gyms", struct{}{}, s.Storage.GetGyms)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clones := make([]*domain.Gym, 0, len(gyms))
+
+	for _, gym := range gyms {
+		clones = append(clones, cloneGym(gym))
+	}
+
+	return clones, nil
+}
+
+func (s *CachedStore) GetAverageRating(ctx context.Context, gymID int) (float32, error) {
+	return readThrough(ctx, s, s.averages, "average_rating", gymID, func(ctx context.Context) (float32, error) {
+		return s.Storage.GetAverageRating(ctx, gymID)
+	})
+}
+
+func (s *CachedStore) CreateGym(ctx context.Context, gym *domain.Gym) (*domain.Gym, error) {
+	created, err := s.Storage.CreateGym(ctx, gym)
+
+	if err == nil {
+		s.invalidateGym(created.ID)
+	}
+
+	return created, err
+}
+
+func (s *CachedStore) UpdateGym(ctx context.Context, gym *domain.Gym) error {
+	err := s.Storage.UpdateGym(ctx, gym)
+
+	if err == nil {
+		s.invalidateGym(gym.ID)
+	}
+
+	return err
+}
+
+func (s *CachedStore) DeleteGym(ctx context.Context, id int) error {
+	err := s.Storage.DeleteGym(ctx, id)
+
+	// Even a failed delete may have gone through
+	s.invalidateGym(id)
+
+	return err
+}
+
+func (s *CachedStore) CreateRating(ctx context.Context, rating *domain.Rating) (*domain.Rating, error) {
+	created, err := s.Storage.CreateRating(ctx, rating)
+
+	s.invalidateGym(rating.GymID)
+
+	return created, err
+}
+
+// The gym, its average and any list it shows up in
+func (s *CachedStore) invalidateGym(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation.Add(1)
+
+	s.gyms.Delete(id)
+	s.averages.Delete(id)
+	s.gymLists.Delete(struct{}{})
+}
+
+// Returns the cached value for `key`, loading and caching it on a miss.
+// Errors aren't cached.
+func readThrough[K comparable, V any](ctx context.Context, s *CachedStore, c *cache.LRU[K, V], name string, key K, load func(context.Context) (V, error)) (V, error) {
+	if value, ok := c.Get(key); ok {
+		metrics.CacheRequests.WithLabelValues(name, "hit").Inc()
+		return value, nil
+	}
+
+	metrics.CacheRequests.WithLabelValues(name, "miss").Inc()
+
+	generation := s.generation.Load()
+
+	// Waiting callers share the first one's query, which shouldn't fail for
+	// all of them when that one client goes away
+	loadCtx := context.WithoutCancel(ctx)
+
+	result, err, _ := s.group.Do(fmt.Sprintf("%s:%v:%d", name, key, generation), func() (any, error) {
+		value, err := load(loadCtx)
+
+		if err == nil {
+			s.mu.Lock()
+
+			if s.generation.Load() == generation {
+				c.Set(key, value)
+			}
+
+			s.mu.Unlock()
+		}
+
+		return value, err
+	})
+
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+
+	return result.(V), nil
+}
+
+// Callers get their own copy, so changing it can't corrupt the cache
+func cloneGym(gym *domain.Gym) *domain.Gym {
+	clone := *gym
+
+	return &clone
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// Counts the reads reaching it. Anything else panics on the nil Storage.
+type countingStore struct {
+	Storage
+
+	gymReads     atomic.Int32
+	listReads    atomic.Int32
+	averageReads atomic.Int32
+	// When set, list reads wait for it to close
+	release chan struct{}
+}
+
+func (s *countingStore) GetGymByID(ctx context.Context, id int) (*domain.Gym, error) {
+	s.gymReads.Add(1)
+
+	return &domain.Gym{ID: id, Name: "Iron Temple"}, nil
+}
+
+func (s *countingStore) GetGyms(ctx context.Context) ([]*domain.Gym, error) {
+	s.listReads.Add(1)
+
+	if s.release != nil {
+		<-s.release
+	}
+
+	return []*domain.Gym{{ID: 1, Name: "Iron Temple"}}, nil
+}
+
+func (s *countingStore) GetAverageRating(ctx context.Context, id int) (float32, error) {
+	s.averageReads.Add(1)
+
+	return 4.5, nil
+}
+
+func (s *countingStore) CreateGym(ctx context.Context, gym *domain.Gym) (*domain.Gym, error) {
+	created := *gym
+	created.ID = 1
+
+	return &created, nil
+}
+
+func (s *countingStore) UpdateGym(ctx context.Context, gym *domain.Gym) error {
+	return nil
+}
+
+func (s *countingStore) DeleteGym(ctx context.Context, id int) error {
+	return nil
+}
+
+func (s *countingStore) CreateRating(ctx context.Context, rating *domain.Rating) (*domain.Rating, error) {
+	return rating, nil
+}
+
+// Warms every cache of gym 1
+func readAll(t *testing.T, s *CachedStore) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	if _, err := s.GetGymByID(ctx, 1); err != nil {
+		t.Fatalf("Error getting gym: %v", err)
+	}
+
+	if _, err := s.GetGyms(ctx); err != nil {
+		t.Fatalf("Error getting gyms: %v", err)
+	}
+
+	if _, err := s.GetAverageRating(ctx, 1); err != nil {
+		t.Fatalf("Error getting average: %v", err)
+	}
+}
+
+func TestCachedStoreHits(t *testing.T) {
+	next := &countingStore{}
+	s := NewCachedStore(next, 10, time.Hour)
+
+	readAll(t, s)
+	readAll(t, s)
+
+	if next.gymReads.Load() != 1 || next.listReads.Load() != 1 || next.averageReads.Load() != 1 {
+		t.Errorf("Got %d gym, %d list and %d average reads, want one each", next.gymReads.Load(), next.listReads.Load(), next.averageReads.Load())
+	}
+
+	// Callers get copies
+	gym, _ := s.GetGymByID(context.Background(), 1)
+	gym.Name = "Changed"
+
+	if cached, _ := s.GetGymByID(context.Background(), 1); cached.Name != "Iron Temple" {
+		t.Errorf("Changing a returned gym changed the cache to %q", cached.Name)
+	}
+}
+
+func TestCachedStoreTTL(t *testing.T) {
+	next := &countingStore{}
+	s := NewCachedStore(next, 10, 20*time.Millisecond)
+
+	readAll(t, s)
+	time.Sleep(30 * time.Millisecond)
+	readAll(t, s)
+
+	if next.gymReads.Load() != 2 {
+		t.Errorf("Got %d gym reads, want the expired entry read again", next.gymReads.Load())
+	}
+}
+
+func TestCachedStoreEviction(t *testing.T) {
+	next := &countingStore{}
+	s := NewCachedStore(next, 2, time.Hour)
+	ctx := context.Background()
+
+	for _, id := range []int{1, 2, 3, 1} {
+		s.GetGymByID(ctx, id)
+	}
+
+	if next.gymReads.Load() != 4 {
+		t.Errorf("Got %d gym reads, want gym 1 read again after being evicted", next.gymReads.Load())
+	}
+}
+
+// Every write drops the gym, its average and the list
+func TestCachedStoreInvalidation(t *testing.T) {
+	writes := map[string]func(*CachedStore) error{
+		"CreateGym": func(s *CachedStore) error {
+			_, err := s.CreateGym(context.Background(), domain.NewGym("Iron Temple", ""))
+			return err
+		},
+		"UpdateGym": func(s *CachedStore) error {
+			return s.UpdateGym(context.Background(), &domain.Gym{ID: 1})
+		},
+		"DeleteGym": func(s *CachedStore) error {
+			return s.DeleteGym(context.Background(), 1)
+		},
+		"CreateRating": func(s *CachedStore) error {
+			_, err := s.CreateRating(context.Background(), &domain.Rating{GymID: 1, Rating: 5})
+			return err
+		},
+	}
+
+	for name, write := range writes {
+		t.Run(name, func(t *testing.T) {
+			next := &countingStore{}
+			s := NewCachedStore(next, 10, time.Hour)
+
+			readAll(t, s)
+
+			if err := write(s); err != nil {
+				t.Fatalf("Error writing: %v", err)
+			}
+
+			readAll(t, s)
+
+			if next.gymReads.Load() != 2 || next.listReads.Load() != 2 || next.averageReads.Load() != 2 {
+				t.Errorf("Got %d gym, %d list and %d average reads, want two each", next.gymReads.Load(), next.listReads.Load(), next.averageReads.Load())
+			}
+		})
+	}
+}
+
+// Concurrent misses share one query
+func TestCachedStoreSingleflight(t *testing.T) {
+	next := &countingStore{release: make(chan struct{})}
+	s := NewCachedStore(next, 10, time.Hour)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if gyms, err := s.GetGyms(context.Background()); err != nil || len(gyms) != 1 {
+				t.Errorf("Got %v, %v", gyms, err)
+			}
+		}()
+	}
+
+	// Let the others pile up behind the first query
+	for next.listReads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(next.release)
+	wg.Wait()
+
+	if got := next.listReads.Load(); got != 1 {
+		t.Errorf("Got %d list reads for concurrent misses, want 1", got)
+	}
+}
+
+// A query that started before a write mustn't cache what it read
+func TestCachedStoreWriteDuringRead(t *testing.T) {
+	next := &countingStore{release: make(chan struct{})}
+	s := NewCachedStore(next, 10, time.Hour)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.GetGyms(context.Background())
+	}()
+
+	for next.listReads.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	s.DeleteGym(context.Background(), 1)
+	close(next.release)
+	<-done
+
+	s.GetGyms(context.Background())
+
+	if got := next.listReads.Load(); got != 2 {
+		t.Errorf("Got %d list reads, want the list read again after the write", got)
+	}
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of storage errors callers can check for with errors.Is, whatever the
+// database behind them
+var (
+	ErrNotFound    = errors.New("Not found")
+	ErrConflict    = errors.New("Conflict")
+	ErrConstraint  = errors.New("Constraint violated")
+	ErrUnavailable = errors.New("Database unavailable")
+)
+
+// An Error is one of the kinds above along with a message safe to show to
+// clients. The driver error it came from, if any, is kept for errors.As.
+type Error struct {
+	Kind    error
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+
+	return []error{e.Kind, e.Err}
+}
+
+// Like notFound("Gym with ID %d", id)
+func notFound(format string, args ...any) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf(format, args...) + " not found"}
+}
+
+// Postgres error codes we map to our kinds, see
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pqUniqueViolation      = "23505"
+	pqForeignKeyViolation  = "23503"
+	pqNotNullViolation     = "23502"
+	pqCheckViolation       = "23514"
+	pqDataException        = "22"
+	pqConnectionException  = "08"
+	pqInsufficientRes      = "53"
+	pqOperatorIntervention = "57P"
+)
+
+// Translates driver errors into our kinds. Errors it doesn't know about, and
+// ones already translated, are returned as is.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var storageErr *Error
+	if errors.As(err, &storageErr) {
+		return err
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Message: "Not found", Err: err}
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return translatePQError(pqErr)
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr) {
+		slog.Error("Database unavailable", "error", err)
+		return &Error{Kind: ErrUnavailable, Message: "Database unavailable, try again later", Err: err}
+	}
+
+	return err
+}
+
+func translatePQError(err *pq.Error) error {
+	code := string(err.Code)
+
+	switch {
+	case code == pqUniqueViolation:
+		logConstraintViolation(err)
+		return &Error{Kind: ErrConflict, Message: "Already exists", Err: err}
+	case code == pqForeignKeyViolation || code == pqNotNullViolation || code == pqCheckViolation:
+		logConstraintViolation(err)
+		return &Error{Kind: ErrConstraint, Message: "Invalid reference or missing value", Err: err}
+	case strings.HasPrefix(code, pqDataException):
+		return &Error{Kind: ErrConstraint, Message: err.Message, Err: err}
+	case strings.HasPrefix(code, pqConnectionException),
+		strings.HasPrefix(code, pqInsufficientRes),
+		strings.HasPrefix(code, pqOperatorIntervention):
+		slog.Error("Database unavailable", "error", err)
+		return &Error{Kind: ErrUnavailable, Message: "Database unavailable, try again later", Err: err}
+	}
+
+	return err
+}
+
+// The detail names the columns and values involved, like
+// "Key (email)=(alice@example.com) already exists.", so it's only logged and
+// never shown to clients
+func logConstraintViolation(err *pq.Error) {
+	slog.Info("Constraint violated", "code", string(err.Code), "constraint", err.Constraint, "detail", err.Detail)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		// nil when the error should come back as is
+		want        error
+		wantMessage string
+	}{
+		{"no rows", sql.ErrNoRows, ErrNotFound, "Not found"},
+		{"wrapped no rows", fmt.Errorf("Getting gym: %w", sql.ErrNoRows), ErrNotFound, "Not found"},
+		{"unique violation hides detail", &pq.Error{Code: "23505", Detail: "Key (username)=(alice) already exists."}, ErrConflict, "Already exists"},
+		{"unique violation without detail", &pq.Error{Code: "23505"}, ErrConflict, "Already exists"},
+		{"foreign key violation", &pq.Error{Code: "23503"}, ErrConstraint, "Invalid reference or missing value"},
+		{"not null violation hides detail", &pq.Error{Code: "23502", Detail: "Failing row"}, ErrConstraint, "Invalid reference or missing value"},
+		{"check violation", &pq.Error{Code: "23514"}, ErrConstraint, "Invalid reference or missing value"},
+		{"data exception", &pq.Error{Code: "22P02", Message: "invalid input syntax"}, ErrConstraint, "invalid input syntax"},
+		{"connection exception", &pq.Error{Code: "08006"}, ErrUnavailable, "Database unavailable, try again later"},
+		{"too many connections", &pq.Error{Code: "53300"}, ErrUnavailable, "Database unavailable, try again later"},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, ErrUnavailable, "Database unavailable, try again later"},
+		{"bad connection", driver.ErrBadConn, ErrUnavailable, "Database unavailable, try again later"},
+		{"connection done", sql.ErrConnDone, ErrUnavailable, "Database unavailable, try again later"},
+		{"network error", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrUnavailable, "Database unavailable, try again later"},
+		{"already translated", notFound("Gym with ID %d", 1), ErrNotFound, "Gym with ID 1 not found"},
+		{"unknown pq error", &pq.Error{Code: "42P01", Message: "relation does not exist"}, nil, ""},
+		{"unknown error", errors.New("something else"), nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := translateError(tt.err)
+
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("Got %v, want the error back as is", got)
+				}
+				return
+			}
+
+			if !errors.Is(got, tt.want) {
+				t.Fatalf("Got %v, want a %v error", got, tt.want)
+			}
+
+			if got.Error() != tt.wantMessage {
+				t.Errorf("Got message %q, want %q", got.Error(), tt.wantMessage)
+			}
+
+			// The driver error stays reachable
+			if !errors.Is(got, tt.err) {
+				t.Errorf("Lost the original error %v", tt.err)
+			}
+		})
+	}
+
+	if translateError(nil) != nil {
+		t.Error("nil wasn't kept nil")
+	}
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+type IdempotencyKeyStorage interface {
+	ClaimIdempotencyKey(context.Context, *domain.IdempotencyKey) (*domain.IdempotencyKey, error)
+	GetIdempotencyKey(ctx context.Context, scope string, key string) (*domain.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, id int, status int, contentType string, body []byte) error
+	DeleteIdempotencyKey(context.Context, int) error
+	DeleteExpiredIdempotencyKeys(context.Context) (int64, error)
+	DeleteIdempotencyKeysByScope(context.Context, string) error
+}
+
+func (s *PostgreSQLStore) CreateIdempotencyKeysTable() error {
+	query := `
+    CREATE table if not exists idempotency_keys (
+      id SERIAL PRIMARY KEY,
+      scope VARCHAR(100) NOT NULL,
+      key VARCHAR(255) NOT NULL,
+      request_hash VARCHAR(64) NOT NULL,
+      response_status INT,
+      response_content_type VARCHAR(255),
+      response_body BYTEA,
+      expires_at TIMESTAMP NOT NULL,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+      UNIQUE (scope, key)
+  );
+    CREATE INDEX if not exists idempotency_keys_expires_at_idx ON idempotency_keys (expires_at)`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+const idempotencyKeyColumns = `id, scope, key, request_hash, response_status, response_content_type, response_body, expires_at, created_at`
+
+// Inserts the key, taking over an expired one with the same name. Returns
+// ErrConflict when a live key already exists, whoever holds it.
+func (s *PostgreSQLStore) ClaimIdempotencyKey(ctx context.Context, k *domain.IdempotencyKey) (*domain.IdempotencyKey, error) {
+	query := `
+    INSERT INTO idempotency_keys (scope, key, request_hash, expires_at, created_at)
+    VALUES ($1, $2, $3, $4, $5)
+    ON CONFLICT (scope, key) DO UPDATE
+    SET request_hash=EXCLUDED.request_hash, response_status=NULL, response_content_type=NULL,
+        response_body=NULL, expires_at=EXCLUDED.expires_at, created_at=EXCLUDED.created_at
+    WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+    RETURNING ` + idempotencyKeyColumns
+
+	row := s.db.QueryRowContext(ctx, query, k.Scope, k.Key, k.RequestHash, k.ExpiresAt, k.CreatedAt)
+
+	claimed, err := scanIntoIdempotencyKey(row)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, &Error{Kind: ErrConflict, Message: "Idempotency key already in use"}
+	}
+
+	return claimed, err
+}
+
+// Expired keys count as missing
+func (s *PostgreSQLStore) GetIdempotencyKey(ctx context.Context, scope string, key string) (*domain.IdempotencyKey, error) {
+	query := `
+    SELECT ` + idempotencyKeyColumns + `
+    FROM idempotency_keys
+    WHERE scope=$1 AND key=$2 AND expires_at > $3`
+
+	k, err := scanIntoIdempotencyKey(s.db.QueryRowContext(ctx, query, scope, key, time.Now().UTC()))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("Idempotency key")
+	}
+
+	return k, err
+}
+
+// Stores the response to replay
+func (s *PostgreSQLStore) CompleteIdempotencyKey(ctx context.Context, id int, status int, contentType string, body []byte) error {
+	query := `
+    UPDATE idempotency_keys
+    SET response_status=$2, response_content_type=$3, response_body=$4
+    WHERE id=$1`
+
+	_, err := s.db.ExecContext(ctx, query, id, status, contentType, body)
+
+	return translateError(err)
+}
+
+// Frees the key, so a retry runs the request again
+func (s *PostgreSQLStore) DeleteIdempotencyKey(ctx context.Context, id int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE id=$1`, id)
+
+	return translateError(err)
+}
+
+// Returns how many keys were deleted
+func (s *PostgreSQLStore) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, time.Now().UTC())
+
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	deleted, err := result.RowsAffected()
+
+	return deleted, translateError(err)
+}
+
+// Drops every stored response of a client, e.g. of a deleted account
+func (s *PostgreSQLStore) DeleteIdempotencyKeysByScope(ctx context.Context, scope string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE scope=$1`, scope)
+
+	return translateError(err)
+}
+
+func scanIntoIdempotencyKey(row *sql.Row) (*domain.IdempotencyKey, error) {
+	k := new(domain.IdempotencyKey)
+
+	var status sql.NullInt64
+	var contentType sql.NullString
+
+	err := row.Scan(
+		&k.ID,
+		&k.Scope,
+		&k.Key,
+		&k.RequestHash,
+		&status,
+		&contentType,
+		&k.ResponseBody,
+		&k.ExpiresAt,
+		&k.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	k.ResponseStatus = int(status.Int64)
+	k.ResponseContentType = contentType.String
+
+	return k, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+type IdentityStorage interface {
+	CreateIdentity(context.Context, *domain.Identity) (*domain.Identity, error)
+	GetIdentity(ctx context.Context, provider string, subject string) (*domain.Identity, error)
+	GetIdentitiesByAccount(context.Context, int) ([]*domain.Identity, error)
+}
+
+func (s *PostgreSQLStore) CreateIdentitiesTable() error {
+	query := `
+    CREATE table if not exists account_identities (
+      id SERIAL PRIMARY KEY,
+      account_id INT REFERENCES accounts(id) ON DELETE CASCADE NOT NULL,
+      provider VARCHAR(50) NOT NULL,
+      subject VARCHAR(255) NOT NULL,
+      email VARCHAR(254),
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+      UNIQUE (provider, subject)
+  )`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+const identityColumns = `id, account_id, provider, subject, email, created_at`
+
+func (s *PostgreSQLStore) CreateIdentity(ctx context.Context, i *domain.Identity) (*domain.Identity, error) {
+	query := `
+    INSERT INTO account_identities (account_id, provider, subject, email, created_at)
+    VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+    RETURNING ` + identityColumns
+
+	row := s.db.QueryRowContext(ctx, query, i.AccountID, i.Provider, i.Subject, i.Email, i.CreatedAt)
+
+	return scanIntoIdentity(row)
+}
+
+func (s *PostgreSQLStore) GetIdentity(ctx context.Context, provider string, subject string) (*domain.Identity, error) {
+	query := `
+    SELECT ` + identityColumns + `
+    FROM account_identities
+    WHERE provider=$1 AND subject=$2`
+
+	identity, err := scanIntoIdentity(s.db.QueryRowContext(ctx, query, provider, subject))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("Identity")
+	}
+
+	return identity, translateError(err)
+}
+
+func (s *PostgreSQLStore) GetIdentitiesByAccount(ctx context.Context, accountID int) ([]*domain.Identity, error) {
+	query := `
+    SELECT ` + identityColumns + `
+    FROM account_identities
+    WHERE account_id=$1
+    ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	identities := []*domain.Identity{}
+
+	for rows.Next() {
+		identity, err := scanIntoIdentity(rows)
+
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		identities = append(identities, identity)
+	}
+
+	return identities, translateError(rows.Err())
+}
+
+func scanIntoIdentity(row scanner) (*domain.Identity, error) {
+	identity := new(domain.Identity)
+
+	var email sql.NullString
+
+	err := row.Scan(
+		&identity.ID,
+		&identity.AccountID,
+		&identity.Provider,
+		&identity.Subject,
+		&email,
+		&identity.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	identity.Email = email.String
+
+	return identity, nil
+}
@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+	"github.com/grez-lucas/go-gym/pkg/metrics"
+)
+
+var tracer = otel.Tracer("github.com/grez-lucas/go-gym/pkg/storage")
+
+// InstrumentedStore wraps a Storage, tracing every call in a span and
+// recording how long it took, and how it went, in metrics.StorageDuration
+type InstrumentedStore struct {
+	next Storage
+}
+
+func NewInstrumentedStore(next Storage) *InstrumentedStore {
+	return &InstrumentedStore{next: next}
+}
+
+var _ Storage = (*InstrumentedStore)(nil)
+
+// The outcome label, one of the error kinds or `ok`
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotFound):
+		return "not_found"
+	case errors.Is(err, ErrConflict):
+		return "conflict"
+	case errors.Is(err, ErrConstraint):
+		return "constraint"
+	case errors.Is(err, ErrUnavailable):
+		return "unavailable"
+	}
+
+	return "error"
+}
+
+// Runs fn in a `storage.<method>` span. The SQL statements it runs show up as
+// child spans, see NewPostgreSQLStore.
+func observe(ctx context.Context, method string, fn func(context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "storage."+method,
+		trace.WithAttributes(
+			attribute.String("db.system.name", "postgresql"),
+			attribute.String("db.operation.name", method),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := fn(ctx)
+	result := outcome(err)
+
+	metrics.StorageDuration.WithLabelValues(method, result).Observe(time.Since(start).Seconds())
+
+	span.SetAttributes(attribute.String("gogym.storage.outcome", result))
+
+	// Lookups of missing rows are business as usual, not failures
+	if err != nil && result != "not_found" {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+func observeValue[T any](ctx context.Context, method string, fn func(context.Context) (T, error)) (T, error) {
+	var value T
+
+	err := observe(ctx, method, func(ctx context.Context) error {
+		var err error
+		value, err = fn(ctx)
+		return err
+	})
+
+	return value, err
+}
+
+func (s *InstrumentedStore) CreateGym(ctx context.Context, gym *domain.Gym) (*domain.Gym, error) {
+	return observeValue(ctx, "CreateGym", func(ctx context.Context) (*domain.Gym, error) { return s.next.CreateGym(ctx, gym) })
+}
+
+func (s *InstrumentedStore) DeleteGym(ctx context.Context, id int) error {
+	return observe(ctx, "DeleteGym", func(ctx context.Context) error { return s.next.DeleteGym(ctx, id) })
+}
+
+func (s *InstrumentedStore) UpdateGym(ctx context.Context, gym *domain.Gym) error {
+	return observe(ctx, "UpdateGym", func(ctx context.Context) error { return s.next.UpdateGym(ctx, gym) })
+}
+
+func (s *InstrumentedStore) GetGymByID(ctx context.Context, id int) (*domain.Gym, error) {
+	return observeValue(ctx, "GetGymByID", func(ctx context.Context) (*domain.Gym, error) { return s.next.GetGymByID(ctx, id) })
+}
+
+func (s *InstrumentedStore) GetGyms(ctx context.Context) ([]*domain.Gym, error) {
+	return observeValue(ctx, "GetGyms", func(ctx context.Context) ([]*domain.Gym, error) { return s.next.GetGyms(ctx) })
+}
+
+func (s *InstrumentedStore) GetLastGymDeletion(ctx context.Context) (time.Time, error) {
+	return observeValue(ctx, "GetLastGymDeletion", func(ctx context.Context) (time.Time, error) { return s.next.GetLastGymDeletion(ctx) })
+}
+
+func (s *InstrumentedStore) CreateRating(ctx context.Context, rating *domain.Rating) (*domain.Rating, error) {
+	return observeValue(ctx, "CreateRating", func(ctx context.Context) (*domain.Rating, error) { return s.next.CreateRating(ctx, rating) })
+}
+
+func (s *InstrumentedStore) GetAverageRating(ctx context.Context, gymID int) (float32, error) {
+	return observeValue(ctx, "GetAverageRating", func(ctx context.Context) (float32, error) { return s.next.GetAverageRating(ctx, gymID) })
+}
+
+func (s *InstrumentedStore) GetRatingsByAccount(ctx context.Context, accountID int) ([]*domain.Rating, error) {
+	return observeValue(ctx, "GetRatingsByAccount", func(ctx context.Context) ([]*domain.Rating, error) { return s.next.GetRatingsByAccount(ctx, accountID) })
+}
+
+func (s *InstrumentedStore) CreateAccount(ctx context.Context, account *domain.Account) (*domain.Account, error) {
+	return observeValue(ctx, "CreateAccount", func(ctx context.Context) (*domain.Account, error) { return s.next.CreateAccount(ctx, account) })
+}
+
+func (s *InstrumentedStore) GetAccounts(ctx context.Context) ([]*domain.Account, error) {
+	return observeValue(ctx, "GetAccounts", func(ctx context.Context) ([]*domain.Account, error) { return s.next.GetAccounts(ctx) })
+}
+
+func (s *InstrumentedStore) GetAccountByID(ctx context.Context, id int) (*domain.Account, error) {
+	return observeValue(ctx, "GetAccountByID", func(ctx context.Context) (*domain.Account, error) { return s.next.GetAccountByID(ctx, id) })
+}
+
+func (s *InstrumentedStore) GetAccountByUsername(ctx context.Context, username string) (*domain.Account, error) {
+	return observeValue(ctx, "GetAccountByUsername", func(ctx context.Context) (*domain.Account, error) { return s.next.GetAccountByUsername(ctx, username) })
+}
+
+func (s *InstrumentedStore) GetAccountByEmail(ctx context.Context, email string) (*domain.Account, error) {
+	return observeValue(ctx, "GetAccountByEmail", func(ctx context.Context) (*domain.Account, error) { return s.next.GetAccountByEmail(ctx, email) })
+}
+
+func (s *InstrumentedStore) VerifyAccountEmail(ctx context.Context, id int, email string) error {
+	return observe(ctx, "VerifyAccountEmail", func(ctx context.Context) error { return s.next.VerifyAccountEmail(ctx, id, email) })
+}
+
+func (s *InstrumentedStore) UpdateAccountUsername(ctx context.Context, id int, username string) error {
+	return observe(ctx, "UpdateAccountUsername", func(ctx context.Context) error { return s.next.UpdateAccountUsername(ctx, id, username) })
+}
+
+func (s *InstrumentedStore) DeleteAccount(ctx context.Context, id int) error {
+	return observe(ctx, "DeleteAccount", func(ctx context.Context) error { return s.next.DeleteAccount(ctx, id) })
+}
+
+func (s *InstrumentedStore) SetAccountRole(ctx context.Context, id int, role string) error {
+	return observe(ctx, "SetAccountRole", func(ctx context.Context) error { return s.next.SetAccountRole(ctx, id, role) })
+}
+
+func (s *InstrumentedStore) UpdateAccountPassword(ctx context.Context, id int, password string) error {
+	return observe(ctx, "UpdateAccountPassword", func(ctx context.Context) error { return s.next.UpdateAccountPassword(ctx, id, password) })
+}
+
+func (s *InstrumentedStore) CreateRefreshToken(ctx context.Context, token *domain.RefreshToken) (*domain.RefreshToken, error) {
+	return observeValue(ctx, "CreateRefreshToken", func(ctx context.Context) (*domain.RefreshToken, error) { return s.next.CreateRefreshToken(ctx, token) })
+}
+
+func (s *InstrumentedStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	return observeValue(ctx, "GetRefreshTokenByHash", func(ctx context.Context) (*domain.RefreshToken, error) {
+		return s.next.GetRefreshTokenByHash(ctx, hash)
+	})
+}
+
+func (s *InstrumentedStore) RotateRefreshToken(ctx context.Context, used *domain.RefreshToken, next *domain.RefreshToken) (*domain.RefreshToken, error) {
+	return observeValue(ctx, "RotateRefreshToken", func(ctx context.Context) (*domain.RefreshToken, error) {
+		return s.next.RotateRefreshToken(ctx, used, next)
+	})
+}
+
+func (s *InstrumentedStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return observe(ctx, "RevokeRefreshTokenFamily", func(ctx context.Context) error { return s.next.RevokeRefreshTokenFamily(ctx, familyID) })
+}
+
+func (s *InstrumentedStore) RevokeAccountRefreshTokens(ctx context.Context, accountID int) error {
+	return observe(ctx, "RevokeAccountRefreshTokens", func(ctx context.Context) error { return s.next.RevokeAccountRefreshTokens(ctx, accountID) })
+}
+
+func (s *InstrumentedStore) GetRefreshTokensByAccount(ctx context.Context, accountID int) ([]*domain.RefreshToken, error) {
+	return observeValue(ctx, "GetRefreshTokensByAccount", func(ctx context.Context) ([]*domain.RefreshToken, error) {
+		return s.next.GetRefreshTokensByAccount(ctx, accountID)
+	})
+}
+
+func (s *InstrumentedStore) GetLoginAttempts(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	return observeValue(ctx, "GetLoginAttempts", func(ctx context.Context) (*domain.LoginAttempts, error) { return s.next.GetLoginAttempts(ctx, key) })
+}
+
+func (s *InstrumentedStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*domain.LoginAttempts, error) {
+	return observeValue(ctx, "RecordLoginFailure", func(ctx context.Context) (*domain.LoginAttempts, error) {
+		return s.next.RecordLoginFailure(ctx, key, window)
+	})
+}
+
+func (s *InstrumentedStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	return observe(ctx, "LockLogin", func(ctx context.Context) error { return s.next.LockLogin(ctx, key, until) })
+}
+
+func (s *InstrumentedStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	return observe(ctx, "ResetLoginAttempts", func(ctx context.Context) error { return s.next.ResetLoginAttempts(ctx, key) })
+}
+
+func (s *InstrumentedStore) CreateAccountToken(ctx context.Context, token *domain.AccountToken) (*domain.AccountToken, error) {
+	return observeValue(ctx, "CreateAccountToken", func(ctx context.Context) (*domain.AccountToken, error) { return s.next.CreateAccountToken(ctx, token) })
+}
+
+func (s *InstrumentedStore) ConsumeAccountToken(ctx context.Context, hash string, purpose string) (*domain.AccountToken, error) {
+	return observeValue(ctx, "ConsumeAccountToken", func(ctx context.Context) (*domain.AccountToken, error) {
+		return s.next.ConsumeAccountToken(ctx, hash, purpose)
+	})
+}
+
+func (s *InstrumentedStore) ConsumeEmailVerificationToken(ctx context.Context, hash string) (*domain.AccountToken, error) {
+	return observeValue(ctx, "ConsumeEmailVerificationToken", func(ctx context.Context) (*domain.AccountToken, error) {
+		return s.next.ConsumeEmailVerificationToken(ctx, hash)
+	})
+}
+
+func (s *InstrumentedStore) DeleteAccountTokens(ctx context.Context, accountID int, purpose string) error {
+	return observe(ctx, "DeleteAccountTokens", func(ctx context.Context) error { return s.next.DeleteAccountTokens(ctx, accountID, purpose) })
+}
+
+func (s *InstrumentedStore) GetLatestAccountToken(ctx context.Context, accountID int, purpose string) (*domain.AccountToken, error) {
+	return observeValue(ctx, "GetLatestAccountToken", func(ctx context.Context) (*domain.AccountToken, error) {
+		return s.next.GetLatestAccountToken(ctx, accountID, purpose)
+	})
+}
+
+func (s *InstrumentedStore) GetAccountTokensByAccount(ctx context.Context, accountID int) ([]*domain.AccountToken, error) {
+	return observeValue(ctx, "GetAccountTokensByAccount", func(ctx context.Context) ([]*domain.AccountToken, error) {
+		return s.next.GetAccountTokensByAccount(ctx, accountID)
+	})
+}
+
+func (s *InstrumentedStore) CreateAPIKey(ctx context.Context, key *domain.APIKey) (*domain.APIKey, error) {
+	return observeValue(ctx, "CreateAPIKey", func(ctx context.Context) (*domain.APIKey, error) { return s.next.CreateAPIKey(ctx, key) })
+}
+
+func (s *InstrumentedStore) GetAPIKeys(ctx context.Context) ([]*domain.APIKey, error) {
+	return observeValue(ctx, "GetAPIKeys", func(ctx context.Context) ([]*domain.APIKey, error) { return s.next.GetAPIKeys(ctx) })
+}
+
+func (s *InstrumentedStore) GetAPIKeysByCreator(ctx context.Context, accountID int) ([]*domain.APIKey, error) {
+	return observeValue(ctx, "GetAPIKeysByCreator", func(ctx context.Context) ([]*domain.APIKey, error) { return s.next.GetAPIKeysByCreator(ctx, accountID) })
+}
+
+func (s *InstrumentedStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*domain.APIKey, error) {
+	return observeValue(ctx, "GetAPIKeyByPrefix", func(ctx context.Context) (*domain.APIKey, error) { return s.next.GetAPIKeyByPrefix(ctx, prefix) })
+}
+
+func (s *InstrumentedStore) RevokeAPIKey(ctx context.Context, id int) error {
+	return observe(ctx, "RevokeAPIKey", func(ctx context.Context) error { return s.next.RevokeAPIKey(ctx, id) })
+}
+
+func (s *InstrumentedStore) TouchAPIKey(ctx context.Context, id int) error {
+	return observe(ctx, "TouchAPIKey", func(ctx context.Context) error { return s.next.TouchAPIKey(ctx, id) })
+}
+
+func (s *InstrumentedStore) CreateIdentity(ctx context.Context, identity *domain.Identity) (*domain.Identity, error) {
+	return observeValue(ctx, "CreateIdentity", func(ctx context.Context) (*domain.Identity, error) { return s.next.CreateIdentity(ctx, identity) })
+}
+
+func (s *InstrumentedStore) GetIdentity(ctx context.Context, provider string, subject string) (*domain.Identity, error) {
+	return observeValue(ctx, "GetIdentity", func(ctx context.Context) (*domain.Identity, error) { return s.next.GetIdentity(ctx, provider, subject) })
+}
+
+func (s *InstrumentedStore) GetIdentitiesByAccount(ctx context.Context, accountID int) ([]*domain.Identity, error) {
+	return observeValue(ctx, "GetIdentitiesByAccount", func(ctx context.Context) ([]*domain.Identity, error) {
+		return s.next.GetIdentitiesByAccount(ctx, accountID)
+	})
+}
+
+func (s *InstrumentedStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	return observe(ctx, "SetTOTPSecret", func(ctx context.Context) error { return s.next.SetTOTPSecret(ctx, id, secret) })
+}
+
+func (s *InstrumentedStore) EnableTOTP(ctx context.Context, id int) error {
+	return observe(ctx, "EnableTOTP", func(ctx context.Context) error { return s.next.EnableTOTP(ctx, id) })
+}
+
+func (s *InstrumentedStore) DisableTOTP(ctx context.Context, id int) error {
+	return observe(ctx, "DisableTOTP", func(ctx context.Context) error { return s.next.DisableTOTP(ctx, id) })
+}
+
+func (s *InstrumentedStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	return observeValue(ctx, "UseTOTPCounter", func(ctx context.Context) (bool, error) { return s.next.UseTOTPCounter(ctx, id, counter) })
+}
+
+func (s *InstrumentedStore) CountRecoveryCodes(ctx context.Context, accountID int) (int, error) {
+	return observeValue(ctx, "CountRecoveryCodes", func(ctx context.Context) (int, error) { return s.next.CountRecoveryCodes(ctx, accountID) })
+}
+
+func (s *InstrumentedStore) ReplaceRecoveryCodes(ctx context.Context, accountID int, hashes []string) error {
+	return observe(ctx, "ReplaceRecoveryCodes", func(ctx context.Context) error { return s.next.ReplaceRecoveryCodes(ctx, accountID, hashes) })
+}
+
+func (s *InstrumentedStore) UseRecoveryCode(ctx context.Context, accountID int, hash string) (bool, error) {
+	return observeValue(ctx, "UseRecoveryCode", func(ctx context.Context) (bool, error) { return s.next.UseRecoveryCode(ctx, accountID, hash) })
+}
+
+func (s *InstrumentedStore) ClaimIdempotencyKey(ctx context.Context, key *domain.IdempotencyKey) (*domain.IdempotencyKey, error) {
+	return observeValue(ctx, "ClaimIdempotencyKey", func(ctx context.Context) (*domain.IdempotencyKey, error) { return s.next.ClaimIdempotencyKey(ctx, key) })
+}
+
+func (s *InstrumentedStore) GetIdempotencyKey(ctx context.Context, scope string, key string) (*domain.IdempotencyKey, error) {
+	return observeValue(ctx, "GetIdempotencyKey", func(ctx context.Context) (*domain.IdempotencyKey, error) {
+		return s.next.GetIdempotencyKey(ctx, scope, key)
+	})
+}
+
+func (s *InstrumentedStore) CompleteIdempotencyKey(ctx context.Context, id int, status int, contentType string, body []byte) error {
+	return observe(ctx, "CompleteIdempotencyKey", func(ctx context.Context) error {
+		return s.next.CompleteIdempotencyKey(ctx, id, status, contentType, body)
+	})
+}
+
+func (s *InstrumentedStore) DeleteIdempotencyKey(ctx context.Context, id int) error {
+	return observe(ctx, "DeleteIdempotencyKey", func(ctx context.Context) error { return s.next.DeleteIdempotencyKey(ctx, id) })
+}
+
+func (s *InstrumentedStore) DeleteIdempotencyKeysByScope(ctx context.Context, scope string) error {
+	return observe(ctx, "DeleteIdempotencyKeysByScope", func(ctx context.Context) error { return s.next.DeleteIdempotencyKeysByScope(ctx, scope) })
+}
+
+func (s *InstrumentedStore) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	return observeValue(ctx, "DeleteExpiredIdempotencyKeys", func(ctx context.Context) (int64, error) { return s.next.DeleteExpiredIdempotencyKeys(ctx) })
+}
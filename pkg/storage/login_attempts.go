@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+type LoginAttemptStorage interface {
+	GetLoginAttempts(context.Context, string) (*domain.LoginAttempts, error)
+	RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*domain.LoginAttempts, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(context.Context, string) error
+}
+
+func (s *PostgreSQLStore) CreateLoginAttemptsTable() error {
+	query := `
+    CREATE table if not exists login_attempts (
+      key VARCHAR(150) PRIMARY KEY,
+      failures INT NOT NULL DEFAULT 0,
+      last_failure_at TIMESTAMP NOT NULL,
+      locked_until TIMESTAMP
+  )`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+// Returns a zero record when there were no failures for the key
+func (s *PostgreSQLStore) GetLoginAttempts(ctx context.Context, key string) (*domain.LoginAttempts, error) {
+	query := `
+    SELECT key, failures, last_failure_at, locked_until
+    FROM login_attempts
+    WHERE key=$1`
+
+	attempts, err := scanIntoLoginAttempts(s.db.QueryRowContext(ctx, query, key))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return &domain.LoginAttempts{Key: key}, nil
+	}
+
+	return attempts, translateError(err)
+}
+
+// Counts a failure for the key. Failures older than `window` are forgotten
+// and the count starts over.
+func (s *PostgreSQLStore) RecordLoginFailure(ctx context.Context, key string, window time.Duration) (*domain.LoginAttempts, error) {
+	now := time.Now().UTC()
+
+	query := `
+    INSERT INTO login_attempts (key, failures, last_failure_at)
+    VALUES ($1, 1, $2)
+    ON CONFLICT (key) DO UPDATE SET
+      failures = CASE
+        WHEN login_attempts.last_failure_at < $3 THEN 1
+        ELSE login_attempts.failures + 1
+      END,
+      last_failure_at = $2
+    RETURNING key, failures, last_failure_at, locked_until`
+
+	return scanIntoLoginAttempts(s.db.QueryRowContext(ctx, query, key, now, now.Add(-window)))
+}
+
+func (s *PostgreSQLStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	query := `
+    UPDATE login_attempts
+    SET locked_until=$2
+    WHERE key=$1`
+
+	_, err := s.db.ExecContext(ctx, query, key, until)
+
+	return translateError(err)
+}
+
+func (s *PostgreSQLStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	query := `
+    DELETE FROM login_attempts
+    WHERE key=$1`
+
+	_, err := s.db.ExecContext(ctx, query, key)
+
+	return translateError(err)
+}
+
+func scanIntoLoginAttempts(row *sql.Row) (*domain.LoginAttempts, error) {
+	attempts := new(domain.LoginAttempts)
+
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&attempts.Key,
+		&attempts.Failures,
+		&attempts.LastFailureAt,
+		&lockedUntil,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if lockedUntil.Valid {
+		attempts.LockedUntil = &lockedUntil.Time
+	}
+
+	return attempts, nil
+}
@@ -0,0 +1,201 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/grez-lucas/go-gym/pkg/domain"
+)
+
+// Returned by RotateRefreshToken when the token was already used or revoked,
+// which means somebody is replaying it.
+var ErrRefreshTokenReused = errors.New("Refresh token already used")
+
+type RefreshTokenStorage interface {
+	CreateRefreshToken(context.Context, *domain.RefreshToken) (*domain.RefreshToken, error)
+	GetRefreshTokenByHash(context.Context, string) (*domain.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, used *domain.RefreshToken, next *domain.RefreshToken) (*domain.RefreshToken, error)
+	RevokeRefreshTokenFamily(context.Context, string) error
+	RevokeAccountRefreshTokens(context.Context, int) error
+	GetRefreshTokensByAccount(context.Context, int) ([]*domain.RefreshToken, error)
+}
+
+func (s *PostgreSQLStore) CreateRefreshTokensTable() error {
+	query := `
+    CREATE table if not exists refresh_tokens (
+      id SERIAL PRIMARY KEY,
+      account_id INT REFERENCES accounts(id) ON DELETE CASCADE NOT NULL,
+      family_id VARCHAR(64) NOT NULL,
+      token_hash VARCHAR(64) UNIQUE NOT NULL,
+      expires_at TIMESTAMP NOT NULL,
+      used_at TIMESTAMP,
+      revoked_at TIMESTAMP,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+  );
+    CREATE INDEX if not exists refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+    CREATE INDEX if not exists refresh_tokens_account_id_idx ON refresh_tokens (account_id)`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+const refreshTokenColumns = `id, account_id, family_id, token_hash, expires_at, used_at, revoked_at, created_at`
+
+func (s *PostgreSQLStore) CreateRefreshToken(ctx context.Context, t *domain.RefreshToken) (*domain.RefreshToken, error) {
+	query := `
+    INSERT INTO refresh_tokens (account_id, family_id, token_hash, expires_at, created_at)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING ` + refreshTokenColumns
+
+	row := s.db.QueryRowContext(ctx, query, t.AccountID, t.FamilyID, t.TokenHash, t.ExpiresAt, t.CreatedAt)
+
+	return scanIntoRefreshToken(row)
+}
+
+func (s *PostgreSQLStore) GetRefreshTokenByHash(ctx context.Context, hash string) (*domain.RefreshToken, error) {
+	query := `
+    SELECT ` + refreshTokenColumns + `
+    FROM refresh_tokens
+    WHERE token_hash=$1`
+
+	token, err := scanIntoRefreshToken(s.db.QueryRowContext(ctx, query, hash))
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, notFound("Refresh token")
+	}
+
+	return token, translateError(err)
+}
+
+// Marks `used` as consumed and stores `next` in a single transaction. The
+// update only matches a token that is still unused and not revoked, so two
+// concurrent refreshes with the same token can't both succeed.
+func (s *PostgreSQLStore) RotateRefreshToken(ctx context.Context, used *domain.RefreshToken, next *domain.RefreshToken) (*domain.RefreshToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+    UPDATE refresh_tokens
+    SET used_at=$2
+    WHERE id=$1 AND used_at IS NULL AND revoked_at IS NULL`,
+		used.ID, time.Now().UTC(),
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	affected, err := result.RowsAffected()
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if affected == 0 {
+		return nil, ErrRefreshTokenReused
+	}
+
+	row := tx.QueryRowContext(ctx, `
+    INSERT INTO refresh_tokens (account_id, family_id, token_hash, expires_at, created_at)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING `+refreshTokenColumns,
+		next.AccountID, next.FamilyID, next.TokenHash, next.ExpiresAt, next.CreatedAt,
+	)
+
+	created, err := scanIntoRefreshToken(row)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return created, translateError(tx.Commit())
+}
+
+func (s *PostgreSQLStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	query := `
+    UPDATE refresh_tokens
+    SET revoked_at=$2
+    WHERE family_id=$1 AND revoked_at IS NULL`
+
+	_, err := s.db.ExecContext(ctx, query, familyID, time.Now().UTC())
+
+	return translateError(err)
+}
+
+func (s *PostgreSQLStore) RevokeAccountRefreshTokens(ctx context.Context, accountID int) error {
+	query := `
+    UPDATE refresh_tokens
+    SET revoked_at=$2
+    WHERE account_id=$1 AND revoked_at IS NULL`
+
+	_, err := s.db.ExecContext(ctx, query, accountID, time.Now().UTC())
+
+	return translateError(err)
+}
+
+func (s *PostgreSQLStore) GetRefreshTokensByAccount(ctx context.Context, accountID int) ([]*domain.RefreshToken, error) {
+	query := `
+    SELECT ` + refreshTokenColumns + `
+    FROM refresh_tokens
+    WHERE account_id=$1
+    ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	tokens := []*domain.RefreshToken{}
+
+	for rows.Next() {
+		token, err := scanIntoRefreshToken(rows)
+
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, translateError(rows.Err())
+}
+
+func scanIntoRefreshToken(row scanner) (*domain.RefreshToken, error) {
+	token := new(domain.RefreshToken)
+
+	var usedAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&token.ID,
+		&token.AccountID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+		&token.CreatedAt,
+	)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return token, nil
+}
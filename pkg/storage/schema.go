@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// Bump whenever Init changes the schema. A replica seeing another version in
+// the database reports itself as not ready, see CheckSchemaVersion.
+const SchemaVersion = 4
+
+// A single row table holding the version of the newest Init that ran
+func (s *PostgreSQLStore) CreateSchemaVersionTable() error {
+	query := `
+    CREATE table if not exists schema_version (
+      id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+      version INT NOT NULL,
+      updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+  )`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+// Never moves the version back, so an old replica restarting during a rollout
+// doesn't undo the newer one
+func (s *PostgreSQLStore) recordSchemaVersion() error {
+	query := `
+    INSERT INTO schema_version (version) VALUES ($1)
+    ON CONFLICT (id) DO UPDATE
+    SET version = EXCLUDED.version, updated_at = CURRENT_TIMESTAMP
+    WHERE schema_version.version < EXCLUDED.version`
+
+	_, err := s.db.Exec(query, SchemaVersion)
+
+	return translateError(err)
+}
+
+func (s *PostgreSQLStore) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+
+	err := s.db.QueryRowContext(ctx, `SELECT version FROM schema_version`).Scan(&version)
+
+	return version, translateError(err)
+}
+
+// Fails when the database holds a schema other than the one this build
+// creates
+func (s *PostgreSQLStore) CheckSchemaVersion(ctx context.Context) error {
+	version, err := s.GetSchemaVersion(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if version != SchemaVersion {
+		return fmt.Errorf("Database schema is at version %d, this build expects %d", version, SchemaVersion)
+	}
+
+	return nil
+}
+
+func (s *PostgreSQLStore) Ping(ctx context.Context) error {
+	return translateError(s.db.PingContext(ctx))
+}
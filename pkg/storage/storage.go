@@ -1,51 +1,86 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/grez-lucas/go-gym/pkg/config"
 	"github.com/grez-lucas/go-gym/pkg/domain"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // This module is responsible for DB connections, and being DB agnostic!
 
 type Storage interface {
-	CreateGym(*domain.Gym) (*domain.Gym, error)
-	DeleteGym(int) error
-	UpdateGym(*domain.Gym) error
-	GetGymByID(int) (*domain.Gym, error)
-	GetGyms() ([]*domain.Gym, error)
-	CreateRating(*domain.Rating) (*domain.Rating, error)
-	GetAverageRating(int) (float32, error)
-	CreateAccount(*domain.Account) (*domain.Account, error)
-	GetAccounts() ([]*domain.Account, error)
-	GetAccountByID(int) (*domain.Account, error)
-	GetAccountByUsername(string) (*domain.Account, error)
+	CreateGym(context.Context, *domain.Gym) (*domain.Gym, error)
+	DeleteGym(context.Context, int) error
+	UpdateGym(context.Context, *domain.Gym) error
+	GetGymByID(context.Context, int) (*domain.Gym, error)
+	GetGyms(context.Context) ([]*domain.Gym, error)
+	GetLastGymDeletion(context.Context) (time.Time, error)
+	CreateRating(context.Context, *domain.Rating) (*domain.Rating, error)
+	GetAverageRating(context.Context, int) (float32, error)
+	GetRatingsByAccount(context.Context, int) ([]*domain.Rating, error)
+	CreateAccount(context.Context, *domain.Account) (*domain.Account, error)
+	GetAccounts(context.Context) ([]*domain.Account, error)
+	GetAccountByID(context.Context, int) (*domain.Account, error)
+	GetAccountByUsername(context.Context, string) (*domain.Account, error)
+	GetAccountByEmail(context.Context, string) (*domain.Account, error)
+	VerifyAccountEmail(context.Context, int, string) error
+	UpdateAccountUsername(context.Context, int, string) error
+	DeleteAccount(context.Context, int) error
+	SetAccountRole(context.Context, int, string) error
+	UpdateAccountPassword(context.Context, int, string) error
+	RefreshTokenStorage
+	LoginAttemptStorage
+	AccountTokenStorage
+	APIKeyStorage
+	IdentityStorage
+	TOTPStorage
+	IdempotencyKeyStorage
 }
 
 type PostgreSQLStore struct {
 	db *sql.DB
 }
 
-func NewPostgreSQLStore() (*PostgreSQLStore, error) {
-
-	config := config.LoadConfig()
+func NewPostgreSQLStore(config *config.Config) (*PostgreSQLStore, error) {
 
 	connStr := config.PostgreSQLConnStr()
-	db, err := sql.Open("postgres", connStr)
+
+	// Every statement gets its own span, named after the SQL command, under
+	// the span of the Storage method that ran it
+	db, err := otelsql.Open("postgres", connStr,
+		otelsql.WithAttributes(attribute.String("db.system.name", "postgresql")),
+		otelsql.WithSpanNameFormatter(sqlSpanName),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
+	db.SetMaxOpenConns(config.DatabaseMaxOpenConns)
+	db.SetMaxIdleConns(config.DatabaseMaxIdleConns)
+	db.SetConnMaxLifetime(config.DatabaseConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.DatabaseConnMaxIdleTime)
+
 	// Ping the DB to healthcheck it
 	if err := db.Ping(); err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return &PostgreSQLStore{
@@ -53,23 +88,85 @@ func NewPostgreSQLStore() (*PostgreSQLStore, error) {
 	}, nil
 }
 
+// Like `SELECT` or `UPDATE`, falling back to the driver method for calls
+// without a statement
+func sqlSpanName(_ context.Context, method otelsql.Method, query string) string {
+	command, _, _ := strings.Cut(strings.TrimSpace(query), " ")
+
+	if command == "" {
+		return string(method)
+	}
+
+	return strings.ToUpper(command)
+}
+
+// The connection pool, for its stats
+func (s *PostgreSQLStore) DB() *sql.DB {
+	return s.db
+}
+
+// Closes the connection pool, waiting for running queries to finish
+func (s *PostgreSQLStore) Close() error {
+	return s.db.Close()
+}
+
 func (s *PostgreSQLStore) Init() error {
 
 	err := s.CreateGymsTable()
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	if err := s.CreateRatingsTable(); err != nil {
-		return err
+		return translateError(err)
+	}
+
+	if err := s.CreateGymDeletionsTable(); err != nil {
+		return translateError(err)
 	}
 
 	if err := s.CreateAccountsTable(); err != nil {
-		return err
+		return translateError(err)
 	}
 
-	return nil
+	if err := s.MigrateRatingsTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateRefreshTokensTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateLoginAttemptsTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateAccountTokensTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateAPIKeysTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateIdentitiesTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateRecoveryCodesTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateIdempotencyKeysTable(); err != nil {
+		return translateError(err)
+	}
+
+	if err := s.CreateSchemaVersionTable(); err != nil {
+		return translateError(err)
+	}
+
+	return s.recordSchemaVersion()
 }
 
 func (s *PostgreSQLStore) CreateGymsTable() error {
@@ -84,7 +181,7 @@ func (s *PostgreSQLStore) CreateGymsTable() error {
 	_, err := s.db.Query(query)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	return nil
@@ -104,13 +201,43 @@ func (s *PostgreSQLStore) CreateRatingsTable() error {
 	_, err := s.db.Query(query)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	return nil
 
 }
 
+// A single row table holding when a gym was last deleted. Deleted gyms leave
+// nothing behind in `gyms`, this is how the list knows it changed.
+func (s *PostgreSQLStore) CreateGymDeletionsTable() error {
+	query := `
+    CREATE table if not exists gym_deletions (
+      id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+      deleted_at TIMESTAMP NOT NULL
+  )`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+// Ratings point at the account that wrote them, so they can be anonymized
+// when it's deleted. Runs after the accounts table exists and links ratings
+// written before the column was added by their username.
+func (s *PostgreSQLStore) MigrateRatingsTable() error {
+	query := `
+    ALTER TABLE ratings ADD COLUMN IF NOT EXISTS account_id INT REFERENCES accounts(id) ON DELETE SET NULL;
+    CREATE INDEX if not exists ratings_account_id_idx ON ratings (account_id);
+    UPDATE ratings SET account_id = accounts.id
+    FROM accounts
+    WHERE ratings.account_id IS NULL AND ratings.user_name = accounts.username`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
 func (s *PostgreSQLStore) CreateAccountsTable() error {
 	query := `
     CREATE table if not exists accounts (
@@ -119,18 +246,31 @@ func (s *PostgreSQLStore) CreateAccountsTable() error {
       password VARCHAR(255) NOT NULL, 
       created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
       updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-  )`
-
-	_, err := s.db.Query(query)
+  );
+    ALTER TABLE accounts ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+    ALTER TABLE accounts ADD COLUMN IF NOT EXISTS email VARCHAR(254);
+    ALTER TABLE accounts ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+    CREATE UNIQUE INDEX if not exists accounts_email_idx ON accounts (LOWER(email));
+    -- Signups used to store the address before it was verified, it's still
+    -- in their verification token
+    UPDATE accounts SET email = NULL WHERE email IS NOT NULL AND email_verified_at IS NULL;
+    ALTER TABLE accounts ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+    ALTER TABLE accounts ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMP;
+    ALTER TABLE accounts ADD COLUMN IF NOT EXISTS totp_last_counter BIGINT`
+
+	_, err := s.db.Exec(query)
 
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	return nil
 }
 
-func (s *PostgreSQLStore) CreateGym(gym *domain.Gym) (*domain.Gym, error) {
+// Columns in the order scanIntoAccount expects them
+const accountColumns = `id, username, password, role, email, email_verified_at, totp_secret, totp_enabled_at, created_at, updated_at`
+
+func (s *PostgreSQLStore) CreateGym(ctx context.Context, gym *domain.Gym) (*domain.Gym, error) {
 	// To avoid SQL injection, avoid using your custom Sprintf format!
 	// Instead use something like this
 	query := `
@@ -138,11 +278,11 @@ func (s *PostgreSQLStore) CreateGym(gym *domain.Gym) (*domain.Gym, error) {
     values ($1, $2, $3, $4)
     RETURNING id, name, description, created_at, updated_at`
 
-	rows, err := s.db.Query(query, gym.Name, gym.Description, gym.CreatedAt, gym.UpdatedAt)
+	rows, err := s.db.QueryContext(ctx, query, gym.Name, gym.Description, gym.CreatedAt, gym.UpdatedAt)
 
 	if err != nil {
-		log.Println("Error creating Gym: ", err.Error())
-		return nil, err
+		slog.Error("Error creating gym", "error", err)
+		return nil, translateError(err)
 	}
 
 	for rows.Next() {
@@ -152,104 +292,191 @@ func (s *PostgreSQLStore) CreateGym(gym *domain.Gym) (*domain.Gym, error) {
 	return nil, fmt.Errorf("Error creating Gym")
 }
 
-func (s *PostgreSQLStore) DeleteGym(id int) error {
+// Deletes the gym, its ratings with it, and records when for
+// GetLastGymDeletion
+func (s *PostgreSQLStore) DeleteGym(ctx context.Context, id int) error {
 
-	query := `
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
     DELETE FROM gyms
     WHERE id=$1
-  `
+  `, id)
+
+	if err != nil {
+		return translateError(err)
+	}
 
-	_, err := s.db.Exec(query, id)
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Gym with ID %d", id)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+    INSERT INTO gym_deletions (deleted_at) VALUES ($1)
+    ON CONFLICT (id) DO UPDATE
+    SET deleted_at = GREATEST(gym_deletions.deleted_at, EXCLUDED.deleted_at)
+  `, time.Now().UTC())
 
 	if err != nil {
-		return err
+		return translateError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return translateError(err)
 	}
 
-	log.Printf("Gym with id %d successfully deleted\n", id)
+	slog.Info("Gym deleted", "gym_id", id)
 
 	return nil
 }
 
-func (s *PostgreSQLStore) UpdateGym(*domain.Gym) error {
+// When a gym was last deleted, the zero time if none ever was
+func (s *PostgreSQLStore) GetLastGymDeletion(ctx context.Context) (time.Time, error) {
+	var deletedAt time.Time
+
+	err := s.db.QueryRowContext(ctx, `SELECT deleted_at FROM gym_deletions`).Scan(&deletedAt)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+
+	return deletedAt, translateError(err)
+}
+
+// Saves the gym's name and description, its ratings are left alone
+func (s *PostgreSQLStore) UpdateGym(ctx context.Context, gym *domain.Gym) error {
+	result, err := s.db.ExecContext(ctx, `
+    UPDATE gyms
+    SET name=$2, description=$3, updated_at=$4
+    WHERE id=$1
+  `, gym.ID, gym.Name, gym.Description, gym.UpdatedAt)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Gym with ID %d", gym.ID)
+	}
+
 	return nil
 }
 
-func (s *PostgreSQLStore) GetGymByID(id int) (*domain.Gym, error) {
+// Gyms along with their rating aggregates, which the API shows and derives
+// Last-Modified from
+const gymWithRatingsSelect = `
+    SELECT g.id, g.name, g.description, g.created_at, g.updated_at,
+      COALESCE(AVG(r.rating), 0), COUNT(r.id), MAX(r.updated_at)
+    FROM gyms g
+    LEFT JOIN ratings r ON r.gym_id = g.id`
 
-	query := `
-    SELECT * from gyms
-    WHERE  id=$1
+func (s *PostgreSQLStore) GetGymByID(ctx context.Context, id int) (*domain.Gym, error) {
+
+	query := gymWithRatingsSelect + `
+    WHERE g.id=$1
+    GROUP BY g.id
   `
 
-	rows, err := s.db.Query(query, id)
+	rows, err := s.db.QueryContext(ctx, query, id)
 
 	if err != nil {
-		log.Printf("Error getting gym with ID: %d - %s\n", id, err.Error())
-		return nil, err
+		slog.Error("Error fetching gym", "gym_id", id, "error", err)
+		return nil, translateError(err)
 	}
 
+	defer rows.Close()
+
 	for rows.Next() {
-		return scanIntoGym(rows)
+		return scanIntoGymWithRatings(rows)
 	}
 
-	return nil, fmt.Errorf("Gym with ID %d not found", id)
+	return nil, notFound("Gym with ID %d", id)
 }
 
-func (s *PostgreSQLStore) GetGyms() ([]*domain.Gym, error) {
+func (s *PostgreSQLStore) GetGyms(ctx context.Context) ([]*domain.Gym, error) {
 
 	gyms := []*domain.Gym{}
 
-	query := `SELECT * FROM gyms`
+	query := gymWithRatingsSelect + `
+    GROUP BY g.id
+    ORDER BY g.id`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 
 	if err != nil {
-		log.Printf("Error fetching gyms: %s\n", err.Error())
-		return nil, err
+		slog.Error("Error fetching gyms", "error", err)
+		return nil, translateError(err)
 	}
 
+	defer rows.Close()
+
 	// For each row, save gym to memory and check for errors
 	for rows.Next() {
-		gym, err := scanIntoGym(rows)
+		gym, err := scanIntoGymWithRatings(rows)
 
 		if err != nil {
-			return nil, err
+			return nil, translateError(err)
 		}
 
-		log.Println("Getting average rating for gym with ID:", gym.ID)
-		avgRating, err := s.GetAverageRating(gym.ID)
-
-		if err != nil {
-			return nil, err
-		}
-
-		gym.Rating = avgRating
-
 		gyms = append(gyms, gym)
 	}
 
-	return gyms, nil
+	return gyms, translateError(rows.Err())
 }
 
-func (s *PostgreSQLStore) CreateRating(r *domain.Rating) (*domain.Rating, error) {
+func (s *PostgreSQLStore) CreateRating(ctx context.Context, r *domain.Rating) (*domain.Rating, error) {
 	query := `
-    INSERT INTO ratings (gym_id, rating, user_name, review, created_at, updated_at)
-    values ($1, $2, $3, $4, $5, $6)
-    RETURNING id, gym_id, rating, user_name, review, created_at, updated_at
-  `
+    INSERT INTO ratings (gym_id, account_id, rating, user_name, review, created_at, updated_at)
+    values ($1, NULLIF($2, 0), $3, $4, $5, $6, $7)
+    RETURNING ` + ratingColumns
 
-	row := s.db.QueryRow(query, r.GymID, r.Rating, r.UserName, r.Review, r.CreatedAt, r.UpdatedAt)
+	row := s.db.QueryRowContext(ctx, query, r.GymID, r.AccountID, r.Rating, r.UserName, r.Review, r.CreatedAt, r.UpdatedAt)
 
 	return scanIntoRating(row)
 }
 
-func (s *PostgreSQLStore) CreateAccount(a *domain.Account) (*domain.Account, error) {
+const ratingColumns = `id, gym_id, account_id, rating, user_name, review, created_at, updated_at`
+
+func (s *PostgreSQLStore) GetRatingsByAccount(ctx context.Context, accountID int) ([]*domain.Rating, error) {
+	query := `
+    SELECT ` + ratingColumns + `
+    FROM ratings
+    WHERE account_id=$1
+    ORDER BY id`
+
+	rows, err := s.db.QueryContext(ctx, query, accountID)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	ratings := []*domain.Rating{}
+
+	for rows.Next() {
+		rating, err := scanIntoRating(rows)
+
+		if err != nil {
+			return nil, translateError(err)
+		}
+
+		ratings = append(ratings, rating)
+	}
+
+	return ratings, translateError(rows.Err())
+}
+
+func (s *PostgreSQLStore) CreateAccount(ctx context.Context, a *domain.Account) (*domain.Account, error) {
 
 	query := `
     INSERT INTO accounts (username, password, created_at, updated_at)
     VALUES ($1, $2, $3, $4)
-    RETURNING id, username, password, created_at, updated_at
-  `
+    RETURNING ` + accountColumns
 
 	hashedPassword, err := hashPassword(a.Password)
 
@@ -257,10 +484,10 @@ func (s *PostgreSQLStore) CreateAccount(a *domain.Account) (*domain.Account, err
 		return nil, fmt.Errorf("Error hashing password: `%s`", err.Error())
 	}
 
-	rows, err := s.db.Query(query, a.UserName, hashedPassword, a.CreatedAt, a.UpdatedAt)
+	rows, err := s.db.QueryContext(ctx, query, a.UserName, hashedPassword, a.CreatedAt, a.UpdatedAt)
 
 	if err != nil {
-		return nil, fmt.Errorf("DB error when creating account: `%s`", err.Error())
+		return nil, translateError(err)
 	}
 
 	for rows.Next() {
@@ -271,14 +498,14 @@ func (s *PostgreSQLStore) CreateAccount(a *domain.Account) (*domain.Account, err
 
 }
 
-func (s *PostgreSQLStore) GetAccounts() ([]*domain.Account, error) {
+func (s *PostgreSQLStore) GetAccounts(ctx context.Context) ([]*domain.Account, error) {
 
-	query := `SELECT * from accounts`
+	query := `SELECT ` + accountColumns + ` from accounts`
 
-	rows, err := s.db.Query(query)
+	rows, err := s.db.QueryContext(ctx, query)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	accounts := []*domain.Account{}
@@ -287,7 +514,7 @@ func (s *PostgreSQLStore) GetAccounts() ([]*domain.Account, error) {
 		account, err := scanIntoAccount(rows)
 
 		if err != nil {
-			return nil, err
+			return nil, translateError(err)
 		}
 
 		accounts = append(accounts, account)
@@ -296,28 +523,51 @@ func (s *PostgreSQLStore) GetAccounts() ([]*domain.Account, error) {
 	return accounts, nil
 }
 
-func (s *PostgreSQLStore) GetAccountByUsername(username string) (*domain.Account, error) {
+func (s *PostgreSQLStore) GetAccountByUsername(ctx context.Context, username string) (*domain.Account, error) {
 
 	query := `
-  SELECT *
+  SELECT ` + accountColumns + `
   FROM accounts
   WHERE username=$1
   `
 
-	rows, err := s.db.Query(query, username)
+	rows, err := s.db.QueryContext(ctx, query, username)
 
 	if err != nil {
-		return nil, fmt.Errorf("DB error when fetching account: `%v`", err.Error())
+		return nil, translateError(err)
 	}
 
 	for rows.Next() {
 		return scanIntoAccount(rows)
 	}
 
-	return nil, fmt.Errorf("DB error: Account not found")
+	return nil, notFound("Account")
 }
 
-func (s *PostgreSQLStore) GetAverageRating(id int) (float32, error) {
+// Emails are matched case insensitively
+func (s *PostgreSQLStore) GetAccountByEmail(ctx context.Context, email string) (*domain.Account, error) {
+
+	query := `
+  SELECT ` + accountColumns + `
+  FROM accounts
+  WHERE LOWER(email)=LOWER($1)
+  `
+
+	rows, err := s.db.QueryContext(ctx, query, email)
+
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		return scanIntoAccount(rows)
+	}
+
+	return nil, notFound("Account")
+}
+
+func (s *PostgreSQLStore) GetAverageRating(ctx context.Context, id int) (float32, error) {
 
 	query := `
     SELECT COALESCE( AVG(rating), 0 ) AS average_rating
@@ -326,34 +576,183 @@ func (s *PostgreSQLStore) GetAverageRating(id int) (float32, error) {
   `
 
 	var avgRating float32
-	if err := s.db.QueryRow(query, id).Scan(&avgRating); err != nil {
-		log.Printf("Error calculating average rating: %s", err.Error())
-		return avgRating, err
+	if err := s.db.QueryRowContext(ctx, query, id).Scan(&avgRating); err != nil {
+		slog.Error("Error calculating average rating", "gym_id", id, "error", err)
+		return avgRating, translateError(err)
 	}
 
 	return avgRating, nil
 }
 
-func (s *PostgreSQLStore) GetAccountByID(id int) (*domain.Account, error) {
+func (s *PostgreSQLStore) GetAccountByID(ctx context.Context, id int) (*domain.Account, error) {
 
 	query := `
-    SELECT *
+    SELECT ` + accountColumns + `
     FROM accounts
     WHERE id=$1
   `
 
-	rows, err := s.db.Query(query, id)
+	rows, err := s.db.QueryContext(ctx, query, id)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	for rows.Next() {
 		return scanIntoAccount(rows)
 	}
 
-	return nil, fmt.Errorf("DB error: Account not found")
+	return nil, notFound("Account")
+
+}
+
+func (s *PostgreSQLStore) SetAccountRole(ctx context.Context, id int, role string) error {
+
+	query := `
+    UPDATE accounts
+    SET role=$2, updated_at=$3
+    WHERE id=$1
+  `
+
+	result, err := s.db.ExecContext(ctx, query, id, role, time.Now().UTC())
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Account")
+	}
 
+	return nil
+}
+
+// Takes the plain password and stores its hash
+func (s *PostgreSQLStore) UpdateAccountPassword(ctx context.Context, id int, password string) error {
+
+	hashedPassword, err := hashPassword(password)
+
+	if err != nil {
+		return fmt.Errorf("Error hashing password: `%s`", err.Error())
+	}
+
+	query := `
+    UPDATE accounts
+    SET password=$2, updated_at=$3
+    WHERE id=$1
+  `
+
+	result, err := s.db.ExecContext(ctx, query, id, hashedPassword, time.Now().UTC())
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Account")
+	}
+
+	return nil
+}
+
+// Sets the email of the account and marks it as verified
+func (s *PostgreSQLStore) VerifyAccountEmail(ctx context.Context, id int, email string) error {
+
+	now := time.Now().UTC()
+
+	query := `
+    UPDATE accounts
+    SET email=$2, email_verified_at=$3, updated_at=$3
+    WHERE id=$1
+  `
+
+	result, err := s.db.ExecContext(ctx, query, id, email, now)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Account")
+	}
+
+	return nil
+}
+
+// Renames the account, along with the name shown on its ratings
+func (s *PostgreSQLStore) UpdateAccountUsername(ctx context.Context, id int, username string) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+
+	result, err := tx.ExecContext(ctx, `
+    UPDATE accounts
+    SET username=$2, updated_at=$3
+    WHERE id=$1
+  `, id, username, now)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Account")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+    UPDATE ratings
+    SET user_name=$2
+    WHERE account_id=$1
+  `, id, username)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// Deletes the account and everything that only makes sense with it. Its
+// ratings stay, since they count towards gym averages, but lose any link to
+// the account.
+func (s *PostgreSQLStore) DeleteAccount(ctx context.Context, id int) error {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+    UPDATE ratings
+    SET account_id=NULL, user_name=$2, updated_at=$3
+    WHERE account_id=$1
+  `, id, domain.DeletedUserName, time.Now().UTC())
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	// Identities, tokens and recovery codes go with it through ON DELETE
+	// CASCADE, API keys it created stay but lose their creator
+	result, err := tx.ExecContext(ctx, `DELETE FROM accounts WHERE id=$1`, id)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return notFound("Account")
+	}
+
+	return translateError(tx.Commit())
 }
 
 func scanIntoGym(row *sql.Rows) (*domain.Gym, error) {
@@ -368,19 +767,50 @@ func scanIntoGym(row *sql.Rows) (*domain.Gym, error) {
 	)
 
 	if err != nil {
-		log.Printf("SQL Error when scanning Gym: %s", err.Error())
-		return nil, err
+		slog.Error("Error scanning gym", "error", err)
+		return nil, translateError(err)
+	}
+
+	return gym, nil
+}
+
+func scanIntoGymWithRatings(row scanner) (*domain.Gym, error) {
+	gym := new(domain.Gym)
+
+	var lastRatedAt sql.NullTime
+
+	err := row.Scan(
+		&gym.ID,
+		&gym.Name,
+		&gym.Description,
+		&gym.CreatedAt,
+		&gym.UpdatedAt,
+		&gym.Rating,
+		&gym.RatingCount,
+		&lastRatedAt,
+	)
+
+	if err != nil {
+		slog.Error("Error scanning gym", "error", err)
+		return nil, translateError(err)
+	}
+
+	if lastRatedAt.Valid {
+		gym.LastRatedAt = &lastRatedAt.Time
 	}
 
 	return gym, nil
 }
 
-func scanIntoRating(row *sql.Row) (*domain.Rating, error) {
+func scanIntoRating(row scanner) (*domain.Rating, error) {
 	createdRating := new(domain.Rating)
 
+	var accountID sql.NullInt64
+
 	err := row.Scan(
 		&createdRating.ID,
 		&createdRating.GymID,
+		&accountID,
 		&createdRating.Rating,
 		&createdRating.UserName,
 		&createdRating.Review,
@@ -389,10 +819,12 @@ func scanIntoRating(row *sql.Row) (*domain.Rating, error) {
 	)
 
 	if err != nil {
-		log.Printf("SQL Error when scanning Rating: %s", err.Error())
-		return nil, err
+		slog.Error("Error scanning rating", "error", err)
+		return nil, translateError(err)
 	}
 
+	createdRating.AccountID = int(accountID.Int64)
+
 	return createdRating, nil
 
 }
@@ -400,32 +832,62 @@ func scanIntoRating(row *sql.Row) (*domain.Rating, error) {
 func scanIntoAccount(rows *sql.Rows) (*domain.Account, error) {
 	createdAccount := new(domain.Account)
 
+	var email, totpSecret sql.NullString
+	var emailVerifiedAt, totpEnabledAt sql.NullTime
+
 	err := rows.Scan(
 		&createdAccount.ID,
 		&createdAccount.UserName,
 		&createdAccount.Password,
+		&createdAccount.Role,
+		&email,
+		&emailVerifiedAt,
+		&totpSecret,
+		&totpEnabledAt,
 		&createdAccount.CreatedAt,
 		&createdAccount.UpdatedAt,
 	)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
+	}
+
+	createdAccount.Email = email.String
+
+	if emailVerifiedAt.Valid {
+		createdAccount.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+
+	createdAccount.TOTPSecret = totpSecret.String
+
+	if totpEnabledAt.Valid {
+		createdAccount.TOTPEnabledAt = &totpEnabledAt.Time
 	}
 
 	return createdAccount, nil
 
 }
 
+// The bcrypt cost of password hashes, tests lower it to stay fast
+var PasswordHashCost = 14
+
 func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), PasswordHashCost)
 
 	if err != nil {
-		return "", err
+		return "", translateError(err)
 	}
 
 	return string(bytes), nil
 }
 
+// A hash with the same cost as real ones, to compare against when an account
+// doesn't exist so the lookup takes as long as for an existing one. It's as
+// slow to make as any other, so make it once at startup.
+func NewDummyPasswordHash() (string, error) {
+	return hashPassword("go-gym-dummy-password")
+}
+
 func VerifyHashedPassword(password string, hashedPass string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPass), []byte(password))
 
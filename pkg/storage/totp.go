@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+type TOTPStorage interface {
+	SetTOTPSecret(context.Context, int, string) error
+	EnableTOTP(context.Context, int) error
+	DisableTOTP(context.Context, int) error
+	UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, accountID int, hashes []string) error
+	UseRecoveryCode(ctx context.Context, accountID int, hash string) (bool, error)
+	CountRecoveryCodes(ctx context.Context, accountID int) (int, error)
+}
+
+func (s *PostgreSQLStore) CreateRecoveryCodesTable() error {
+	query := `
+    CREATE table if not exists recovery_codes (
+      id SERIAL PRIMARY KEY,
+      account_id INT REFERENCES accounts(id) ON DELETE CASCADE NOT NULL,
+      code_hash VARCHAR(64) NOT NULL,
+      used_at TIMESTAMP,
+      created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+      UNIQUE (account_id, code_hash)
+  )`
+
+	_, err := s.db.Exec(query)
+
+	return translateError(err)
+}
+
+// Stores a secret for an enrollment that still needs to be confirmed. 2FA
+// stays off until EnableTOTP.
+func (s *PostgreSQLStore) SetTOTPSecret(ctx context.Context, id int, secret string) error {
+	query := `
+    UPDATE accounts
+    SET totp_secret=$2, totp_enabled_at=NULL, totp_last_counter=NULL, updated_at=$3
+    WHERE id=$1`
+
+	_, err := s.db.ExecContext(ctx, query, id, secret, time.Now().UTC())
+
+	return translateError(err)
+}
+
+func (s *PostgreSQLStore) EnableTOTP(ctx context.Context, id int) error {
+	now := time.Now().UTC()
+
+	query := `
+    UPDATE accounts
+    SET totp_enabled_at=$2, updated_at=$2
+    WHERE id=$1 AND totp_secret IS NOT NULL`
+
+	_, err := s.db.ExecContext(ctx, query, id, now)
+
+	return translateError(err)
+}
+
+// Turns 2FA off and throws away the secret and recovery codes
+func (s *PostgreSQLStore) DisableTOTP(ctx context.Context, id int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+    UPDATE accounts
+    SET totp_secret=NULL, totp_enabled_at=NULL, totp_last_counter=NULL, updated_at=$2
+    WHERE id=$1`,
+		id, time.Now().UTC(),
+	)
+
+	if err != nil {
+		return translateError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE account_id=$1`, id); err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// Records the time step of an accepted code. Returns false when that step
+// or a later one was already used, i.e. the code is being replayed.
+func (s *PostgreSQLStore) UseTOTPCounter(ctx context.Context, id int, counter int64) (bool, error) {
+	query := `
+    UPDATE accounts
+    SET totp_last_counter=$2
+    WHERE id=$1 AND (totp_last_counter IS NULL OR totp_last_counter < $2)`
+
+	result, err := s.db.ExecContext(ctx, query, id, counter)
+
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	affected, err := result.RowsAffected()
+
+	return affected == 1, translateError(err)
+}
+
+func (s *PostgreSQLStore) ReplaceRecoveryCodes(ctx context.Context, accountID int, hashes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE account_id=$1`, accountID); err != nil {
+		return translateError(err)
+	}
+
+	now := time.Now().UTC()
+
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, `
+    INSERT INTO recovery_codes (account_id, code_hash, created_at)
+    VALUES ($1, $2, $3)`,
+			accountID, hash, now,
+		)
+
+		if err != nil {
+			return translateError(err)
+		}
+	}
+
+	return translateError(tx.Commit())
+}
+
+// Marks the recovery code as used, false when it doesn't exist or was used
+func (s *PostgreSQLStore) UseRecoveryCode(ctx context.Context, accountID int, hash string) (bool, error) {
+	query := `
+    UPDATE recovery_codes
+    SET used_at=$3
+    WHERE account_id=$1 AND code_hash=$2 AND used_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, accountID, hash, time.Now().UTC())
+
+	if err != nil {
+		return false, translateError(err)
+	}
+
+	affected, err := result.RowsAffected()
+
+	return affected == 1, translateError(err)
+}
+
+// How many recovery codes the account has left
+func (s *PostgreSQLStore) CountRecoveryCodes(ctx context.Context, accountID int) (int, error) {
+	var count int
+
+	query := `SELECT COUNT(*) FROM recovery_codes WHERE account_id=$1 AND used_at IS NULL`
+
+	err := s.db.QueryRowContext(ctx, query, accountID).Scan(&count)
+
+	return count, translateError(err)
+}
@@ -0,0 +1,133 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 seed of RFC 6238 appendix B, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The appendix lists 8 digit codes, ours are their last 6 digits
+func TestCodeRFC6238Vectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+
+		if err != nil {
+			t.Fatalf("Error generating code: %v", err)
+		}
+
+		if got != tt.want {
+			t.Errorf("At %d got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+// Secrets are typed by hand, so lowercase has to work too
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, _ := Code(rfcSecret, 1)
+	lower, err := Code(strings.ToLower(rfcSecret), 1)
+
+	if err != nil || lower != upper {
+		t.Errorf("Got %s (%v) for the lowercase secret, want %s", lower, err, upper)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Invalid secret was accepted")
+	}
+}
+
+// A code is valid for its own step and the ones right next to it, and
+// Validate reports the step it was generated for
+func TestValidateSkew(t *testing.T) {
+	step := int64(Period.Seconds())
+	counter := int64(1_000_000)
+	code, _ := Code(rfcSecret, counter)
+
+	start := time.Unix(counter*step, 0)
+	end := time.Unix((counter+1)*step-1, 0)
+
+	tests := []struct {
+		name string
+		at   time.Time
+		ok   bool
+	}{
+		{"start of its step", start, true},
+		{"end of its step", end, true},
+		{"last second of the step after", end.Add(Skew * Period), true},
+		{"first second of the step before", start.Add(-Skew * Period), true},
+		{"two steps later", end.Add(Skew*Period + time.Second), false},
+		{"two steps earlier", start.Add(-Skew*Period - time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, ok := Validate(rfcSecret, code, tt.at)
+
+			if ok != tt.ok {
+				t.Fatalf("Got %v, want %v", ok, tt.ok)
+			}
+
+			if ok && matched != counter {
+				t.Errorf("Matched step %d, want %d", matched, counter)
+			}
+		})
+	}
+}
+
+func TestValidateFormatting(t *testing.T) {
+	now := time.Now()
+	code, _ := Code(rfcSecret, Counter(now))
+
+	if _, ok := Validate(rfcSecret, code[:3]+" "+code[3:], now); !ok {
+		t.Error("Code with a space was rejected")
+	}
+
+	for _, bad := range []string{"", code[:5], code + "0"} {
+		if _, ok := Validate(rfcSecret, bad, now); ok {
+			t.Errorf("Code %q was accepted", bad)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+
+	if err != nil {
+		t.Fatalf("Error generating secret: %v", err)
+	}
+
+	// 160 bits are 32 base32 characters
+	if len(secret) != 32 {
+		t.Errorf("Got a %d character secret, want 32", len(secret))
+	}
+
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("Generated secret is unusable: %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Go Gym", "alice", rfcSecret)
+
+	for _, part := range []string{"otpauth://totp/Go%20Gym:alice?", "secret=" + rfcSecret, "issuer=Go+Gym", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI %s is missing %s", uri, part)
+		}
+	}
+}
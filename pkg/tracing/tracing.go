@@ -0,0 +1,78 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported with
+// OTLP over HTTP, printed to stdout for local use, or dropped.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+
+	"github.com/grez-lucas/go-gym/pkg/config"
+)
+
+// Flushes pending spans and stops the exporter
+type ShutdownFunc func(context.Context) error
+
+// Installs the global tracer provider and W3C trace context propagation.
+// With the `none` exporter spans are still created, so trace IDs propagate
+// and show up in logs, they just aren't sent anywhere.
+func Setup(ctx context.Context, cfg *config.Config) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("go-gym")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	}
+
+	switch cfg.TracingExporter {
+	case "otlp":
+		// Endpoint, headers and TLS come from the standard
+		// OTEL_EXPORTER_OTLP_* variables
+		exporter, err := otlptracehttp.New(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case "stdout", "console":
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	case "none", "":
+	default:
+		return nil, fmt.Errorf("Unknown traces exporter `%s`, use `otlp`, `stdout` or `none`", cfg.TracingExporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,312 @@
+// Package validation checks request structs against the rules in their
+// `validate` struct tags, like
+//
+//	UserName string `json:"userName" validate:"required,min=3,max=100,username"`
+//
+// Rules are separated by commas. Fields without `required` are only checked
+// when set, so optional fields can still carry rules. A non-nil pointer is
+// always set, even to a blank string. Numbers can't be unset unless they're
+// pointers, so a zero number goes through min and max like any other. Nested structs are validated too, their fields named `parent.child`.
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type FieldError struct {
+	// The JSON name of the field
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Every rule that failed, in field order
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+
+	for _, fieldErr := range e {
+		messages = append(messages, fmt.Sprintf("%s %s", fieldErr.Field, fieldErr.Message))
+	}
+
+	return "Invalid request: " + strings.Join(messages, ", ")
+}
+
+// Validates the struct `v` points to. Returns Errors when any rule fails.
+func Validate(v any) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	errs := validateStruct(value, "")
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateStruct(value reflect.Value, prefix string) Errors {
+	var errs Errors
+
+	valueType := value.Type()
+
+	for i := range valueType.NumField() {
+		field := valueType.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonName(field)
+
+		if tag := field.Tag.Get("validate"); tag != "" {
+			if message := checkField(value.Field(i), strings.Split(tag, ",")); message != "" {
+				errs = append(errs, FieldError{Field: name, Message: message})
+				continue
+			}
+		}
+
+		if nested := reflect.Indirect(value.Field(i)); nested.Kind() == reflect.Struct {
+			errs = append(errs, validateStruct(nested, name+".")...)
+		}
+	}
+
+	return errs
+}
+
+// Returns the message of the first rule the field breaks
+func checkField(value reflect.Value, rules []string) string {
+	required := slices.Contains(rules, "required")
+	pointer := value.Kind() == reflect.Pointer
+
+	if pointer {
+		if value.IsNil() {
+			if required {
+				return "is required"
+			}
+
+			return ""
+		}
+
+		value = value.Elem()
+	}
+
+	if isUnset(value) {
+		if required {
+			return "is required"
+		}
+
+		// `"userName": "  "` is a value the rules have to judge, only a
+		// missing field is left alone
+		if !pointer {
+			return ""
+		}
+	}
+
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+
+		check, ok := ruleFuncs[name]
+
+		if !ok {
+			panic(fmt.Sprintf("validation: unknown rule `%s`", name))
+		}
+
+		if message := check(value, param); message != "" {
+			return message
+		}
+	}
+
+	// A zero number the other rules let through
+	if required && value.IsZero() {
+		return "is required"
+	}
+
+	return ""
+}
+
+type rule func(value reflect.Value, param string) string
+
+var ruleFuncs = map[string]rule{
+	// Checked in checkField
+	"required": func(reflect.Value, string) string { return "" },
+	"min":      checkMin,
+	"max":      checkMax,
+	"username": checkUsername,
+	"email":    checkEmail,
+	"password": checkPassword,
+	"future":   checkFuture,
+}
+
+// Strings are measured in characters, slices in items
+func size(value reflect.Value) (int64, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return int64(utf8.RuneCountInString(value.String())), true
+	case reflect.Slice, reflect.Map:
+		return int64(value.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint()), true
+	}
+
+	return 0, false
+}
+
+func checkMin(value reflect.Value, param string) string {
+	limit := mustParseInt(param)
+	n, _ := size(value)
+
+	if n >= limit {
+		return ""
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be at least %d characters long", limit)
+	case reflect.Slice, reflect.Map:
+		return fmt.Sprintf("must have at least %d items", limit)
+	}
+
+	return fmt.Sprintf("must be at least %d", limit)
+}
+
+func checkMax(value reflect.Value, param string) string {
+	limit := mustParseInt(param)
+	n, _ := size(value)
+
+	if n <= limit {
+		return ""
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return fmt.Sprintf("must be at most %d characters long", limit)
+	case reflect.Slice, reflect.Map:
+		return fmt.Sprintf("must have at most %d items", limit)
+	}
+
+	return fmt.Sprintf("must be at most %d", limit)
+}
+
+var usernameChars = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+func checkUsername(value reflect.Value, _ string) string {
+	if !usernameChars.MatchString(value.String()) {
+		return "may only contain letters, digits, `_`, `.` and `-`"
+	}
+
+	return ""
+}
+
+func checkEmail(value reflect.Value, _ string) string {
+	if !IsEmail(value.String()) {
+		return "must be a valid email address"
+	}
+
+	return ""
+}
+
+// bcrypt ignores anything past 72 bytes
+const maxPasswordBytes = 72
+
+// At least 8 characters, mixing letters with digits or symbols
+func checkPassword(value reflect.Value, _ string) string {
+	password := value.String()
+
+	if utf8.RuneCountInString(password) < 8 {
+		return "must be at least 8 characters long"
+	}
+
+	if len(password) > maxPasswordBytes {
+		return fmt.Sprintf("must be at most %d bytes long", maxPasswordBytes)
+	}
+
+	var letters, others bool
+
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			letters = true
+		} else {
+			others = true
+		}
+	}
+
+	if !letters || !others {
+		return "must mix letters with digits or symbols"
+	}
+
+	return ""
+}
+
+func checkFuture(value reflect.Value, _ string) string {
+	t, ok := value.Interface().(time.Time)
+
+	if !ok {
+		panic(fmt.Sprintf("validation: `future` needs a time.Time, got %s", value.Type()))
+	}
+
+	if !t.After(time.Now()) {
+		return "must be in the future"
+	}
+
+	return ""
+}
+
+// Accepts plain addresses only, no display names
+func IsEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+
+	return err == nil && address.Address == email
+}
+
+// Blank strings and empty collections. Numbers never are, 0 is left to the
+// rules.
+func isUnset(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return false
+	}
+
+	return value.IsZero()
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+	if name == "" || name == "-" {
+		return field.Name
+	}
+
+	return name
+}
+
+func mustParseInt(param string) int64 {
+	n, err := strconv.ParseInt(param, 10, 64)
+
+	if err != nil {
+		panic(fmt.Sprintf("validation: invalid rule parameter `%s`", param))
+	}
+
+	return n
+}
@@ -0,0 +1,167 @@
+package validation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type address struct {
+	City string `json:"city" validate:"required,max=20"`
+}
+
+type request struct {
+	Name     string     `json:"name" validate:"required,min=3,max=10,username"`
+	Email    string     `json:"email" validate:"email"`
+	Password string     `json:"password" validate:"password"`
+	Rating   int        `json:"rating" validate:"required,min=1,max=5"`
+	Count    int        `json:"count" validate:"max=3"`
+	Tags     []string   `json:"tags" validate:"max=2"`
+	Nickname *string    `json:"nickname" validate:"min=2"`
+	Age      *int       `json:"age" validate:"required,min=18"`
+	Home     address    `json:"home"`
+	Work     *address   `json:"work"`
+	Notes    string     `json:"-" validate:"max=5"`
+	Expires  *time.Time `json:"expires" validate:"future"`
+}
+
+func validRequest() request {
+	age := 30
+
+	return request{
+		Name:   "alice",
+		Rating: 3,
+		Age:    &age,
+		Home:   address{City: "Lisbon"},
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(r *request)
+		// Field and message of the only expected error, empty when valid
+		field   string
+		message string
+	}{
+		{"valid", func(r *request) {}, "", ""},
+		{"required string missing", func(r *request) { r.Name = "" }, "name", "is required"},
+		{"required string blank", func(r *request) { r.Name = "   " }, "name", "is required"},
+		{"min string", func(r *request) { r.Name = "al" }, "name", "must be at least 3 characters long"},
+		{"max string", func(r *request) { r.Name = "abcdefghijk" }, "name", "must be at most 10 characters long"},
+		{"username", func(r *request) { r.Name = "al ice" }, "name", "may only contain letters, digits, `_`, `.` and `-`"},
+		{"email", func(r *request) { r.Email = "alice" }, "email", "must be a valid email address"},
+		{"email with display name", func(r *request) { r.Email = "Alice <alice@example.com>" }, "email", "must be a valid email address"},
+		{"optional email unset", func(r *request) { r.Email = "" }, "", ""},
+		{"valid email", func(r *request) { r.Email = "alice@example.com" }, "", ""},
+		{"password too short", func(r *request) { r.Password = "a1" }, "password", "must be at least 8 characters long"},
+		{"password too long", func(r *request) { r.Password = strings.Repeat("a1", 37) }, "password", "must be at most 72 bytes long"},
+		{"password letters only", func(r *request) { r.Password = "abcdefgh" }, "password", "must mix letters with digits or symbols"},
+		{"password digits only", func(r *request) { r.Password = "12345678" }, "password", "must mix letters with digits or symbols"},
+		{"password", func(r *request) { r.Password = "correct horse" }, "", ""},
+		{"zero number under min", func(r *request) { r.Rating = 0 }, "rating", "must be at least 1"},
+		{"number under min", func(r *request) { r.Rating = -1 }, "rating", "must be at least 1"},
+		{"number over max", func(r *request) { r.Rating = 6 }, "rating", "must be at most 5"},
+		{"optional zero number", func(r *request) { r.Count = 0 }, "", ""},
+		{"optional number over max", func(r *request) { r.Count = 4 }, "count", "must be at most 3"},
+		{"optional slice unset", func(r *request) { r.Tags = []string{} }, "", ""},
+		{"slice over max", func(r *request) { r.Tags = []string{"a", "b", "c"} }, "tags", "must have at most 2 items"},
+		{"optional pointer nil", func(r *request) { r.Nickname = nil }, "", ""},
+		{"optional pointer under min", func(r *request) { r.Nickname = ptr("a") }, "nickname", "must be at least 2 characters long"},
+		{"optional pointer empty", func(r *request) { r.Nickname = ptr("") }, "nickname", "must be at least 2 characters long"},
+		{"optional pointer blank", func(r *request) { r.Nickname = ptr(" ") }, "nickname", "must be at least 2 characters long"},
+		{"required pointer nil", func(r *request) { r.Age = nil }, "age", "is required"},
+		{"required pointer zero", func(r *request) { r.Age = ptr(0) }, "age", "must be at least 18"},
+		{"nested", func(r *request) { r.Home.City = "" }, "home.city", "is required"},
+		{"nested max", func(r *request) { r.Home.City = strings.Repeat("a", 21) }, "home.city", "must be at most 20 characters long"},
+		{"nested pointer nil", func(r *request) { r.Work = nil }, "", ""},
+		{"nested pointer", func(r *request) { r.Work = &address{} }, "work.city", "is required"},
+		{"optional time nil", func(r *request) { r.Expires = nil }, "", ""},
+		{"time in the future", func(r *request) { r.Expires = ptr(time.Now().Add(time.Hour)) }, "", ""},
+		{"time in the past", func(r *request) { r.Expires = ptr(time.Now().Add(-time.Hour)) }, "expires", "must be in the future"},
+		{"zero time", func(r *request) { r.Expires = &time.Time{} }, "expires", "must be in the future"},
+		{"go name without json name", func(r *request) { r.Notes = "abcdef" }, "Notes", "must be at most 5 characters long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := validRequest()
+			tt.modify(&r)
+
+			err := Validate(&r)
+
+			if tt.field == "" {
+				if err != nil {
+					t.Fatalf("Got %v, want no error", err)
+				}
+
+				return
+			}
+
+			var errs Errors
+
+			if !errors.As(err, &errs) {
+				t.Fatalf("Got %v, want Errors", err)
+			}
+
+			want := FieldError{Field: tt.field, Message: tt.message}
+
+			if len(errs) != 1 || errs[0] != want {
+				t.Errorf("Got %+v, want [%+v]", errs, want)
+			}
+		})
+	}
+}
+
+// Without other rules, a zero number still counts as missing
+func TestRequiredZeroNumber(t *testing.T) {
+	v := struct {
+		ID int `json:"id" validate:"required"`
+	}{}
+
+	var errs Errors
+
+	if err := Validate(&v); !errors.As(err, &errs) || errs[0] != (FieldError{Field: "id", Message: "is required"}) {
+		t.Errorf("Got %v, want id is required", err)
+	}
+}
+
+// Every failing field is reported, in field order
+func TestValidateCollectsErrors(t *testing.T) {
+	r := validRequest()
+	r.Name = ""
+	r.Rating = 9
+
+	err := Validate(&r)
+
+	want := "Invalid request: name is required, rating must be at most 5"
+
+	if err == nil || err.Error() != want {
+		t.Errorf("Got %v, want %q", err, want)
+	}
+}
+
+func TestValidateNonStruct(t *testing.T) {
+	n := 5
+
+	if err := Validate(&n); err != nil {
+		t.Errorf("Got %v, want nil", err)
+	}
+}
+
+func TestUnknownRulePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Unknown rule didn't panic")
+		}
+	}()
+
+	Validate(&struct {
+		Name string `validate:"nope"`
+	}{Name: "alice"})
+}